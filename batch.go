@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ------------------------------------------------------------------------
+// Batch plate lookup
+// ------------------------------------------------------------------------
+
+const (
+	maxBatchSize            = 100
+	defaultBatchConcurrency = 4
+)
+
+// batchItem is one requested plate. JSON input accepts either a plain string
+// or an object using the same field names as /checkplate.
+type batchItem struct {
+	Plate       string `json:"bienso"`
+	VehicleType string `json:"loaixe"`
+}
+
+func (b *batchItem) UnmarshalJSON(raw []byte) error {
+	var plate string
+	if err := json.Unmarshal(raw, &plate); err == nil {
+		b.Plate = plate
+		return nil
+	}
+
+	type plain batchItem
+	return json.Unmarshal(raw, (*plain)(b))
+}
+
+type batchResult struct {
+	Index       int         `json:"index"`
+	Input       string      `json:"input"`
+	Plate       string      `json:"plate,omitempty"`
+	VehicleType string      `json:"vehicle_type,omitempty"`
+	Status      string      `json:"status"` // "ok" or "error"
+	Data        []*CsgtData `json:"data"`
+	Error       string      `json:"error,omitempty"`
+	Code        string      `json:"code,omitempty"` // see errors.go
+}
+
+type batchResponse struct {
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+type batchAPI struct {
+	lookup      func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	concurrency int
+}
+
+func newBatchAPI(concurrency int) *batchAPI {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &batchAPI{lookup: lookupViolations, concurrency: concurrency}
+}
+
+// checkPlateBatchHandler handles POST /checkplate/batch. The body is a JSON
+// array of plates, or a CSV file (raw body with Content-Type text/csv, or the
+// "file" field of a multipart form) with a "bienso" column and an optional
+// "loaixe" column. Results are JSON, or CSV/XLSX (see negotiateFormat).
+func (api *batchAPI) checkPlateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+		return
+	}
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := parseBatchRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Batch is empty")
+		return
+	}
+	if len(items) > maxBatchSize {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Batch too large: %d plates (max %d)", len(items), maxBatchSize))
+		return
+	}
+	if err := chargeLookups(r.Context(), len(items)-1); err != nil {
+		writeRequestError(w, r, http.StatusTooManyRequests, err)
+		return
+	}
+
+	resp := api.run(r.Context(), items)
+	if format != formatJSON {
+		writeTable(w, format, "batch", batchTable(resp))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// run validates every item, then looks up the valid ones with at most
+// api.concurrency lookups in flight. Results keep the input order.
+func (api *batchAPI) run(ctx context.Context, items []batchItem) *batchResponse {
+	results, vehicleCodes, pending := prepareBatch(items)
+
+	forEachBounded(len(pending), api.concurrency, func(n int) {
+		i := pending[n]
+		data, err := api.lookup(ctx, results[i].Plate, vehicleCodes[i])
+		results[i].complete(data, err)
+	})
+
+	return summarizeBatch(results)
+}
+
+// prepareBatch validates every item. Invalid ones get an error result right
+// away; the indexes of the valid ones are returned in pending, with their
+// csgt.vn vehicle codes in vehicleCodes.
+func prepareBatch(items []batchItem) (results []batchResult, vehicleCodes []string, pending []int) {
+	results = make([]batchResult, len(items))
+	vehicleCodes = make([]string, len(items))
+
+	for i, item := range items {
+		res := batchResult{Index: i, Input: item.Plate}
+
+		vehicleType := strings.ToLower(strings.TrimSpace(item.VehicleType))
+		if vehicleType == "" {
+			vehicleType = "oto"
+		}
+		vehicleCode, err := vehicleCodeFor(vehicleType)
+		if err == nil {
+			res.VehicleType = vehicleType
+			res.Plate, err = processPlate(item.Plate)
+		}
+		if err != nil {
+			res.Status = "error"
+			res.Error = err.Error()
+			res.Code = errorCode(err, http.StatusBadRequest)
+		} else {
+			vehicleCodes[i] = vehicleCode
+			pending = append(pending, i)
+		}
+		results[i] = res
+	}
+	return results, vehicleCodes, pending
+}
+
+// complete records the outcome of the lookup for one item.
+func (res *batchResult) complete(data []*CsgtData, err error) {
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		res.Code = errorCode(err, http.StatusBadGateway)
+		return
+	}
+	if data == nil {
+		data = []*CsgtData{}
+	}
+	res.Status = "ok"
+	res.Data = data
+}
+
+func summarizeBatch(results []batchResult) *batchResponse {
+	resp := &batchResponse{Total: len(results), Results: results}
+	for _, res := range results {
+		if res.Status == "ok" {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+// forEachBounded calls fn(0..count-1) with at most limit calls running at once
+// and returns when all of them are done.
+func forEachBounded(count, limit int, fn func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func parseBatchRequest(r *http.Request) ([]batchItem, error) {
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errors.New("Missing CSV file field: file")
+		}
+		defer file.Close()
+		return parseBatchCSV(file)
+	case strings.HasPrefix(contentType, "text/csv"):
+		return parseBatchCSV(r.Body)
+	default:
+		var items []batchItem
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			return nil, fmt.Errorf("Failed to parse JSON body (expected an array of plates): %v", err)
+		}
+		return items, nil
+	}
+}
+
+// parseBatchCSV reads a CSV with a header row. The plate column may be named
+// "bienso" or "plate", the vehicle type column "loaixe" or "vehicle_type".
+func parseBatchCSV(r io.Reader) ([]batchItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	plateCol, typeCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "bienso", "plate":
+			plateCol = i
+		case "loaixe", "vehicle_type":
+			typeCol = i
+		}
+	}
+	if plateCol < 0 {
+		return nil, errors.New("CSV header must contain a \"bienso\" column")
+	}
+
+	var items []batchItem
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var item batchItem
+		if plateCol < len(record) {
+			item.Plate = strings.TrimSpace(record[plateCol])
+		}
+		if typeCol >= 0 && typeCol < len(record) {
+			item.VehicleType = strings.TrimSpace(record[typeCol])
+		}
+		if item.Plate == "" && item.VehicleType == "" {
+			continue // blank line
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
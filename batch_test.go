@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func postBatch(t *testing.T, api *batchAPI, contentType string, body []byte) (int, *batchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/checkplate/batch", bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	api.checkPlateBatchHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	var resp batchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not parse response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, &resp
+}
+
+func TestBatch_JSON(t *testing.T) {
+	api := newBatchAPI(2)
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		switch plate {
+		case "51K12345":
+			return []*CsgtData{{Plate: plate, VehicleType: vehicleCode}}, nil
+		case "30A99999":
+			return nil, errors.New("connection error")
+		}
+		return nil, nil
+	}
+
+	body := `["51K-123.45", {"bienso":"98E1-714.78","loaixe":"xemay"}, "abc", {"bienso":"30A99999","loaixe":"tau"}, "30A-999.99"]`
+	code, resp := postBatch(t, api, "application/json", []byte(body))
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if resp.Total != 5 || resp.Succeeded != 2 || resp.Failed != 3 {
+		t.Fatalf("Unexpected totals: %+v", resp)
+	}
+
+	r := resp.Results
+	if r[0].Status != "ok" || r[0].Plate != "51K12345" || len(r[0].Data) != 1 {
+		t.Errorf("Unexpected result 0: %+v", r[0])
+	}
+	if r[1].Status != "ok" || r[1].VehicleType != "xemay" || r[1].Data == nil {
+		t.Errorf("Unexpected result 1: %+v", r[1])
+	}
+	if r[2].Status != "error" || !strings.Contains(r[2].Error, "invalid plate number format") {
+		t.Errorf("Expected plate validation error, got %+v", r[2])
+	}
+	if r[3].Status != "error" || !strings.Contains(r[3].Error, "Invalid vehicle type") {
+		t.Errorf("Expected vehicle type error, got %+v", r[3])
+	}
+	if r[4].Status != "error" || r[4].Error != "connection error" {
+		t.Errorf("Expected lookup error, got %+v", r[4])
+	}
+}
+
+func TestBatch_CSVUpload(t *testing.T) {
+	api := newBatchAPI(4)
+	api.lookup = stubLookup(nil)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "plates.csv")
+	fw.Write([]byte("bienso,loaixe\n51K-123.45,\n98E1-714.78,xemay\n\n"))
+	mw.Close()
+
+	code, resp := postBatch(t, api, mw.FormDataContentType(), buf.Bytes())
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if resp.Total != 2 || resp.Succeeded != 2 {
+		t.Fatalf("Unexpected totals: %+v", resp)
+	}
+	if resp.Results[1].VehicleType != "xemay" {
+		t.Errorf("Expected xemay from CSV, got %+v", resp.Results[1])
+	}
+
+	// Raw text/csv body works too
+	code, resp = postBatch(t, api, "text/csv", []byte("plate\n51K12345\n"))
+	if code != http.StatusOK || resp.Total != 1 {
+		t.Fatalf("Expected 1 result from raw CSV, got %d %+v", code, resp)
+	}
+}
+
+func TestBatch_BoundedConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	api := newBatchAPI(3)
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return nil, nil
+	}
+
+	var plates []string
+	for i := 0; i < 12; i++ {
+		plates = append(plates, "51K1234"+string(rune('0'+i%10)))
+	}
+	body, _ := json.Marshal(plates)
+
+	_, resp := postBatch(t, api, "", body)
+	if resp.Succeeded != 12 {
+		t.Fatalf("Expected 12 successes, got %+v", resp)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("Expected at most 3 concurrent lookups, saw %d", maxInFlight)
+	}
+}
+
+func TestBatch_Limits(t *testing.T) {
+	api := newBatchAPI(1)
+	api.lookup = stubLookup(nil)
+
+	if code, _ := postBatch(t, api, "", []byte(`[]`)); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty batch, got %d", code)
+	}
+	if code, _ := postBatch(t, api, "", []byte(`{"bienso":"51K12345"}`)); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for non-array body, got %d", code)
+	}
+
+	plates := make([]string, maxBatchSize+1)
+	for i := range plates {
+		plates[i] = "51K12345"
+	}
+	body, _ := json.Marshal(plates)
+	if code, _ := postBatch(t, api, "", body); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for oversized batch, got %d", code)
+	}
+}
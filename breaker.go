@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Circuit breakers per upstream source
+// ------------------------------------------------------------------------
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// breakerConfig is the breaker.<name>.* settings of a source.
+type breakerConfig struct {
+	Failures int
+	Cooldown time.Duration
+}
+
+// ErrCircuitOpen is returned without calling an upstream whose breaker is
+// open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// circuitOpenError is ErrCircuitOpen with the cooldown the breaker had left,
+// i.e. until the RetryAt of its status, for the Retry-After of the answer.
+type circuitOpenError struct {
+	retryIn time.Duration
+}
+
+func (e *circuitOpenError) Error() string { return ErrCircuitOpen.Error() }
+func (e *circuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+// circuitBreaker stops calling a source after failures consecutive upstream
+// failures. After cooldown one probe call is let through (half-open): its
+// success closes the breaker, its failure opens it again.
+type circuitBreaker struct {
+	source   string
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	state       string
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+	lastError   string
+}
+
+func newCircuitBreaker(source string, failures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{source: source, failures: failures, cooldown: cooldown, now: time.Now, state: breakerClosed}
+}
+
+// allow reports whether a call may go ahead, moving an open breaker whose
+// cool-down is over to half-open. A caller that was allowed must report the
+// outcome with done.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+		log.Printf("Circuit breaker for %s is half-open, probing\n", b.source)
+	}
+	switch {
+	case b.state == breakerOpen:
+		retryIn := b.openedAt.Add(b.cooldown).Sub(b.now())
+		return markError(ErrUpstreamUnavailable, fmt.Errorf("%s: %w (retry in %s)", b.source, &circuitOpenError{retryIn}, retryIn.Round(time.Second)))
+	case b.state == breakerHalfOpen && b.probing:
+		return markError(ErrUpstreamUnavailable, fmt.Errorf("%s: %w (probe in progress)", b.source, ErrCircuitOpen))
+	case b.state == breakerHalfOpen:
+		b.probing = true
+	}
+	return nil
+}
+
+// done records the outcome of an allowed call. Only upstream failures (see
+// isUpstreamFailure) count against the source; a call aborted by its caller
+// counts for nothing.
+func (b *circuitBreaker) done(err error, aborted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == breakerHalfOpen && b.probing
+	b.probing = false
+	switch {
+	case aborted:
+		return
+	case err != nil && isUpstreamFailure(err):
+		b.consecutive++
+		b.lastError = err.Error()
+		if wasProbe || b.consecutive >= b.failures {
+			if b.state != breakerOpen {
+				log.Printf("Circuit breaker for %s opened after %d failure(s): %v\n", b.source, b.consecutive, err)
+			}
+			b.state = breakerOpen
+			b.openedAt = b.now()
+		}
+	default:
+		if b.state != breakerClosed {
+			log.Printf("Circuit breaker for %s closed\n", b.source)
+		}
+		b.state = breakerClosed
+		b.consecutive = 0
+	}
+}
+
+// isUpstreamFailure tells failures of the source itself (unreachable, 5xx,
+// timeouts) from answers that prove it works, such as "no data", a rejected
+// captcha or an invalid plate. Our own rate limit giving up is not the
+// source's fault either.
+func isUpstreamFailure(err error) bool {
+	if errors.Is(err, ErrUpstreamBusy) {
+		return false
+	}
+	_, isNet := asNetError(err)
+	return errors.Is(err, ErrUpstreamUnavailable) || isNet
+}
+
+// breakerStatus is one source in GET /v1/status/upstreams.
+type breakerStatus struct {
+	Source              string     `json:"source"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	FailureThreshold    int        `json:"failure_threshold"`
+	Cooldown            string     `json:"cooldown"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"` // when an open breaker lets a probe through
+	LastError           string     `json:"last_error,omitempty"`
+
+	RateLimit *limiterStatus `json:"rate_limit,omitempty"`
+}
+
+func (b *circuitBreaker) status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := breakerStatus{
+		Source:              b.source,
+		State:               b.state,
+		ConsecutiveFailures: b.consecutive,
+		FailureThreshold:    b.failures,
+		Cooldown:            b.cooldown.String(),
+		LastError:           b.lastError,
+	}
+	if b.state != breakerClosed {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.cooldown)
+		st.OpenedAt, st.RetryAt = &openedAt, &retryAt
+	}
+	return st
+}
+
+// withBreaker runs op unless source's breaker is open, and records its
+// outcome unless ctx was done by then. Sources without a breaker always run.
+func withBreaker(ctx context.Context, source string, op func() error) error {
+	b := breakers[source]
+	if b == nil {
+		return op()
+	}
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := op()
+	b.done(err, ctx.Err() != nil)
+	return err
+}
+
+// breakers holds the breaker of each lookup source; main applies the
+// breaker.* settings.
+var breakers = newBreakers(func(string) (int, time.Duration) {
+	return defaultBreakerFailures, defaultBreakerCooldown
+})
+
+func newBreakers(settings func(source string) (failures int, cooldown time.Duration)) map[string]*circuitBreaker {
+	m := make(map[string]*circuitBreaker)
+	for _, source := range []string{sourcePrimary, sourceCSGT} {
+		failures, cooldown := settings(source)
+		m[source] = newCircuitBreaker(source, failures, cooldown)
+	}
+	return m
+}
+
+// ---- status endpoint ----
+
+func registerStatusRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/status/upstreams", getUpstreamStatus)
+}
+
+// getUpstreamStatus handles GET /v1/status/upstreams.
+func getUpstreamStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]breakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		st := b.status()
+		if l := upstream.Limiter(st.Source); l != nil {
+			st.RateLimit = l.status()
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Source < statuses[j].Source })
+	writeEnvelope(w, http.StatusOK, statuses, nil)
+}
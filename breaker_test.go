@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBreaker_OpensProbesAndCloses(t *testing.T) {
+	now := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(sourceCSGT, 3, 30*time.Second)
+	b.now = func() time.Time { return now }
+	down := markError(ErrUpstreamUnavailable, errors.New("server returned status code: 503"))
+
+	// Answers that prove the source works never count, nor do our own
+	// file system errors
+	saveFailed := fmt.Errorf("failed to write file: %w", &fs.PathError{Op: "open", Path: "x", Err: syscall.ENOSPC})
+	for _, err := range []error{ErrDataNotFound, ErrCaptchaRejected, saveFailed, down, down} {
+		if b.allow() != nil {
+			t.Fatal("Expected a closed breaker")
+		}
+		b.done(err, false)
+	}
+	if st := b.status(); st.State != breakerClosed || st.ConsecutiveFailures != 2 {
+		t.Fatalf("Expected closed with 2 failures, got %+v", st)
+	}
+	b.allow()
+	b.done(down, true) // aborted by the caller
+	b.allow()
+	b.done(down, false)
+
+	err := b.allow()
+	if !errors.Is(err, ErrCircuitOpen) || errorCode(err, http.StatusBadGateway) != codeUpstreamUnavailable {
+		t.Fatalf("Expected an open breaker to reject calls, got %v", err)
+	}
+	if st := b.status(); st.State != breakerOpen || st.RetryAt == nil || !st.RetryAt.Equal(now.Add(30*time.Second)) {
+		t.Errorf("Unexpected status %+v", st)
+	}
+
+	// Clients are told to come back when the cool-down ends
+	now = now.Add(10*time.Second + 500*time.Millisecond)
+	if _, e := apiErrorFor(b.allow(), http.StatusBadGateway); e.RetryAfter != 20 {
+		t.Errorf("Expected Retry-After of the remaining 20s, got %+v", e)
+	}
+	now = now.Add(-10*time.Second - 500*time.Millisecond)
+
+	// After the cool-down a single probe goes through; its failure reopens
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a probe, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected only one probe at a time, got %v", err)
+	}
+	b.done(down, false)
+	if st := b.status(); st.State != breakerOpen || !st.OpenedAt.Equal(now) {
+		t.Fatalf("Expected the failed probe to reopen the breaker, got %+v", st)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.done(nil, false)
+	if st := b.status(); st.State != breakerClosed || st.ConsecutiveFailures != 0 || st.OpenedAt != nil {
+		t.Errorf("Expected the probe to close the breaker, got %+v", st)
+	}
+}
+
+func TestBreaker_ChainSkipsOpenSources(t *testing.T) {
+	saved := breakers
+	t.Cleanup(func() { breakers = saved })
+	breakers = newBreakers(func(string) (int, time.Duration) { return 1, time.Hour })
+	for _, b := range breakers {
+		b.allow()
+		b.done(markError(ErrUpstreamUnavailable, errors.New("down")), false)
+	}
+
+	var stages []string
+	start := time.Now()
+	_, _, err := lookupViolationsFrom(context.Background(), "51K12345", "1", func(ev lookupEvent) {
+		stages = append(stages, ev.Stage)
+	})
+	if !errors.Is(err, ErrCircuitOpen) || time.Since(start) > time.Second {
+		t.Fatalf("Expected a fast circuit-open error, got %v after %s", err, time.Since(start))
+	}
+	if len(stages) != 2 || stages[1] != stagePrimaryUnavailable {
+		t.Errorf("Expected the chain to move on to csgt.vn, got stages %v", stages)
+	}
+
+	var env struct {
+		Data []breakerStatus `json:"data"`
+	}
+	mux := http.NewServeMux()
+	registerStatusRoutes(mux)
+	if code := doJSON(t, mux, "GET", "/v1/status/upstreams", "", &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(env.Data) != 2 || env.Data[0].Source != sourcePrimary || env.Data[1].State != breakerOpen {
+		t.Errorf("Unexpected status %+v", env.Data)
+	}
+}
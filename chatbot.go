@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ------------------------------------------------------------------------
+// Chat commands shared by the messenger front-ends (Telegram, Zalo)
+// ------------------------------------------------------------------------
+
+// chatMaxBodyBytes caps the webhook bodies of the messenger front-ends. An
+// update carrying a text message is a few kilobytes.
+const chatMaxBodyBytes = 1 << 20
+
+// chatCommands turns incoming chat text into replies: a plate lookup, or one
+// of the subscription commands. Each front-end owns one, keyed by its channel
+// name in the subscription store.
+type chatCommands struct {
+	channel string
+	subs    *subscriptionStore
+	lookup  func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	limiter *clientLimiter // meters lookups per recipient; nil means unlimited
+}
+
+func newChatCommands(channel string, subs *subscriptionStore) chatCommands {
+	return chatCommands{channel: channel, subs: subs, lookup: lookupViolations}
+}
+
+// replyTo builds the answer to one incoming message from a recipient. ctx
+// bounds the lookups it runs.
+func (c *chatCommands) replyTo(ctx context.Context, recipient, text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return c.replyLookup(ctx, recipient, text)
+	}
+
+	// "/subscribe@MyBot 51K12345" => command "/subscribe", args "51K12345"
+	fields := strings.Fields(text)
+	command := strings.ToLower(fields[0])
+	if i := strings.Index(command, "@"); i >= 0 {
+		command = command[:i]
+	}
+	args := strings.Join(fields[1:], " ")
+
+	switch command {
+	case "/start", "/help":
+		return botHelpText
+	case "/subscribe":
+		return c.replySubscribe(ctx, recipient, args)
+	case "/unsubscribe":
+		return c.replyUnsubscribe(recipient, args)
+	case "/list":
+		return c.replyList(recipient)
+	default:
+		return "Lệnh không hợp lệ.\n\n" + botHelpText
+	}
+}
+
+func (c *chatCommands) replyLookup(ctx context.Context, recipient, query string) string {
+	plate, vehicleCode, err := parsePlateQuery(query)
+	if err != nil {
+		return err.Error() + "\n\n" + botHelpText
+	}
+	if !c.allow(ctx, recipient) {
+		return chatRateLimitedText
+	}
+
+	data, err := c.lookup(ctx, plate, vehicleCode)
+	if err != nil {
+		log.Printf("%s: lookup for plate %s failed: %v\n", c.channel, plate, err)
+		return fmt.Sprintf("Không tra cứu được biển số %s, vui lòng thử lại sau.", plate)
+	}
+	return formatViolations(plate, data)
+}
+
+func (c *chatCommands) replySubscribe(ctx context.Context, recipient, args string) string {
+	plate, vehicleCode, err := parsePlateQuery(args)
+	if err != nil {
+		return err.Error() + "\n\nCú pháp: /subscribe <biển số> [oto|xemay]"
+	}
+	if !c.allow(ctx, recipient) {
+		return chatRateLimitedText
+	}
+
+	// Record the current state so only later changes are notified. If the
+	// lookup fails the fingerprint stays empty and the watcher will report
+	// whatever it finds on its next run.
+	data, lookupErr := c.lookup(ctx, plate, vehicleCode)
+	fingerprint := ""
+	if lookupErr == nil {
+		fingerprint = violationsFingerprint(data)
+	}
+
+	if err := c.subs.Subscribe(c.channel, recipient, plate, vehicleCode, fingerprint); err != nil {
+		log.Printf("%s: subscribe %s for %s failed: %v\n", c.channel, plate, recipient, err)
+		return "Không lưu được đăng ký, vui lòng thử lại sau."
+	}
+
+	msg := fmt.Sprintf("Đã đăng ký theo dõi biển số %s. Bạn sẽ nhận được thông báo khi có thay đổi.", plate)
+	if lookupErr != nil {
+		return msg
+	}
+	return msg + "\n\n" + formatViolations(plate, data)
+}
+
+const chatRateLimitedText = "Bạn đã tra cứu quá nhiều lần, vui lòng thử lại sau."
+
+// allow charges one lookup to recipient and reports whether it is within the
+// limits.
+func (c *chatCommands) allow(ctx context.Context, recipient string) bool {
+	if c.limiter == nil {
+		return true
+	}
+	if err := c.limiter.chargeChat(ctx, c.channel, recipient); err != nil {
+		log.Printf("%s: %s is over its lookup limit: %v\n", c.channel, recipient, err)
+		return false
+	}
+	return true
+}
+
+func (c *chatCommands) replyUnsubscribe(recipient, args string) string {
+	plate, err := processPlate(args)
+	if err != nil {
+		return err.Error() + "\n\nCú pháp: /unsubscribe <biển số>"
+	}
+
+	removed, err := c.subs.Unsubscribe(c.channel, recipient, plate)
+	if err != nil {
+		log.Printf("%s: unsubscribe %s for %s failed: %v\n", c.channel, plate, recipient, err)
+		return "Không huỷ được đăng ký, vui lòng thử lại sau."
+	}
+	if !removed {
+		return fmt.Sprintf("Bạn chưa đăng ký theo dõi biển số %s.", plate)
+	}
+	return fmt.Sprintf("Đã huỷ theo dõi biển số %s.", plate)
+}
+
+func (c *chatCommands) replyList(recipient string) string {
+	subs := c.subs.List(c.channel, recipient)
+	if len(subs) == 0 {
+		return "Bạn chưa theo dõi biển số nào."
+	}
+
+	lines := []string{"Các biển số đang theo dõi:"}
+	for _, sub := range subs {
+		lines = append(lines, "• "+sub.Plate)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ------------------------------------------------------------------------
+// Message helpers
+// ------------------------------------------------------------------------
+
+const botHelpText = `Gửi biển số xe để tra cứu phạt nguội, ví dụ: 51K-123.45 hoặc 98E1-714.78 xemay
+
+Các lệnh:
+/subscribe <biển số> [oto|xemay] - nhận thông báo khi có vi phạm mới
+/unsubscribe <biển số> - huỷ theo dõi
+/list - các biển số đang theo dõi`
+
+// parsePlateQuery accepts "<plate> [oto|xemay]" in any format processPlate
+// accepts, e.g. "51K-123.45", "51k 12345 xemay".
+func parsePlateQuery(query string) (string, string, error) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "", "", errors.New("please provide a valid plate number")
+	}
+
+	vehicleType := ""
+	if last := strings.ToLower(fields[len(fields)-1]); len(fields) > 1 && (last == "oto" || last == "xemay") {
+		vehicleType = last
+		fields = fields[:len(fields)-1]
+	}
+
+	vehicleCode, err := vehicleCodeFor(vehicleType)
+	if err != nil {
+		return "", "", err
+	}
+	plate, err := processPlate(strings.Join(fields, ""))
+	if err != nil {
+		return "", "", err
+	}
+	return plate, vehicleCode, nil
+}
+
+// formatNotification is the message pushed to subscribers when a plate's
+// violations change.
+func formatNotification(plate string, data []*CsgtData) string {
+	return "🔔 Có thay đổi vi phạm!\n\n" + formatViolations(plate, data)
+}
+
+// formatViolations renders lookup results as plain text for chat messages.
+func formatViolations(plate string, data []*CsgtData) string {
+	if len(data) == 0 {
+		return fmt.Sprintf("✅ Không tìm thấy vi phạm cho biển số %s.", plate)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🚨 Biển số %s có %d vi phạm:\n", plate, len(data))
+	for i, d := range data {
+		fmt.Fprintf(&sb, "\n%d. %s\n", i+1, d.ViolationAction)
+		fmt.Fprintf(&sb, "⏰ Thời gian: %s\n", d.ViolationTime)
+		fmt.Fprintf(&sb, "📍 Địa điểm: %s\n", d.ViolationPlace)
+		fmt.Fprintf(&sb, "📌 Trạng thái: %s\n", d.Status)
+		if d.DetectedBy != "" {
+			fmt.Fprintf(&sb, "👮 Đơn vị phát hiện: %s\n", d.DetectedBy)
+		}
+		if d.ResolutionLocation != "" {
+			fmt.Fprintf(&sb, "🏢 Nơi giải quyết:\n%s\n", d.ResolutionLocation)
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// splitMessage breaks text into chunks of at most limit runes, preferring to
+// cut at line breaks so a violation is not split mid-line.
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		cut := limit
+		for i := limit; i > limit/2; i-- {
+			if runes[i-1] == '\n' {
+				cut = i
+				break
+			}
+		}
+		chunks = append(chunks, strings.TrimRight(string(runes[:cut]), "\n"))
+		runes = runes[cut:]
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ------------------------------------------------------------------------
+// Configuration: defaults < config file < environment < flags
+// ------------------------------------------------------------------------
+
+// appConfig holds the settings of the server.
+type appConfig struct {
+	HTTP     serverConfig
+	GRPCAddr string
+
+	PrimaryURL      string
+	CSGTCaptchaURL  string
+	CSGTLookupURL   string
+	CSGTClientIP    string // sent as ipClient with csgt.vn lookups
+	CaptchaLogDir   string // "" stops saving captcha images
+	CaptchaAttempts int
+	LookupTimeout   time.Duration
+
+	BatchConcurrency int
+	FleetFile        string
+	HistoryFile      string
+	HistoryPerPlate  int
+	JobsFile         string
+	JobRetention     time.Duration
+	JobWorkers       int
+	ReportBrand      string
+
+	OCR       ocrConfig
+	RateLimit rateLimitConfig
+	Chat      chatConfig
+	Upstream  upstreamSettings
+}
+
+// rateLimitConfig configures the inbound limits (see clientLimiter).
+type rateLimitConfig struct {
+	Tiers      string // "name:per_minute:per_day,..."
+	APIKeys    string // "key[:tier],..."
+	Store      string // memory or redis
+	RedisURL   string
+	TrustProxy bool
+}
+
+// chatConfig configures the chat bots and the plate watcher.
+type chatConfig struct {
+	TelegramToken         string
+	TelegramAPIURL        string
+	TelegramWebhookURL    string
+	TelegramWebhookSecret string
+	ZaloToken             string
+	ZaloAPIURL            string
+	ZaloAppID             string
+	ZaloSecretKey         string
+	SubscriptionsFile     string
+	WatchInterval         time.Duration
+}
+
+func defaultAppConfig() *appConfig {
+	return &appConfig{
+		HTTP:             defaultServerConfig,
+		GRPCAddr:         ":9090",
+		PrimaryURL:       primaryLookupURL,
+		CSGTCaptchaURL:   csgtCaptchaURL,
+		CSGTLookupURL:    csgtLookupURL,
+		CSGTClientIP:     csgtClientIP,
+		CaptchaLogDir:    captchaLogDir,
+		CaptchaAttempts:  captchaAttempts,
+		LookupTimeout:    lookupTimeout,
+		BatchConcurrency: defaultBatchConcurrency,
+		FleetFile:        filepath.Join("data", "fleet.json"),
+		HistoryFile:      filepath.Join("data", "history.json"),
+		HistoryPerPlate:  defaultHistoryPerPlate,
+		JobsFile:         filepath.Join("data", "jobs.json"),
+		JobRetention:     24 * time.Hour,
+		JobWorkers:       2,
+		ReportBrand:      defaultReportBrand,
+		OCR:              defaultOCRConfig,
+		RateLimit:        rateLimitConfig{Store: "memory", RedisURL: "redis://localhost:6379/0"},
+		Chat: chatConfig{
+			SubscriptionsFile: filepath.Join("data", "subscriptions.json"),
+			WatchInterval:     6 * time.Hour,
+		},
+		Upstream: defaultUpstreamSettings(),
+	}
+}
+
+// setting binds one field of appConfig to its config file key (also the
+// flag name, with "-" for "." and "_"), its environment variable and help.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	value  configValue
+	secret bool
+	source string // where the value came from, for /debug/config
+}
+
+func (c *appConfig) settings() []*setting {
+	return append([]*setting{
+		{key: "http.addr", env: "HTTP_ADDR", usage: "HTTP listen address", value: stringValue{&c.HTTP.Addr}},
+		{key: "http.read_header_timeout", env: "HTTP_READ_HEADER_TIMEOUT", usage: "time to read request headers", value: durationValue{&c.HTTP.ReadHeaderTimeout}},
+		{key: "http.read_timeout", env: "HTTP_READ_TIMEOUT", usage: "time to read a whole request", value: durationValue{&c.HTTP.ReadTimeout}},
+		{key: "http.write_timeout", env: "HTTP_WRITE_TIMEOUT", usage: "time to write a response; must exceed lookup.timeout", value: durationValue{&c.HTTP.WriteTimeout}},
+		{key: "http.idle_timeout", env: "HTTP_IDLE_TIMEOUT", usage: "keep-alive idle time", value: durationValue{&c.HTTP.IdleTimeout}},
+		{key: "http.max_header_bytes", env: "HTTP_MAX_HEADER_BYTES", usage: "maximum request header size", value: intValue{&c.HTTP.MaxHeaderBytes}},
+		{key: "http.shutdown_timeout", env: "SHUTDOWN_TIMEOUT", usage: "time given to requests and jobs on shutdown", value: durationValue{&c.HTTP.ShutdownTimeout}},
+		{key: "http.tls_cert_file", env: "TLS_CERT_FILE", usage: "TLS certificate, enables HTTPS", value: stringValue{&c.HTTP.TLSCertFile}},
+		{key: "http.tls_key_file", env: "TLS_KEY_FILE", usage: "TLS private key", value: stringValue{&c.HTTP.TLSKeyFile}},
+		{key: "grpc.addr", env: "GRPC_ADDR", usage: "gRPC listen address", value: stringValue{&c.GRPCAddr}},
+
+		{key: "sources.primary_url", env: "PRIMARY_URL", usage: "checkphatnguoi.vn lookup endpoint", value: stringValue{&c.PrimaryURL}},
+		{key: "sources.csgt_captcha_url", env: "CSGT_CAPTCHA_URL", usage: "csgt.vn captcha image", value: stringValue{&c.CSGTCaptchaURL}},
+		{key: "sources.csgt_lookup_url", env: "CSGT_LOOKUP_URL", usage: "csgt.vn lookup form endpoint", value: stringValue{&c.CSGTLookupURL}},
+		{key: "sources.csgt_client_ip", env: "CSGT_CLIENT_IP", usage: "ipClient sent with csgt.vn lookups", value: stringValue{&c.CSGTClientIP}},
+		{key: "captcha.log_dir", env: "CAPTCHA_LOG_DIR", usage: "where captcha images are saved; empty to disable", value: stringValue{&c.CaptchaLogDir}},
+		{key: "captcha.attempts", env: "CAPTCHA_ATTEMPTS", usage: "captchas tried per csgt.vn lookup", value: intValue{&c.CaptchaAttempts}},
+		{key: "lookup.timeout", env: "LOOKUP_TIMEOUT", usage: "time limit of one lookup", value: durationValue{&c.LookupTimeout}},
+		{key: "lookup.batch_concurrency", env: "BATCH_CONCURRENCY", usage: "lookups in flight per batch", value: intValue{&c.BatchConcurrency}},
+
+		{key: "storage.fleet_file", env: "FLEET_FILE", usage: "fleet data file", value: stringValue{&c.FleetFile}},
+		{key: "storage.history_file", env: "HISTORY_FILE", usage: "lookup history file", value: stringValue{&c.HistoryFile}},
+		{key: "storage.history_per_plate", env: "HISTORY_PER_PLATE", usage: "history entries kept per plate", value: intValue{&c.HistoryPerPlate}},
+		{key: "storage.jobs_file", env: "JOBS_FILE", usage: "async jobs file", value: stringValue{&c.JobsFile}},
+		{key: "jobs.retention", env: "JOB_RETENTION", usage: "how long finished jobs are kept", value: durationValue{&c.JobRetention}},
+		{key: "jobs.workers", env: "JOB_WORKERS", usage: "jobs run at once", value: intValue{&c.JobWorkers}},
+		{key: "report.brand", env: "REPORT_BRAND", usage: "name printed on PDF reports", value: stringValue{&c.ReportBrand}},
+
+		{key: "ocr.workers", env: "OCR_WORKERS", usage: "OCR workers (Tesseract instances)", value: intValue{&c.OCR.Workers}},
+		{key: "ocr.queue", env: "OCR_QUEUE", usage: "captchas waiting for an OCR worker", value: intValue{&c.OCR.Queue}},
+		{key: "ocr.timeout", env: "OCR_TIMEOUT", usage: "time limit of one captcha, queue included", value: durationValue{&c.OCR.Timeout}},
+
+		{key: "rate_limit.tiers", env: "RATE_LIMIT_TIERS", usage: "extra tiers as name:per_minute:per_day,...", value: stringValue{&c.RateLimit.Tiers}},
+		{key: "rate_limit.api_keys", env: "API_KEYS", usage: "API keys as key[:tier],...", value: stringValue{&c.RateLimit.APIKeys}, secret: true},
+		{key: "rate_limit.store", env: "RATE_LIMIT_STORE", usage: "memory or redis", value: stringValue{&c.RateLimit.Store}},
+		{key: "rate_limit.redis_url", env: "REDIS_URL", usage: "Redis for rate_limit.store=redis", value: urlValue{stringValue{&c.RateLimit.RedisURL}}},
+		{key: "rate_limit.trust_proxy", env: "RATE_LIMIT_TRUST_PROXY", usage: "take client IPs from X-Forwarded-For", value: boolValue{&c.RateLimit.TrustProxy}},
+
+		{key: "telegram.token", env: "TELEGRAM_BOT_TOKEN", usage: "enables the Telegram bot", value: stringValue{&c.Chat.TelegramToken}, secret: true},
+		{key: "telegram.api_url", env: "TELEGRAM_API_URL", usage: "Bot API base URL", value: stringValue{&c.Chat.TelegramAPIURL}},
+		{key: "telegram.webhook_url", env: "TELEGRAM_WEBHOOK_URL", usage: "public URL of /telegram/webhook; polling if empty", value: stringValue{&c.Chat.TelegramWebhookURL}},
+		{key: "telegram.webhook_secret", env: "TELEGRAM_WEBHOOK_SECRET", usage: "secret token sent with webhook calls", value: stringValue{&c.Chat.TelegramWebhookSecret}, secret: true},
+		{key: "zalo.token", env: "ZALO_OA_ACCESS_TOKEN", usage: "enables the Zalo OA webhook", value: stringValue{&c.Chat.ZaloToken}, secret: true},
+		{key: "zalo.api_url", env: "ZALO_API_URL", usage: "Zalo OpenAPI base URL", value: stringValue{&c.Chat.ZaloAPIURL}},
+		{key: "zalo.app_id", env: "ZALO_APP_ID", usage: "app id, part of the webhook signature", value: stringValue{&c.Chat.ZaloAppID}},
+		{key: "zalo.secret_key", env: "ZALO_OA_SECRET_KEY", usage: "OA secret key for webhook signatures", value: stringValue{&c.Chat.ZaloSecretKey}, secret: true},
+		{key: "watch.subscriptions_file", env: "SUBSCRIPTIONS_FILE", usage: "chat subscriptions file", value: stringValue{&c.Chat.SubscriptionsFile}},
+		{key: "watch.interval", env: "WATCH_INTERVAL", usage: "how often subscribed plates are re-checked", value: durationValue{&c.Chat.WatchInterval}},
+	}, c.Upstream.settings()...)
+}
+
+// ---- per-source upstream settings ----
+
+// upstreamSettings tunes the requests to each upstream: upstream.* for all
+// sources, upstream.<name>.* per source, and the retry, breaker and limit
+// settings of the lookup sources. name is the lower-cased NAME of
+// upstreamSources, so upstream.csgt.proxy is read from UPSTREAM_CSGT_PROXY.
+type upstreamSettings struct {
+	Base     upstreamConfig
+	Sources  map[string]*upstreamConfig
+	Retry    map[string]*retryPolicy
+	Breakers map[string]*breakerConfig
+	Limits   map[string]*limitConfig
+}
+
+// upstreamSourceOrder lists upstreamSources in the order of their settings.
+var upstreamSourceOrder = []string{sourcePrimary, sourceCSGT, telegramChannel, zaloChannel}
+
+func defaultUpstreamSettings() upstreamSettings {
+	u := upstreamSettings{
+		Base:     defaultUpstreamConfig,
+		Sources:  make(map[string]*upstreamConfig),
+		Retry:    make(map[string]*retryPolicy),
+		Breakers: make(map[string]*breakerConfig),
+		Limits:   make(map[string]*limitConfig),
+	}
+	for source := range upstreamSources {
+		cfg := defaultUpstreamConfig
+		if fn := upstreamDefaults[source]; fn != nil {
+			fn(&cfg)
+		}
+		u.Sources[source] = &cfg
+	}
+	for source, p := range defaultRetryPolicies {
+		p := p
+		u.Retry[source] = &p
+	}
+	for _, source := range []string{sourcePrimary, sourceCSGT} {
+		u.Breakers[source] = &breakerConfig{Failures: defaultBreakerFailures, Cooldown: defaultBreakerCooldown}
+	}
+	for source, l := range defaultLimits {
+		l := l
+		u.Limits[source] = &l
+	}
+	return u
+}
+
+func (u *upstreamSettings) settings() []*setting {
+	settings := upstreamConfigSettings("upstream.", "UPSTREAM_", "", &u.Base)
+	for _, source := range upstreamSourceOrder {
+		key, env := "upstream."+strings.ToLower(upstreamSources[source])+".", "UPSTREAM_"+upstreamSources[source]+"_"
+		settings = append(settings, upstreamConfigSettings(key, env, source+": ", u.Sources[source])...)
+	}
+	for _, source := range upstreamSourceOrder {
+		name := upstreamSources[source]
+		key := strings.ToLower(name)
+		if p := u.Retry[source]; p != nil {
+			settings = append(settings,
+				&setting{key: "retry." + key + ".max_attempts", env: "RETRY_" + name + "_MAX_ATTEMPTS", usage: source + ": attempts per call, 1 disables retries", value: intValue{&p.MaxAttempts}},
+				&setting{key: "retry." + key + ".base_delay", env: "RETRY_" + name + "_BASE_DELAY", usage: source + ": wait before the first retry", value: durationValue{&p.BaseDelay}},
+				&setting{key: "retry." + key + ".max_delay", env: "RETRY_" + name + "_MAX_DELAY", usage: source + ": longest wait between retries", value: durationValue{&p.MaxDelay}},
+				&setting{key: "retry." + key + ".budget", env: "RETRY_" + name + "_BUDGET", usage: source + ": time for all attempts of one call", value: durationValue{&p.Budget}},
+			)
+		}
+		if b := u.Breakers[source]; b != nil {
+			settings = append(settings,
+				&setting{key: "breaker." + key + ".failures", env: "BREAKER_" + name + "_FAILURES", usage: source + ": consecutive failures that open the breaker", value: intValue{&b.Failures}},
+				&setting{key: "breaker." + key + ".cooldown", env: "BREAKER_" + name + "_COOLDOWN", usage: source + ": time before an open breaker lets a request through", value: durationValue{&b.Cooldown}},
+			)
+		}
+		if l := u.Limits[source]; l != nil {
+			settings = append(settings,
+				&setting{key: "limit." + key + ".rate", env: "LIMIT_" + name + "_RATE", usage: source + ": requests per second", value: floatValue{&l.Rate}},
+				&setting{key: "limit." + key + ".burst", env: "LIMIT_" + name + "_BURST", usage: source + ": requests at once after a quiet period", value: intValue{&l.Burst}},
+				&setting{key: "limit." + key + ".concurrency", env: "LIMIT_" + name + "_CONCURRENCY", usage: source + ": requests in flight", value: intValue{&l.Concurrency}},
+				&setting{key: "limit." + key + ".max_wait", env: "LIMIT_" + name + "_MAX_WAIT", usage: source + ": longest wait for a token and a slot", value: durationValue{&l.MaxWait}},
+			)
+		}
+	}
+	return settings
+}
+
+// upstreamConfigSettings binds the fields of cfg to keys and variables with
+// the given prefixes; who starts the usage of per-source settings.
+func upstreamConfigSettings(key, env, who string, cfg *upstreamConfig) []*setting {
+	return []*setting{
+		{key: key + "timeout", env: env + "TIMEOUT", usage: who + "time limit of a request, body included; 0 for none", value: optionalDurationValue{&cfg.Timeout}},
+		{key: key + "dial_timeout", env: env + "DIAL_TIMEOUT", usage: who + "time to open a TCP connection", value: durationValue{&cfg.DialTimeout}},
+		{key: key + "keep_alive", env: env + "KEEP_ALIVE", usage: who + "TCP keep-alive period", value: durationValue{&cfg.KeepAlive}},
+		{key: key + "tls_handshake_timeout", env: env + "TLS_HANDSHAKE_TIMEOUT", usage: who + "time for the TLS handshake", value: durationValue{&cfg.TLSHandshakeTimeout}},
+		{key: key + "response_header_timeout", env: env + "RESPONSE_HEADER_TIMEOUT", usage: who + "time to wait for response headers; 0 for no limit", value: optionalDurationValue{&cfg.ResponseHeaderTimeout}},
+		{key: key + "idle_conn_timeout", env: env + "IDLE_CONN_TIMEOUT", usage: who + "how long idle connections are kept; 0 for no limit", value: optionalDurationValue{&cfg.IdleConnTimeout}},
+		{key: key + "max_idle_conns", env: env + "MAX_IDLE_CONNS", usage: who + "idle connections kept, 0 for no limit", value: countValue{&cfg.MaxIdleConns}},
+		{key: key + "max_idle_conns_per_host", env: env + "MAX_IDLE_CONNS_PER_HOST", usage: who + "idle connections kept per host", value: intValue{&cfg.MaxIdleConnsPerHost}},
+		{key: key + "max_conns_per_host", env: env + "MAX_CONNS_PER_HOST", usage: who + "connections per host, 0 for no limit", value: countValue{&cfg.MaxConnsPerHost}},
+		{key: key + "proxy", env: env + "PROXY", usage: who + "http, https or socks5 proxy URL; direct for none, empty for HTTP_PROXY", value: urlValue{stringValue{&cfg.Proxy}}},
+		{key: key + "tls_insecure", env: env + "TLS_INSECURE", usage: who + "skip certificate checks", value: boolValue{&cfg.InsecureSkipVerify}},
+	}
+}
+
+// resolve gives each source the shared upstream.* values, after its
+// upstreamDefaults, for the upstream.<name>.* settings that were not set.
+func (u *upstreamSettings) resolve(settings []*setting) {
+	set := make(map[string]bool)
+	for _, s := range settings {
+		if s.source != "default" {
+			set[s.key] = true
+		}
+	}
+	for _, source := range upstreamSourceOrder {
+		resolved := u.Base
+		if fn := upstreamDefaults[source]; fn != nil {
+			fn(&resolved)
+		}
+		key := "upstream." + strings.ToLower(upstreamSources[source]) + "."
+		own := upstreamConfigSettings(key, "", "", u.Sources[source])
+		for i, s := range upstreamConfigSettings(key, "", "", &resolved) {
+			if set[s.key] {
+				s.value.Set(rawValue(own[i].value)) // already parsed once
+			}
+		}
+		*u.Sources[source] = resolved
+	}
+}
+
+// clients builds the upstream clients, with the rate limits.
+func (u *upstreamSettings) clients() (*upstreamClients, error) {
+	overrides := make(map[string]upstreamConfig, len(u.Sources))
+	for source, cfg := range u.Sources {
+		overrides[source] = *cfg
+	}
+	limits := make(map[string]limitConfig, len(u.Limits))
+	for source, l := range u.Limits {
+		limits[source] = *l
+	}
+	return newUpstreamClients(u.Base, overrides, limits)
+}
+
+func (u *upstreamSettings) retryPolicies() map[string]retryPolicy {
+	policies := make(map[string]retryPolicy, len(u.Retry))
+	for source, p := range u.Retry {
+		policies[source] = *p
+	}
+	return policies
+}
+
+func (u *upstreamSettings) breakers() map[string]*circuitBreaker {
+	return newBreakers(func(source string) (int, time.Duration) {
+		b := u.Breakers[source]
+		return b.Failures, b.Cooldown
+	})
+}
+
+func (u *upstreamSettings) validate() []error {
+	var errs []error
+	proxies := map[string]string{"upstream.proxy": u.Base.Proxy}
+	for source, cfg := range u.Sources {
+		proxies["upstream."+strings.ToLower(upstreamSources[source])+".proxy"] = cfg.Proxy
+	}
+	for key, proxy := range proxies {
+		if _, err := upstreamProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	for source, p := range u.Retry {
+		if p.BaseDelay > p.MaxDelay {
+			key := "retry." + strings.ToLower(upstreamSources[source])
+			errs = append(errs, fmt.Errorf("%s.base_delay (%s) exceeds %s.max_delay (%s)", key, p.BaseDelay, key, p.MaxDelay))
+		}
+	}
+	return errs
+}
+
+// ---- values ----
+
+type configValue interface {
+	Set(s string) error
+	String() string
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+
+// urlValue is a string holding a URL whose password must not be shown.
+type urlValue struct{ stringValue }
+
+func (v urlValue) String() string {
+	if u, err := url.Parse(*v.p); err == nil {
+		return u.Redacted()
+	}
+	return *v.p
+}
+
+// rawValue is the value of v as set, passwords included.
+func rawValue(v configValue) string {
+	if u, ok := v.(urlValue); ok {
+		return *u.p
+	}
+	return v.String()
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 {
+		return fmt.Errorf("%q is not a positive integer", s)
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+// countValue is an int where 0 means no limit.
+type countValue struct{ p *int }
+
+func (v countValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not 0 or a positive integer", s)
+	}
+	*v.p = n
+	return nil
+}
+func (v countValue) String() string { return strconv.Itoa(*v.p) }
+
+type floatValue struct{ p *float64 }
+
+func (v floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f <= 0 {
+		return fmt.Errorf("%q is not a positive number", s)
+	}
+	*v.p = f
+	return nil
+}
+func (v floatValue) String() string { return strconv.FormatFloat(*v.p, 'g', -1, 64) }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%q is not a duration like 30s or 6h", s)
+	}
+	*v.p = d
+	return nil
+}
+func (v durationValue) String() string { return v.p.String() }
+
+// optionalDurationValue is a duration where 0 means no limit.
+type optionalDurationValue struct{ p *time.Duration }
+
+func (v optionalDurationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return fmt.Errorf("%q is not 0 or a duration like 30s or 6h", s)
+	}
+	*v.p = d
+	return nil
+}
+func (v optionalDurationValue) String() string { return v.p.String() }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+
+// ---- loading ----
+
+// flagName is the command-line flag of a config key, e.g. -http-addr.
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// loadConfig builds the configuration from a config file (-config or
+// CONFIG_FILE; YAML or TOML by extension), then the environment, then the
+// flags in args, and validates it. A variable set to "" counts as set.
+// Every problem found is reported. Flag usage and parse errors are written
+// to output.
+func loadConfig(args []string, lookupEnv func(string) (string, bool), output io.Writer) (*appConfig, []*setting, error) {
+	cfg := defaultAppConfig()
+	settings := cfg.settings()
+	for _, s := range settings {
+		s.source = "default"
+	}
+
+	fs := flag.NewFlagSet("kiemtraphatnguoi", flag.ContinueOnError)
+	fs.SetOutput(output)
+	defaultFile, _ := lookupEnv("CONFIG_FILE")
+	configFile := fs.String("config", defaultFile, "YAML or TOML config file")
+	fs.Bool("logtostderr", true, "accepted for compatibility; logs always go to stderr")
+	type flagValue struct {
+		s   *setting
+		raw string
+	}
+	var flags []flagValue
+	for _, s := range settings {
+		s := s
+		record := func(raw string) error {
+			flags = append(flags, flagValue{s, raw})
+			return nil
+		}
+		usage := fmt.Sprintf("%s (%s, default %q)", s.usage, s.env, s.value.String())
+		if _, ok := s.value.(boolValue); ok {
+			fs.BoolFunc(flagName(s.key), usage, record)
+		} else {
+			fs.Func(flagName(s.key), usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		known := make(map[string]*setting, len(settings))
+		for _, s := range settings {
+			known[s.key] = s
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s, ok := known[k]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %q", *configFile, k))
+				continue
+			}
+			if err := s.value.Set(values[k]); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", *configFile, k, err))
+				continue
+			}
+			s.source = "file"
+		}
+	}
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+				continue
+			}
+			s.source = "env"
+		}
+	}
+	for _, f := range flags {
+		if err := f.s.value.Set(f.raw); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", flagName(f.s.key), err))
+			continue
+		}
+		f.s.source = "flag"
+	}
+	cfg.Upstream.resolve(settings)
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return cfg, settings, nil
+}
+
+// readConfigFile reads a .yaml/.yml or .toml file into dotted keys, e.g.
+// {"http": {"addr": ":80"}} becomes "http.addr". Lists are joined with
+// commas.
+func readConfigFile(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var tree map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &tree)
+	case ".toml":
+		err = toml.Unmarshal(raw, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format %q (use .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	var flatten func(prefix string, node map[string]interface{})
+	flatten = func(prefix string, node map[string]interface{}) {
+		for k, v := range node {
+			key := prefix + k
+			switch v := v.(type) {
+			case map[string]interface{}:
+				flatten(key+".", v)
+			case nil:
+				values[key] = ""
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					items[i] = fmt.Sprint(item)
+				}
+				values[key] = strings.Join(items, ",")
+			default:
+				values[key] = fmt.Sprint(v)
+			}
+		}
+	}
+	flatten("", tree)
+	return values, nil
+}
+
+// validate checks what single values can't: formats and combinations.
+func (c *appConfig) validate() []error {
+	var errs []error
+	for key, addr := range map[string]string{"http.addr": c.HTTP.Addr, "grpc.addr": c.GRPCAddr} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q is not host:port", key, addr))
+		}
+	}
+	urls := map[string]string{
+		"sources.primary_url":      c.PrimaryURL,
+		"sources.csgt_captcha_url": c.CSGTCaptchaURL,
+		"sources.csgt_lookup_url":  c.CSGTLookupURL,
+		"telegram.api_url":         c.Chat.TelegramAPIURL,
+		"telegram.webhook_url":     c.Chat.TelegramWebhookURL,
+		"zalo.api_url":             c.Chat.ZaloAPIURL,
+	}
+	for key, raw := range urls {
+		if raw == "" && !strings.HasPrefix(key, "sources.") {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: %q is not an http(s) URL", key, raw))
+		}
+	}
+	if net.ParseIP(c.CSGTClientIP) == nil {
+		errs = append(errs, fmt.Errorf("sources.csgt_client_ip: %q is not an IP address", c.CSGTClientIP))
+	}
+	// Without the secret anyone who finds the webhook URL can post updates
+	if c.Chat.TelegramToken != "" && c.Chat.TelegramWebhookURL != "" && c.Chat.TelegramWebhookSecret == "" {
+		errs = append(errs, errors.New("telegram.webhook_secret is required with telegram.webhook_url"))
+	}
+	if c.Chat.ZaloToken != "" && (c.Chat.ZaloSecretKey == "" || c.Chat.ZaloAppID == "") {
+		errs = append(errs, errors.New("zalo.secret_key and zalo.app_id are required with zalo.token, to verify webhook signatures"))
+	}
+	if (c.HTTP.TLSCertFile == "") != (c.HTTP.TLSKeyFile == "") {
+		errs = append(errs, errors.New("http.tls_cert_file and http.tls_key_file must be set together"))
+	}
+
+	tiers, err := parseClientTiers(c.RateLimit.Tiers, defaultClientTiers)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.tiers: %w", err))
+	} else if _, err := parseAPIKeys(c.RateLimit.APIKeys, tiers); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.api_keys: %w", err))
+	}
+	switch c.RateLimit.Store {
+	case "memory":
+	case "redis":
+		if store, err := newRedisCounterStore(c.RateLimit.RedisURL); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit.redis_url: %w", err))
+		} else {
+			store.client.Close()
+		}
+	default:
+		errs = append(errs, fmt.Errorf("rate_limit.store: %q is not memory or redis", c.RateLimit.Store))
+	}
+	return append(errs, c.Upstream.validate()...)
+}
+
+// apply sets the package-level settings the lookup chain reads.
+func (c *appConfig) apply() {
+	primaryLookupURL = c.PrimaryURL
+	csgtCaptchaURL = c.CSGTCaptchaURL
+	csgtLookupURL = c.CSGTLookupURL
+	csgtClientIP = c.CSGTClientIP
+	captchaLogDir = c.CaptchaLogDir
+	captchaAttempts = c.CaptchaAttempts
+	lookupTimeout = c.LookupTimeout
+}
+
+// ---- debug endpoint ----
+
+// configEntry is one setting in GET /debug/config.
+type configEntry struct {
+	Key    string `json:"key"`
+	Env    string `json:"env"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // default, file, env or flag
+}
+
+const redacted = "[redacted]"
+
+func configEntries(settings []*setting) []configEntry {
+	entries := make([]configEntry, len(settings))
+	for i, s := range settings {
+		value := s.value.String()
+		if s.secret && value != "" {
+			value = redacted
+		}
+		entries[i] = configEntry{Key: s.key, Env: s.env, Value: value, Source: s.source}
+	}
+	return entries
+}
+
+// registerConfigRoutes serves the effective configuration, secrets
+// redacted, at GET /debug/config.
+func registerConfigRoutes(mux *http.ServeMux, settings []*setting) {
+	entries := configEntries(settings)
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		writeEnvelope(w, http.StatusOK, entries, nil)
+	})
+}
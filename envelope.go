@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ------------------------------------------------------------------------
+// /v1 response envelopes
+// ------------------------------------------------------------------------
+
+// apiEnvelope is the shape of every JSON response under /v1:
+//
+//	{"data": ..., "meta": {...}}   on success
+//	{"data": null, "error": {...}} on failure
+type apiEnvelope struct {
+	Data  interface{} `json:"data"`
+	Meta  interface{} `json:"meta,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+func writeEnvelope(w http.ResponseWriter, statusCode int, data, meta interface{}) {
+	writeJSON(w, statusCode, apiEnvelope{Data: data, Meta: meta})
+}
+
+// writeEnvelopeError writes err as an error envelope. See apiErrorFor for
+// fallbackStatus.
+func writeEnvelopeError(w http.ResponseWriter, fallbackStatus int, err error) {
+	status, e := apiErrorFor(err, fallbackStatus)
+	writeEnvelopeAPIError(w, status, e)
+}
+
+func writeEnvelopeAPIError(w http.ResponseWriter, statusCode int, e *apiError) {
+	setRetryAfter(w, e)
+	writeJSON(w, statusCode, apiEnvelope{Error: e})
+}
+
+// withEnvelope adapts a handler written for the unversioned API (plain JSON
+// bodies, {"error": "..."} on failure) to the /v1 envelope. Non-JSON
+// responses such as CSV exports and empty 204s pass through untouched.
+func withEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+
+		if !strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") || rec.body.Len() == 0 {
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+			return
+		}
+
+		if rec.status >= 400 {
+			var legacy legacyError
+			_ = json.Unmarshal(rec.body.Bytes(), &legacy)
+			if legacy.Error == "" {
+				legacy.Error = http.StatusText(rec.status)
+			}
+			if legacy.Code == "" {
+				legacy.Code = codeForStatus(rec.status)
+			}
+			writeEnvelopeAPIError(w, rec.status, &apiError{
+				Code:       legacy.Code,
+				Message:    legacy.Error,
+				Retryable:  legacy.Retryable,
+				RetryAfter: legacy.RetryAfter,
+			})
+			return
+		}
+
+		writeEnvelope(w, rec.status, json.RawMessage(rec.body.Bytes()), nil)
+	})
+}
+
+// mountV1 serves an unversioned handler under /v1: prefix is the handler's
+// own path (e.g. "/jobs/"), requests to "/v1"+prefix reach it with "/v1"
+// stripped, and its responses are wrapped by withEnvelope.
+func mountV1(mux *http.ServeMux, prefix string, h http.Handler) {
+	inner := withEnvelope(h)
+	mux.Handle("/v1"+prefix, http.StripPrefix("/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(&v1LocationWriter{ResponseWriter: w}, r)
+	})))
+}
+
+// v1LocationWriter keeps Location headers pointing at /v1 routes.
+type v1LocationWriter struct {
+	http.ResponseWriter
+}
+
+func (v *v1LocationWriter) WriteHeader(statusCode int) {
+	if loc := v.Header().Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "/v1/") {
+		v.Header().Set("Location", "/v1"+loc)
+	}
+	v.ResponseWriter.WriteHeader(statusCode)
+}
+
+// bufferedResponse captures a handler's response so it can be rewritten.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(statusCode int)  { b.status = statusCode }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Error model: stable codes, HTTP statuses and retry hints
+// ------------------------------------------------------------------------
+
+// Error codes returned to clients. They are part of the API and must not
+// change once published.
+const (
+	codeInvalidRequest        = "invalid_request"
+	codeInvalidPlate          = "invalid_plate"
+	codeInvalidVehicleType    = "invalid_vehicle_type"
+	codeUnauthorized          = "unauthorized"
+	codeNotFound              = "not_found"
+	codeConflict              = "conflict"
+	codeMethodNotAllowed      = "method_not_allowed"
+	codeUpstreamUnavailable   = "upstream_unavailable"
+	codeCaptchaFailed         = "captcha_failed"
+	codeUpstreamSchemaChanged = "upstream_schema_changed"
+	codeOverloaded            = "overloaded"
+	codeRateLimited           = "rate_limited"
+	codeCanceled              = "canceled"
+	codeInternal              = "internal_error"
+)
+
+// statusClientClosedRequest is nginx's status for a request whose client went
+// away before the answer; nobody receives it, but logs and gRPC do.
+const statusClientClosedRequest = 499
+
+var (
+	ErrInvalidPlate        = errors.New("invalid plate number")
+	ErrInvalidVehicleType  = errors.New("invalid vehicle type")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrUpstreamSchema      = errors.New("unexpected upstream response")
+	ErrCaptchaUnsolved     = errors.New("captcha could not be solved")
+)
+
+// apiError is the machine-readable description of a failure. Under /v1 it is
+// the "error" member of the envelope; unversioned routes flatten it next to
+// the legacy "error" message (see legacyError).
+type apiError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Retryable  bool   `json:"retryable"`
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds
+}
+
+// legacyError keeps {"error": "..."} for existing clients and adds the code.
+type legacyError struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	Retryable  bool   `json:"retryable"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// errorKind is how one class of errors is reported.
+type errorKind struct {
+	code       string
+	status     int
+	retryAfter time.Duration // > 0 means the request may be retried
+}
+
+// classifyError maps err to its kind. ok is false for errors the model does
+// not know about; callers then pick a status from context.
+func classifyError(err error) (kind errorKind, ok bool) {
+	netErr, isNet := asNetError(err)
+	var openErr *circuitOpenError
+	switch {
+	case errors.Is(err, ErrInvalidPlate):
+		return errorKind{codeInvalidPlate, http.StatusBadRequest, 0}, true
+	case errors.Is(err, ErrInvalidVehicleType):
+		return errorKind{codeInvalidVehicleType, http.StatusBadRequest, 0}, true
+	case errors.Is(err, ErrFleetNotFound), errors.Is(err, ErrDataNotFound):
+		return errorKind{codeNotFound, http.StatusNotFound, 0}, true
+	case errors.Is(err, ErrInvalidAPIKey), errors.Is(err, ErrAPIKeyRequired):
+		return errorKind{codeUnauthorized, http.StatusUnauthorized, 0}, true
+	case errors.Is(err, ErrRateLimited):
+		return errorKind{codeRateLimited, http.StatusTooManyRequests, time.Minute}, true
+	case errors.Is(err, ErrFleetInvalid):
+		return errorKind{codeInvalidRequest, http.StatusBadRequest, 0}, true
+	case errors.Is(err, ErrDuplicatePlate):
+		return errorKind{codeConflict, http.StatusConflict, 0}, true
+	case errors.Is(err, ErrJobQueueFull), errors.Is(err, ErrUpstreamBusy), errors.Is(err, ErrOCRBusy):
+		return errorKind{codeOverloaded, http.StatusServiceUnavailable, 30 * time.Second}, true
+	case errors.Is(err, ErrCaptchaRejected), errors.Is(err, ErrCaptchaUnsolved):
+		return errorKind{codeCaptchaFailed, http.StatusBadGateway, 5 * time.Second}, true
+	case errors.Is(err, ErrUpstreamSchema):
+		return errorKind{codeUpstreamSchemaChanged, http.StatusBadGateway, 0}, true
+	case errors.Is(err, context.Canceled):
+		return errorKind{codeCanceled, statusClientClosedRequest, 0}, true
+	case errors.Is(err, context.DeadlineExceeded), isNet && netErr.Timeout():
+		return errorKind{codeUpstreamUnavailable, http.StatusGatewayTimeout, 30 * time.Second}, true
+	case errors.As(err, &openErr) && openErr.retryIn > 0:
+		// Whole seconds, rounded up so the breaker has let go by then
+		return errorKind{codeUpstreamUnavailable, http.StatusServiceUnavailable, (openErr.retryIn + time.Second - 1).Truncate(time.Second)}, true
+	case errors.Is(err, ErrUpstreamUnavailable), isNet:
+		return errorKind{codeUpstreamUnavailable, http.StatusServiceUnavailable, 60 * time.Second}, true
+	}
+	return errorKind{}, false
+}
+
+// asNetError finds the network error in err's chain. syscall.Errno
+// implements net.Error too, but a bare one comes from the file system, e.g.
+// a store that failed to save, and says nothing about the upstreams.
+func asNetError(err error) (net.Error, bool) {
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		return nil, false
+	}
+	if _, ok := netErr.(syscall.Errno); ok {
+		return nil, false
+	}
+	return netErr, true
+}
+
+// errorCode returns the code for err. See apiErrorFor for fallbackStatus.
+func errorCode(err error, fallbackStatus int) string {
+	_, e := apiErrorFor(err, fallbackStatus)
+	return e.Code
+}
+
+// apiErrorFor describes err and picks its HTTP status. fallbackStatus is used
+// for errors classifyError does not know, e.g. request validation messages.
+func apiErrorFor(err error, fallbackStatus int) (int, *apiError) {
+	kind, ok := classifyError(err)
+	if !ok {
+		kind = errorKind{code: codeForStatus(fallbackStatus), status: fallbackStatus}
+	}
+	return kind.status, &apiError{
+		Code:       kind.code,
+		Message:    err.Error(),
+		Retryable:  kind.retryAfter > 0,
+		RetryAfter: int(kind.retryAfter / time.Second),
+	}
+}
+
+// codeForStatus is the generic code for errors that only have a status.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return codeInvalidRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return codeUpstreamUnavailable
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	case http.StatusServiceUnavailable:
+		return codeOverloaded
+	}
+	return codeInternal
+}
+
+// setRetryAfter adds the Retry-After header for retryable errors.
+func setRetryAfter(w http.ResponseWriter, e *apiError) {
+	if e.Retryable && e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+}
+
+// writeError writes err in the unversioned format. See apiErrorFor for
+// fallbackStatus.
+func writeError(w http.ResponseWriter, fallbackStatus int, err error) {
+	status, e := apiErrorFor(err, fallbackStatus)
+	writeLegacyError(w, status, e)
+}
+
+func writeLegacyError(w http.ResponseWriter, status int, e *apiError) {
+	setRetryAfter(w, e)
+	writeJSON(w, status, legacyError{Error: e.Message, Code: e.Code, Retryable: e.Retryable, RetryAfter: e.RetryAfter})
+}
+
+// markedError reads like err but also matches kind with errors.Is, so
+// existing messages keep their wording while gaining a class.
+type markedError struct {
+	kind error
+	err  error
+}
+
+func markError(kind, err error) error {
+	return &markedError{kind: kind, err: err}
+}
+
+func (e *markedError) Error() string   { return e.err.Error() }
+func (e *markedError) Unwrap() []error { return []error{e.kind, e.err} }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	_, invalidPlate := processPlate("abc")
+	_, invalidType := vehicleCodeFor("tau")
+
+	cases := []struct {
+		name      string
+		err       error
+		code      string
+		status    int
+		retryable bool
+	}{
+		{"invalid plate", invalidPlate, codeInvalidPlate, http.StatusBadRequest, false},
+		{"invalid vehicle type", invalidType, codeInvalidVehicleType, http.StatusBadRequest, false},
+		{"fleet not found", fmt.Errorf("vehicle: %w", ErrFleetNotFound), codeNotFound, http.StatusNotFound, false},
+		{"captcha after fallback", fmt.Errorf("%w: %w", ErrFallbackFailed, ErrCaptchaRejected), codeCaptchaFailed, http.StatusBadGateway, true},
+		{"schema", markError(ErrUpstreamSchema, errors.New("could not parse JSON")), codeUpstreamSchemaChanged, http.StatusBadGateway, false},
+		{"upstream 500", markError(ErrUpstreamUnavailable, errors.New("server returned status code: 500")), codeUpstreamUnavailable, http.StatusServiceUnavailable, true},
+		{"connection refused", fmt.Errorf("connection error: %w", &url.Error{Op: "Post", URL: "x", Err: errors.New("refused")}), codeUpstreamUnavailable, http.StatusServiceUnavailable, true},
+		{"timeout", fmt.Errorf("connection error: %w", &url.Error{Op: "Post", URL: "x", Err: timeoutError{}}), codeUpstreamUnavailable, http.StatusGatewayTimeout, true},
+		{"deadline", context.DeadlineExceeded, codeUpstreamUnavailable, http.StatusGatewayTimeout, true},
+		{"client gone", fmt.Errorf("connection error: %w", &url.Error{Op: "Post", URL: "x", Err: context.Canceled}), codeCanceled, statusClientClosedRequest, false},
+		{"queue full", ErrJobQueueFull, codeOverloaded, http.StatusServiceUnavailable, true},
+		{"failed save", fmt.Errorf("failed to write file: %w", &fs.PathError{Op: "open", Path: "x", Err: syscall.ENOTDIR}), codeInternal, http.StatusInternalServerError, false},
+		{"invalid fleet data", markError(ErrFleetInvalid, errors.New("unknown group: x")), codeInvalidRequest, http.StatusBadRequest, false},
+		{"unknown", errors.New("boom"), codeInternal, http.StatusInternalServerError, false},
+	}
+	for _, c := range cases {
+		status, e := apiErrorFor(c.err, http.StatusInternalServerError)
+		if status != c.status || e.Code != c.code || e.Retryable != c.retryable {
+			t.Errorf("%s: expected %d %s retryable=%v, got %d %+v", c.name, c.status, c.code, c.retryable, status, e)
+		}
+		if e.Message != c.err.Error() {
+			t.Errorf("%s: expected message %q, got %q", c.name, c.err.Error(), e.Message)
+		}
+	}
+}
+
+func TestWriteError_RetryAfter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusBadRequest, markError(ErrUpstreamUnavailable, errors.New("down")))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 503 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	writeEnvelopeError(rec, http.StatusBadRequest, errors.New("Missing or empty parameter: captcha"))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected plain 400, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var env testEnvelope
+	doJSON(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeEnvelopeError(w, http.StatusBadRequest, errors.New("bad"))
+	}), "GET", "/", "", &env)
+	if env.Error == nil || env.Error.Code != codeInvalidRequest {
+		t.Errorf("Expected invalid_request code, got %+v", env.Error)
+	}
+}
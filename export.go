@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ------------------------------------------------------------------------
+// CSV / XLSX export of lookup results
+// ------------------------------------------------------------------------
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+
+	mimeCSV  = "text/csv"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// utf8BOM makes Excel open the CSV as UTF-8 instead of the ANSI code page,
+// which mangles Vietnamese text.
+const utf8BOM = "\ufeff"
+
+// negotiateFormat picks the response format: ?format= if given, otherwise
+// the first of CSV, XLSX or JSON named in the Accept header, otherwise JSON.
+func negotiateFormat(r *http.Request) (string, error) {
+	if f := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); f != "" {
+		switch f {
+		case formatJSON, formatCSV, formatXLSX:
+			return f, nil
+		}
+		return "", fmt.Errorf("Unsupported format: %s (use json, csv or xlsx)", f)
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case mimeCSV:
+			return formatCSV, nil
+		case mimeXLSX:
+			return formatXLSX, nil
+		case "application/json":
+			return formatJSON, nil
+		}
+	}
+	return formatJSON, nil
+}
+
+type columnKind int
+
+const (
+	columnText      columnKind = iota
+	columnMultiline            // text with line breaks, wrapped in XLSX
+	columnInt
+	columnTime // time.Time, or a violation time string
+)
+
+type exportColumn struct {
+	name  string
+	kind  columnKind
+	width float64 // XLSX column width, 0 = default
+}
+
+// exportTable is a result flattened to one row per violation. Cells are
+// strings, ints or time.Times; nil is an empty cell.
+type exportTable struct {
+	sheet   string
+	columns []exportColumn
+	rows    [][]interface{}
+}
+
+// violationColumns has one column per CsgtData field, named like its JSON
+// field.
+var violationColumns = []exportColumn{
+	{"plate", columnText, 12},
+	{"plate_color", columnText, 12},
+	{"vehicle_type", columnText, 12},
+	{"violation_time", columnTime, 18},
+	{"violation_place", columnMultiline, 40},
+	{"violation_action", columnMultiline, 40},
+	{"status", columnText, 16},
+	{"detected_by", columnMultiline, 30},
+	{"resolution_location", columnMultiline, 60},
+}
+
+// violationCells returns the violationColumns cells of d; nil d gives empty
+// cells, for rows that carry no violation.
+func violationCells(d *CsgtData) []interface{} {
+	if d == nil {
+		return make([]interface{}, len(violationColumns))
+	}
+	return []interface{}{
+		d.Plate, d.PlateColor, d.VehicleType, d.ViolationTime, d.ViolationPlace,
+		d.ViolationAction, d.Status, d.DetectedBy, d.ResolutionLocation,
+	}
+}
+
+func violationsTable(data []*CsgtData) *exportTable {
+	t := &exportTable{sheet: "Violations", columns: violationColumns}
+	for _, d := range data {
+		t.rows = append(t.rows, violationCells(d))
+	}
+	return t
+}
+
+// writeTable writes t as an attachment named filename plus the format's
+// extension.
+func writeTable(w http.ResponseWriter, format, filename string, t *exportTable) {
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", mimeCSV+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		w.WriteHeader(http.StatusOK)
+		_ = writeTableCSV(w, t)
+	case formatXLSX:
+		f, err := tableToXLSX(t)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", mimeXLSX)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		w.WriteHeader(http.StatusOK)
+		_ = f.Write(w)
+	}
+}
+
+// writeTableCSV writes t with a BOM and CRLF line endings, as Excel expects.
+// Multi-line cells are quoted by encoding/csv and keep their line breaks.
+func writeTableCSV(w io.Writer, t *exportTable) error {
+	if _, err := w.Write([]byte(utf8BOM)); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.name
+	}
+	_ = cw.Write(header)
+
+	for _, row := range t.rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+			case time.Time:
+				if !v.IsZero() {
+					record[i] = v.Format(time.RFC3339)
+				}
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		_ = cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tableToXLSX builds a one-sheet workbook with a frozen header row. Time
+// columns hold real dates, ints are numbers and multi-line text wraps.
+func tableToXLSX(t *exportTable) (*excelize.File, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", t.sheet); err != nil {
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter(t.sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	headerStyle, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	timeFormat := "dd/mm/yyyy hh:mm"
+	timeStyle, _ := f.NewStyle(&excelize.Style{CustomNumFmt: &timeFormat, Alignment: &excelize.Alignment{Vertical: "top"}})
+	wrapStyle, _ := f.NewStyle(&excelize.Style{Alignment: &excelize.Alignment{WrapText: true, Vertical: "top"}})
+	topStyle, _ := f.NewStyle(&excelize.Style{Alignment: &excelize.Alignment{Vertical: "top"}})
+
+	for i, c := range t.columns {
+		if c.width > 0 {
+			if err := sw.SetColWidth(i+1, i+1, c.width); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: c.name}
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+
+	for n, row := range t.rows {
+		cells := make([]interface{}, len(row))
+		for i, v := range row {
+			cell := excelize.Cell{StyleID: topStyle, Value: v}
+			switch t.columns[i].kind {
+			case columnMultiline:
+				cell.StyleID = wrapStyle
+			case columnTime:
+				cell.StyleID = timeStyle
+				if s, ok := v.(string); ok {
+					// Keep the text when a source changes its format
+					if parsed, err := time.Parse(violationTimeLayout, s); err == nil {
+						cell.Value = parsed
+					} else {
+						cell.StyleID = topStyle
+					}
+				}
+				if tm, ok := cell.Value.(time.Time); ok && tm.IsZero() {
+					cell.Value = nil
+				}
+			}
+			cells[i] = cell
+		}
+		axis, _ := excelize.CoordinatesToCellName(1, n+2)
+		if err := sw.SetRow(axis, cells); err != nil {
+			return nil, err
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// batchTable has one row per violation of each batch item; items without
+// violations, including failed ones, get a single row.
+func batchTable(resp *batchResponse) *exportTable {
+	t := &exportTable{sheet: "Batch", columns: append([]exportColumn{
+		{"index", columnInt, 6},
+		{"input", columnText, 14},
+		{"lookup_plate", columnText, 12},
+		{"lookup_vehicle_type", columnText, 10},
+		{"result", columnText, 8},
+		{"error", columnMultiline, 40},
+		{"error_code", columnText, 20},
+	}, violationColumns...)}
+
+	for _, res := range resp.Results {
+		prefix := []interface{}{res.Index, res.Input, res.Plate, res.VehicleType, res.Status, res.Error, res.Code}
+		if len(res.Data) == 0 {
+			t.rows = append(t.rows, append(prefix, violationCells(nil)...))
+		}
+		for _, d := range res.Data {
+			t.rows = append(t.rows, append(append([]interface{}{}, prefix...), violationCells(d)...))
+		}
+	}
+	return t
+}
+
+// fleetViolationsTable has one row per violation of each vehicle; vehicles
+// without violations get a single row.
+func fleetViolationsTable(view *fleetViolationsView) *exportTable {
+	t := &exportTable{sheet: "Fleet", columns: append([]exportColumn{
+		{"vehicle_id", columnText, 18},
+		{"vehicle_plate", columnText, 12},
+		{"driver", columnText, 20},
+		{"checked_at", columnTime, 18},
+		{"lookup_error", columnMultiline, 40},
+	}, violationColumns...)}
+
+	for _, v := range view.Vehicles {
+		var checkedAt interface{}
+		if v.CheckedAt != nil {
+			checkedAt = *v.CheckedAt
+		}
+		prefix := []interface{}{v.VehicleID, v.Plate, v.Driver, checkedAt, v.Error}
+		if len(v.Violations) == 0 {
+			t.rows = append(t.rows, append(prefix, violationCells(nil)...))
+		}
+		for _, d := range v.Violations {
+			t.rows = append(t.rows, append(append([]interface{}{}, prefix...), violationCells(d)...))
+		}
+	}
+	return t
+}
+
+// vehiclesTable has one row per vehicle in the fleetCSVHeader layout, so an
+// exported file can be imported again.
+func vehiclesTable(vehicles []fleetVehicle, groups []vehicleGroup) *exportTable {
+	groupNames := make(map[string]string, len(groups))
+	for _, g := range groups {
+		groupNames[g.ID] = g.Name
+	}
+
+	t := &exportTable{sheet: "Vehicles", columns: []exportColumn{
+		{"plate", columnText, 12},
+		{"vehicle_type", columnText, 12},
+		{"driver", columnText, 20},
+		{"notes", columnMultiline, 40},
+		{"groups", columnText, 30},
+	}}
+	for _, v := range vehicles {
+		var names []string
+		for _, id := range v.GroupIDs {
+			names = append(names, groupNames[id])
+		}
+		t.rows = append(t.rows, []interface{}{v.Plate, v.VehicleType, v.Driver, v.Notes, strings.Join(names, ";")})
+	}
+	return t
+}
+
+// exportFilename is a download name made of safe characters only.
+func exportFilename(parts ...string) string {
+	name := strings.Join(parts, "-")
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var exportSample = []*CsgtData{{
+	Plate:              "98E1-714.78",
+	PlateColor:         "Nền mầu trắng, chữ và số màu đen",
+	VehicleType:        "Xe máy",
+	ViolationTime:      "14:52, 06/01/2025",
+	ViolationPlace:     "Km 12+500, QL1A, Lạng Giang, Bắc Giang",
+	ViolationAction:    "12321.5.3.a.01.Điều khiển xe chạy quá tốc độ quy định",
+	Status:             "Chưa xử phạt",
+	DetectedBy:         "Đội Cảnh sát giao thông, Trật tự",
+	ResolutionLocation: "1. Đội CSGT\nĐịa chỉ: số 384 đường Xương Giang\nSố điện thoại liên hệ: 0911595121",
+}}
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		url, accept, want string
+	}{
+		{"/x", "", formatJSON},
+		{"/x", "*/*", formatJSON},
+		{"/x", "text/csv", formatCSV},
+		{"/x", "text/html, " + mimeXLSX + ";q=0.9", formatXLSX},
+		{"/x", "application/json, text/csv", formatJSON},
+		{"/x?format=CSV", mimeXLSX, formatCSV},
+		{"/x?format=xlsx", "", formatXLSX},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.url, nil)
+		req.Header.Set("Accept", c.accept)
+		if got, err := negotiateFormat(req); err != nil || got != c.want {
+			t.Errorf("%s (Accept %q): got %q, %v; want %q", c.url, c.accept, got, err, c.want)
+		}
+	}
+
+	if _, err := negotiateFormat(httptest.NewRequest("GET", "/x?format=pdf", nil)); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
+
+func TestExport_LookupCSV(t *testing.T) {
+	mux := newTestPlateAPI(func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) { return exportSample, nil })
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/plates/98E1-714.78/violations?vehicle_type=xemay&format=csv", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected CSV, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), `filename="violations-98E171478.csv"`) {
+		t.Errorf("Unexpected Content-Disposition %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, utf8BOM) {
+		t.Fatal("Expected a UTF-8 BOM")
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(body, utf8BOM))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[0]) != len(violationColumns) || records[0][0] != "plate" {
+		t.Fatalf("Unexpected records %q", records)
+	}
+	got := strings.ReplaceAll(records[1][8], "\r\n", "\n")
+	if got != exportSample[0].ResolutionLocation || records[1][1] != exportSample[0].PlateColor {
+		t.Errorf("Fields did not survive the round trip: %q", records[1])
+	}
+
+	// Errors stay JSON
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/plates/abc/violations?format=csv", nil))
+	if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("Expected a JSON 400, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestExport_BatchXLSX(t *testing.T) {
+	api := newBatchAPI(2)
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		return append(exportSample, exportSample...), nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/checkplate/batch", strings.NewReader(`["98E171478","bad"]`))
+	req.Header.Set("Accept", mimeXLSX)
+	rec := httptest.NewRecorder()
+	api.checkPlateBatchHandler(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mimeXLSX {
+		t.Fatalf("Expected XLSX, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("Batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Header, two violations of the first plate, one row for the invalid one
+	if len(rows) != 4 || rows[0][0] != "index" || rows[3][4] != "error" || rows[3][6] != codeInvalidPlate {
+		t.Fatalf("Unexpected rows %q", rows)
+	}
+
+	// index is a number and violation_time a real date
+	if typ, _ := f.GetCellType("Batch", "A3"); typ != excelize.CellTypeUnset && typ != excelize.CellTypeNumber {
+		t.Errorf("Expected a numeric index, got type %v", typ)
+	}
+	timeCol := len(rows[0]) - len(violationColumns) + 4
+	cell, _ := excelize.CoordinatesToCellName(timeCol, 2)
+	raw, _ := f.GetCellValue("Batch", cell, excelize.Options{RawCellValue: true})
+	serial, err := excelize.ExcelDateToTime(mustParseFloat(t, raw), false)
+	if err != nil || !serial.Equal(time.Date(2025, 1, 6, 14, 52, 0, 0, time.UTC)) {
+		t.Errorf("Expected violation_time stored as a date, got %q (%v)", raw, serial)
+	}
+	loc, _ := f.GetCellValue("Batch", mustCell(len(rows[0]), 2))
+	if loc != exportSample[0].ResolutionLocation {
+		t.Errorf("Expected the multi-line resolution_location unchanged, got %q", loc)
+	}
+}
+
+func TestExport_FleetViolations(t *testing.T) {
+	api, mux := newTestFleet(t, "")
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		if plate == "98E171478" {
+			return exportSample, nil
+		}
+		return nil, nil
+	}
+
+	var org organization
+	doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"Fleet"}`, &org)
+	doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", "plate,vehicle_type\n98E171478,xemay\n30A99999,oto\n", nil)
+
+	req := httptest.NewRequest("POST", "/fleet/organizations/"+org.ID+"/violations/refresh?format=csv", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(rec.Body.String(), utf8BOM))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][1] != "vehicle_plate" || records[1][3] == "" {
+		t.Fatalf("Unexpected records %q", records)
+	}
+	if _, err := time.Parse(time.RFC3339, records[1][3]); err != nil {
+		t.Errorf("Expected an RFC 3339 checked_at, got %q", records[1][3])
+	}
+}
+
+func mustParseFloat(t *testing.T, s string) float64 {
+	t.Helper()
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatalf("Not a number: %q", s)
+	}
+	return f
+}
+
+func mustCell(col, row int) string {
+	cell, _ := excelize.CoordinatesToCellName(col, row)
+	return cell
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Fleet model: organizations, vehicles and groups
+// ------------------------------------------------------------------------
+
+var (
+	ErrFleetNotFound  = errors.New("not found")
+	ErrDuplicatePlate = errors.New("plate already registered in this organization")
+	ErrFleetInvalid   = errors.New("invalid fleet data")
+)
+
+type organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type vehicleGroup struct {
+	ID          string    `json:"id"`
+	OrgID       string    `json:"organization_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type fleetVehicle struct {
+	ID          string         `json:"id"`
+	OrgID       string         `json:"organization_id"`
+	Plate       string         `json:"plate"`
+	VehicleType string         `json:"vehicle_type"` // "oto" or "xemay"
+	Driver      string         `json:"driver"`
+	Notes       string         `json:"notes"`
+	GroupIDs    []string       `json:"group_ids"`
+	LastLookup  *vehicleLookup `json:"last_lookup,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// vehicleLookup is the most recent lookup result kept for a vehicle, so the
+// fleet-wide view doesn't have to hit the upstream sources.
+type vehicleLookup struct {
+	CheckedAt  time.Time   `json:"checked_at"`
+	Violations []*CsgtData `json:"violations"`
+	Error      string      `json:"error,omitempty"`
+}
+
+type fleetData struct {
+	Organizations []*organization `json:"organizations"`
+	Groups        []*vehicleGroup `json:"groups"`
+	Vehicles      []*fleetVehicle `json:"vehicles"`
+}
+
+// fleetStore holds all fleet entities and mirrors them to a JSON file, like
+// subscriptionStore. An empty path keeps everything in memory.
+type fleetStore struct {
+	mu   sync.Mutex
+	path string
+	data fleetData
+}
+
+func newFleetStore(path string) (*fleetStore, error) {
+	s := &fleetStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fleet data %q: %w", path, err)
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse fleet data %q: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *fleetStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.data)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// normalizeVehicle validates and cleans the user-editable vehicle fields.
+func normalizeVehicle(v *fleetVehicle) error {
+	plate, err := processPlate(v.Plate)
+	if err != nil {
+		return err
+	}
+	v.Plate = plate
+
+	v.VehicleType = strings.ToLower(strings.TrimSpace(v.VehicleType))
+	if v.VehicleType == "" {
+		v.VehicleType = "oto"
+	}
+	if _, err := vehicleCodeFor(v.VehicleType); err != nil {
+		return err
+	}
+
+	v.Driver = strings.TrimSpace(v.Driver)
+	v.Notes = strings.TrimSpace(v.Notes)
+	return nil
+}
+
+// ---- organizations ----
+
+func (s *fleetStore) CreateOrganization(name string) (*organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := &organization{ID: newID(), Name: name, CreatedAt: time.Now()}
+	s.data.Organizations = append(s.data.Organizations, org)
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	cp := *org
+	return &cp, nil
+}
+
+func (s *fleetStore) ListOrganizations() []organization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]organization, 0, len(s.data.Organizations))
+	for _, org := range s.data.Organizations {
+		out = append(out, *org)
+	}
+	return out
+}
+
+func (s *fleetStore) GetOrganization(id string) (*organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := s.orgLocked(id)
+	if org == nil {
+		return nil, ErrFleetNotFound
+	}
+	cp := *org
+	return &cp, nil
+}
+
+func (s *fleetStore) UpdateOrganization(id, name string) (*organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := s.orgLocked(id)
+	if org == nil {
+		return nil, ErrFleetNotFound
+	}
+	org.Name = name
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	cp := *org
+	return &cp, nil
+}
+
+// DeleteOrganization removes an organization with all its vehicles and groups.
+func (s *fleetStore) DeleteOrganization(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.orgLocked(id) == nil {
+		return ErrFleetNotFound
+	}
+
+	orgs := s.data.Organizations[:0]
+	for _, org := range s.data.Organizations {
+		if org.ID != id {
+			orgs = append(orgs, org)
+		}
+	}
+	s.data.Organizations = orgs
+
+	groups := s.data.Groups[:0]
+	for _, g := range s.data.Groups {
+		if g.OrgID != id {
+			groups = append(groups, g)
+		}
+	}
+	s.data.Groups = groups
+
+	vehicles := s.data.Vehicles[:0]
+	for _, v := range s.data.Vehicles {
+		if v.OrgID != id {
+			vehicles = append(vehicles, v)
+		}
+	}
+	s.data.Vehicles = vehicles
+
+	return s.saveLocked()
+}
+
+func (s *fleetStore) orgLocked(id string) *organization {
+	for _, org := range s.data.Organizations {
+		if org.ID == id {
+			return org
+		}
+	}
+	return nil
+}
+
+// ---- groups ----
+
+func (s *fleetStore) CreateGroup(orgID, name, description string) (*vehicleGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.orgLocked(orgID) == nil {
+		return nil, ErrFleetNotFound
+	}
+
+	g := &vehicleGroup{ID: newID(), OrgID: orgID, Name: name, Description: description, CreatedAt: time.Now()}
+	s.data.Groups = append(s.data.Groups, g)
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	cp := *g
+	return &cp, nil
+}
+
+func (s *fleetStore) ListGroups(orgID string) ([]vehicleGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.orgLocked(orgID) == nil {
+		return nil, ErrFleetNotFound
+	}
+
+	out := []vehicleGroup{}
+	for _, g := range s.data.Groups {
+		if g.OrgID == orgID {
+			out = append(out, *g)
+		}
+	}
+	return out, nil
+}
+
+func (s *fleetStore) GetGroup(orgID, id string) (*vehicleGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupLocked(orgID, id)
+	if g == nil {
+		return nil, ErrFleetNotFound
+	}
+	cp := *g
+	return &cp, nil
+}
+
+func (s *fleetStore) UpdateGroup(orgID, id, name, description string) (*vehicleGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupLocked(orgID, id)
+	if g == nil {
+		return nil, ErrFleetNotFound
+	}
+	g.Name = name
+	g.Description = description
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	cp := *g
+	return &cp, nil
+}
+
+// DeleteGroup removes a group and takes its vehicles out of it.
+func (s *fleetStore) DeleteGroup(orgID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.groupLocked(orgID, id) == nil {
+		return ErrFleetNotFound
+	}
+
+	groups := s.data.Groups[:0]
+	for _, g := range s.data.Groups {
+		if g.ID != id {
+			groups = append(groups, g)
+		}
+	}
+	s.data.Groups = groups
+
+	for _, v := range s.data.Vehicles {
+		if v.OrgID == orgID {
+			v.GroupIDs = removeString(v.GroupIDs, id)
+		}
+	}
+	return s.saveLocked()
+}
+
+func (s *fleetStore) groupLocked(orgID, id string) *vehicleGroup {
+	for _, g := range s.data.Groups {
+		if g.OrgID == orgID && g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
+
+func (d *fleetData) groupByName(orgID, name string) *vehicleGroup {
+	for _, g := range d.Groups {
+		if g.OrgID == orgID && strings.EqualFold(g.Name, name) {
+			return g
+		}
+	}
+	return nil
+}
+
+// ---- vehicles ----
+
+// CreateVehicle adds a vehicle to an organization. Plates are unique per
+// organization.
+func (s *fleetStore) CreateVehicle(orgID string, v fleetVehicle) (*fleetVehicle, error) {
+	if err := normalizeVehicle(&v); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.orgLocked(orgID) == nil {
+		return nil, ErrFleetNotFound
+	}
+	if s.vehicleByPlateLocked(orgID, v.Plate) != nil {
+		return nil, ErrDuplicatePlate
+	}
+	if err := s.checkGroupsLocked(orgID, v.GroupIDs); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	nv := &fleetVehicle{
+		ID:          newID(),
+		OrgID:       orgID,
+		Plate:       v.Plate,
+		VehicleType: v.VehicleType,
+		Driver:      v.Driver,
+		Notes:       v.Notes,
+		GroupIDs:    append([]string{}, v.GroupIDs...),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	s.data.Vehicles = append(s.data.Vehicles, nv)
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	cp := *nv
+	return &cp, nil
+}
+
+// ListVehicles returns the vehicles of an organization, optionally only those
+// in groupID.
+func (s *fleetStore) ListVehicles(orgID, groupID string) ([]fleetVehicle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.orgLocked(orgID) == nil {
+		return nil, ErrFleetNotFound
+	}
+
+	out := []fleetVehicle{}
+	for _, v := range s.data.Vehicles {
+		if v.OrgID != orgID {
+			continue
+		}
+		if groupID != "" && !slices.Contains(v.GroupIDs, groupID) {
+			continue
+		}
+		out = append(out, *v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Plate < out[j].Plate })
+	return out, nil
+}
+
+func (s *fleetStore) GetVehicle(orgID, id string) (*fleetVehicle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := s.vehicleLocked(orgID, id)
+	if v == nil {
+		return nil, ErrFleetNotFound
+	}
+	cp := *v
+	return &cp, nil
+}
+
+// UpdateVehicle replaces the editable fields of a vehicle. Changing the plate
+// or vehicle type drops the stored lookup, since it no longer applies.
+func (s *fleetStore) UpdateVehicle(orgID, id string, upd fleetVehicle) (*fleetVehicle, error) {
+	if err := normalizeVehicle(&upd); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := s.vehicleLocked(orgID, id)
+	if v == nil {
+		return nil, ErrFleetNotFound
+	}
+	if other := s.vehicleByPlateLocked(orgID, upd.Plate); other != nil && other.ID != id {
+		return nil, ErrDuplicatePlate
+	}
+	if err := s.checkGroupsLocked(orgID, upd.GroupIDs); err != nil {
+		return nil, err
+	}
+
+	if v.Plate != upd.Plate || v.VehicleType != upd.VehicleType {
+		v.LastLookup = nil
+	}
+	v.Plate = upd.Plate
+	v.VehicleType = upd.VehicleType
+	v.Driver = upd.Driver
+	v.Notes = upd.Notes
+	v.GroupIDs = append([]string{}, upd.GroupIDs...)
+	v.UpdatedAt = time.Now()
+
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	cp := *v
+	return &cp, nil
+}
+
+func (s *fleetStore) DeleteVehicle(orgID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.vehicleLocked(orgID, id) == nil {
+		return ErrFleetNotFound
+	}
+
+	vehicles := s.data.Vehicles[:0]
+	for _, v := range s.data.Vehicles {
+		if v.ID != id {
+			vehicles = append(vehicles, v)
+		}
+	}
+	s.data.Vehicles = vehicles
+	return s.saveLocked()
+}
+
+// SetLastLookup stores the outcome of a lookup for a vehicle.
+func (s *fleetStore) SetLastLookup(orgID, id string, lookup *vehicleLookup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := s.vehicleLocked(orgID, id)
+	if v == nil {
+		return ErrFleetNotFound
+	}
+	v.LastLookup = lookup
+	return s.saveLocked()
+}
+
+// SetLastLookups stores the outcomes of lookups for several vehicles, by
+// vehicle ID, with a single save. Vehicles that no longer exist are skipped.
+func (s *fleetStore) SetLastLookups(orgID string, lookups map[string]*vehicleLookup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for id, lookup := range lookups {
+		if v := s.vehicleLocked(orgID, id); v != nil {
+			v.LastLookup = lookup
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// ImportVehicles creates or updates vehicles by plate. Group names that don't
+// exist yet are created. It stops at the first invalid row and saves nothing.
+func (s *fleetStore) ImportVehicles(orgID string, rows []fleetImportRow) (created, updated int, err error) {
+	for i := range rows {
+		if err := normalizeVehicle(&rows[i].Vehicle); err != nil {
+			return 0, 0, fmt.Errorf("line %d: %w", rows[i].Line, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.orgLocked(orgID) == nil {
+		return 0, 0, ErrFleetNotFound
+	}
+
+	// The rows are applied to a copy, which replaces the data once it is
+	// saved: a failed save leaves the store as it was
+	next := fleetData{
+		Organizations: s.data.Organizations,
+		Groups:        slices.Clone(s.data.Groups),
+		Vehicles:      slices.Clone(s.data.Vehicles),
+	}
+	now := time.Now()
+	for _, row := range rows {
+		groupIDs := []string{}
+		for _, name := range row.GroupNames {
+			g := next.groupByName(orgID, name)
+			if g == nil {
+				g = &vehicleGroup{ID: newID(), OrgID: orgID, Name: name, CreatedAt: now}
+				next.Groups = append(next.Groups, g)
+			}
+			groupIDs = append(groupIDs, g.ID)
+		}
+
+		in := row.Vehicle
+		if i := slices.IndexFunc(next.Vehicles, func(v *fleetVehicle) bool { return v.OrgID == orgID && v.Plate == in.Plate }); i >= 0 {
+			v := *next.Vehicles[i]
+			if v.VehicleType != in.VehicleType {
+				v.LastLookup = nil
+			}
+			v.VehicleType = in.VehicleType
+			v.Driver = in.Driver
+			v.Notes = in.Notes
+			v.GroupIDs = groupIDs
+			v.UpdatedAt = now
+			next.Vehicles[i] = &v
+			updated++
+			continue
+		}
+
+		next.Vehicles = append(next.Vehicles, &fleetVehicle{
+			ID:          newID(),
+			OrgID:       orgID,
+			Plate:       in.Plate,
+			VehicleType: in.VehicleType,
+			Driver:      in.Driver,
+			Notes:       in.Notes,
+			GroupIDs:    groupIDs,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		created++
+	}
+
+	if s.path != "" {
+		if err := writeJSONFile(s.path, next); err != nil {
+			return 0, 0, err
+		}
+	}
+	s.data = next
+	return created, updated, nil
+}
+
+func (s *fleetStore) vehicleLocked(orgID, id string) *fleetVehicle {
+	for _, v := range s.data.Vehicles {
+		if v.OrgID == orgID && v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *fleetStore) vehicleByPlateLocked(orgID, plate string) *fleetVehicle {
+	for _, v := range s.data.Vehicles {
+		if v.OrgID == orgID && v.Plate == plate {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *fleetStore) checkGroupsLocked(orgID string, groupIDs []string) error {
+	for _, id := range groupIDs {
+		if s.groupLocked(orgID, id) == nil {
+			return markError(ErrFleetInvalid, fmt.Errorf("unknown group: %s", id))
+		}
+	}
+	return nil
+}
+
+// removeString returns list without s, leaving list itself untouched.
+func removeString(list []string, s string) []string {
+	out := []string{}
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
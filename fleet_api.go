@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Fleet HTTP API
+// ------------------------------------------------------------------------
+
+// fleetCSVHeader is the column layout for vehicle import/export. "groups" holds
+// group names separated by ";".
+var fleetCSVHeader = []string{"plate", "vehicle_type", "driver", "notes", "groups"}
+
+// fleetImportRow is one parsed CSV line, before group names are resolved.
+type fleetImportRow struct {
+	Line       int
+	Vehicle    fleetVehicle
+	GroupNames []string
+}
+
+type fleetAPI struct {
+	store       *fleetStore
+	lookup      func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	concurrency int // max lookups in flight during a refresh
+}
+
+func newFleetAPI(store *fleetStore) *fleetAPI {
+	return &fleetAPI{store: store, lookup: lookupViolations, concurrency: defaultBatchConcurrency}
+}
+
+func (api *fleetAPI) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /fleet/organizations", api.createOrganization)
+	mux.HandleFunc("GET /fleet/organizations", api.listOrganizations)
+	mux.HandleFunc("GET /fleet/organizations/{orgID}", api.getOrganization)
+	mux.HandleFunc("PUT /fleet/organizations/{orgID}", api.updateOrganization)
+	mux.HandleFunc("DELETE /fleet/organizations/{orgID}", api.deleteOrganization)
+
+	mux.HandleFunc("POST /fleet/organizations/{orgID}/groups", api.createGroup)
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/groups", api.listGroups)
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/groups/{groupID}", api.getGroup)
+	mux.HandleFunc("PUT /fleet/organizations/{orgID}/groups/{groupID}", api.updateGroup)
+	mux.HandleFunc("DELETE /fleet/organizations/{orgID}/groups/{groupID}", api.deleteGroup)
+
+	mux.HandleFunc("POST /fleet/organizations/{orgID}/vehicles", api.createVehicle)
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/vehicles", api.listVehicles)
+	mux.HandleFunc("POST /fleet/organizations/{orgID}/vehicles/import", api.importVehicles)
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/vehicles/export", api.exportVehicles)
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/vehicles/{vehicleID}", api.getVehicle)
+	mux.HandleFunc("PUT /fleet/organizations/{orgID}/vehicles/{vehicleID}", api.updateVehicle)
+	mux.HandleFunc("DELETE /fleet/organizations/{orgID}/vehicles/{vehicleID}", api.deleteVehicle)
+	mux.HandleFunc("POST /fleet/organizations/{orgID}/vehicles/{vehicleID}/check", api.checkVehicle)
+
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/violations", api.fleetViolations)
+	mux.HandleFunc("POST /fleet/organizations/{orgID}/violations/refresh", api.refreshViolations)
+}
+
+// maxJSONBodyBytes caps the JSON request bodies of the fleet, v1 and
+// GraphQL endpoints.
+const maxJSONBodyBytes = 1 << 20
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)).Decode(v); err != nil {
+		return fmt.Errorf("Failed to parse JSON body: %v", err)
+	}
+	return nil
+}
+
+// ---- organizations ----
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+func (req *organizationRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("Missing or empty parameter: name")
+	}
+	return nil
+}
+
+func (api *fleetAPI) createOrganization(w http.ResponseWriter, r *http.Request) {
+	var req organizationRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	org, err := api.store.CreateOrganization(req.Name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, org)
+}
+
+func (api *fleetAPI) listOrganizations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.store.ListOrganizations())
+}
+
+func (api *fleetAPI) getOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := api.store.GetOrganization(r.PathValue("orgID"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, org)
+}
+
+func (api *fleetAPI) updateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req organizationRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	org, err := api.store.UpdateOrganization(r.PathValue("orgID"), req.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, org)
+}
+
+func (api *fleetAPI) deleteOrganization(w http.ResponseWriter, r *http.Request) {
+	if err := api.store.DeleteOrganization(r.PathValue("orgID")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---- groups ----
+
+type groupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (req *groupRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" {
+		return errors.New("Missing or empty parameter: name")
+	}
+	return nil
+}
+
+func (api *fleetAPI) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req groupRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g, err := api.store.CreateGroup(r.PathValue("orgID"), req.Name, req.Description)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, g)
+}
+
+func (api *fleetAPI) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := api.store.ListGroups(r.PathValue("orgID"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (api *fleetAPI) getGroup(w http.ResponseWriter, r *http.Request) {
+	g, err := api.store.GetGroup(r.PathValue("orgID"), r.PathValue("groupID"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+func (api *fleetAPI) updateGroup(w http.ResponseWriter, r *http.Request) {
+	var req groupRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g, err := api.store.UpdateGroup(r.PathValue("orgID"), r.PathValue("groupID"), req.Name, req.Description)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+func (api *fleetAPI) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	if err := api.store.DeleteGroup(r.PathValue("orgID"), r.PathValue("groupID")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---- vehicles ----
+
+func (api *fleetAPI) createVehicle(w http.ResponseWriter, r *http.Request) {
+	var v fleetVehicle
+	if err := decodeJSONBody(w, r, &v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := api.store.CreateVehicle(r.PathValue("orgID"), v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (api *fleetAPI) listVehicles(w http.ResponseWriter, r *http.Request) {
+	vehicles, err := api.store.ListVehicles(r.PathValue("orgID"), r.URL.Query().Get("group"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, vehicles)
+}
+
+func (api *fleetAPI) getVehicle(w http.ResponseWriter, r *http.Request) {
+	v, err := api.store.GetVehicle(r.PathValue("orgID"), r.PathValue("vehicleID"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (api *fleetAPI) updateVehicle(w http.ResponseWriter, r *http.Request) {
+	var v fleetVehicle
+	if err := decodeJSONBody(w, r, &v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := api.store.UpdateVehicle(r.PathValue("orgID"), r.PathValue("vehicleID"), v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (api *fleetAPI) deleteVehicle(w http.ResponseWriter, r *http.Request) {
+	if err := api.store.DeleteVehicle(r.PathValue("orgID"), r.PathValue("vehicleID")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkVehicle runs a fresh lookup for one vehicle and stores it.
+func (api *fleetAPI) checkVehicle(w http.ResponseWriter, r *http.Request) {
+	orgID := r.PathValue("orgID")
+	v, err := api.store.GetVehicle(orgID, r.PathValue("vehicleID"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	lookup := api.checkOne(r.Context(), *v)
+	if r.Context().Err() != nil {
+		// The client is gone; keep the last complete lookup
+		return
+	}
+	if err := api.store.SetLastLookup(orgID, v.ID, lookup); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	v.LastLookup = lookup
+	writeJSON(w, http.StatusOK, v)
+}
+
+// checkOne looks a vehicle up. CheckedAt is when the result came back, so it
+// is never earlier than the matching history entry.
+func (api *fleetAPI) checkOne(ctx context.Context, v fleetVehicle) *vehicleLookup {
+	lookup := &vehicleLookup{Violations: []*CsgtData{}}
+
+	vehicleCode, err := vehicleCodeFor(v.VehicleType)
+	if err == nil {
+		var data []*CsgtData
+		data, err = api.lookup(ctx, v.Plate, vehicleCode)
+		if data != nil {
+			lookup.Violations = data
+		}
+	}
+	if err != nil {
+		lookup.Error = err.Error()
+	}
+	lookup.CheckedAt = time.Now()
+	return lookup
+}
+
+// ---- CSV import / export ----
+
+// importVehicles accepts a CSV file, either as the raw request body or as the
+// "file" field of a multipart form.
+func (api *fleetAPI) importVehicles(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Missing CSV file field: file")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	rows, err := parseFleetCSV(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, updated, err := api.store.ImportVehicles(r.PathValue("orgID"), rows)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"created": created, "updated": updated})
+}
+
+func parseFleetCSV(r io.Reader) ([]fleetImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	// Columns may come in any order; only "plate" is required
+	cols := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		cols[name] = i
+	}
+	if _, ok := cols["plate"]; !ok {
+		return nil, errors.New("CSV header must contain a \"plate\" column")
+	}
+
+	var rows []fleetImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		get := func(name string) string {
+			i, ok := cols[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		if get("plate") == "" {
+			continue
+		}
+
+		row := fleetImportRow{
+			Line: line,
+			Vehicle: fleetVehicle{
+				Plate:       get("plate"),
+				VehicleType: get("vehicle_type"),
+				Driver:      get("driver"),
+				Notes:       get("notes"),
+			},
+		}
+		for _, name := range strings.Split(get("groups"), ";") {
+			if name = strings.TrimSpace(name); name != "" {
+				row.GroupNames = append(row.GroupNames, name)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// exportVehicles handles GET .../vehicles/export. It is a download, so it
+// answers CSV (which import reads back) unless XLSX is asked for.
+func (api *fleetAPI) exportVehicles(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if format == formatJSON {
+		format = formatCSV
+	}
+
+	orgID := r.PathValue("orgID")
+	vehicles, err := api.store.ListVehicles(orgID, r.URL.Query().Get("group"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	groups, err := api.store.ListGroups(orgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeTable(w, format, exportFilename("vehicles", orgID), vehiclesTable(vehicles, groups))
+}
+
+// ---- fleet-wide violations ----
+
+type fleetVehicleViolations struct {
+	VehicleID  string      `json:"vehicle_id"`
+	Plate      string      `json:"plate"`
+	Driver     string      `json:"driver"`
+	GroupIDs   []string    `json:"group_ids"`
+	CheckedAt  *time.Time  `json:"checked_at"`
+	Error      string      `json:"error,omitempty"`
+	Violations []*CsgtData `json:"violations"`
+}
+
+type fleetViolationsView struct {
+	Organization      organization             `json:"organization"`
+	TotalVehicles     int                      `json:"total_vehicles"`
+	CheckedVehicles   int                      `json:"checked_vehicles"`
+	VehiclesWithIssue int                      `json:"vehicles_with_violations"`
+	TotalViolations   int                      `json:"total_violations"`
+	UnpaidViolations  int                      `json:"unpaid_violations"`
+	Vehicles          []fleetVehicleViolations `json:"vehicles"`
+}
+
+// fleetViolations aggregates the latest stored lookup of every vehicle.
+// ?group= restricts it to one group, ?only_violations=true hides clean vehicles.
+// It can be exported as CSV or XLSX (see negotiateFormat).
+func (api *fleetAPI) fleetViolations(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	view, err := api.buildViolationsView(r.PathValue("orgID"), r.URL.Query().Get("group"), r.URL.Query().Get("only_violations") == "true")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeViolationsView(w, format, view)
+}
+
+func writeViolationsView(w http.ResponseWriter, format string, view *fleetViolationsView) {
+	if format != formatJSON {
+		writeTable(w, format, exportFilename("violations", view.Organization.ID), fleetViolationsTable(view))
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// refreshViolations re-checks every vehicle (optionally one group) and
+// returns the updated view.
+func (api *fleetAPI) refreshViolations(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	orgID := r.PathValue("orgID")
+	groupID := r.URL.Query().Get("group")
+
+	vehicles, err := api.store.ListVehicles(orgID, groupID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := chargeLookups(r.Context(), len(vehicles)-1); err != nil {
+		writeRequestError(w, r, http.StatusTooManyRequests, err)
+		return
+	}
+	// Vehicles deleted while the refresh runs are simply skipped, and so are
+	// lookups aborted because the client went away
+	ctx := r.Context()
+	lookups := make([]*vehicleLookup, len(vehicles))
+	forEachBounded(len(vehicles), api.concurrency, func(i int) {
+		lookups[i] = api.checkOne(ctx, vehicles[i])
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	byID := make(map[string]*vehicleLookup, len(vehicles))
+	for i, v := range vehicles {
+		byID[v.ID] = lookups[i]
+	}
+	if err := api.store.SetLastLookups(orgID, byID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	view, err := api.buildViolationsView(orgID, groupID, r.URL.Query().Get("only_violations") == "true")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeViolationsView(w, format, view)
+}
+
+func (api *fleetAPI) buildViolationsView(orgID, groupID string, onlyViolations bool) (*fleetViolationsView, error) {
+	org, err := api.store.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	vehicles, err := api.store.ListVehicles(orgID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &fleetViolationsView{
+		Organization:  *org,
+		TotalVehicles: len(vehicles),
+		Vehicles:      []fleetVehicleViolations{},
+	}
+	for _, v := range vehicles {
+		entry := fleetVehicleViolations{
+			VehicleID:  v.ID,
+			Plate:      v.Plate,
+			Driver:     v.Driver,
+			GroupIDs:   v.GroupIDs,
+			Violations: []*CsgtData{},
+		}
+		if v.LastLookup != nil {
+			checkedAt := v.LastLookup.CheckedAt
+			entry.CheckedAt = &checkedAt
+			entry.Error = v.LastLookup.Error
+			if v.LastLookup.Violations != nil {
+				entry.Violations = v.LastLookup.Violations
+			}
+			view.CheckedVehicles++
+		}
+
+		if len(entry.Violations) > 0 {
+			view.VehiclesWithIssue++
+		}
+		view.TotalViolations += len(entry.Violations)
+		for _, d := range entry.Violations {
+			if isUnpaid(d) {
+				view.UnpaidViolations++
+			}
+		}
+
+		if onlyViolations && len(entry.Violations) == 0 {
+			continue
+		}
+		view.Vehicles = append(view.Vehicles, entry)
+	}
+	return view, nil
+}
+
+// isUnpaid reports whether csgt.vn still lists the violation as unresolved.
+func isUnpaid(d *CsgtData) bool {
+	return strings.Contains(strings.ToLower(d.Status), "chưa xử phạt")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFleet(t *testing.T, path string) (*fleetAPI, *http.ServeMux) {
+	t.Helper()
+	store, err := newFleetStore(path)
+	if err != nil {
+		t.Fatalf("newFleetStore failed: %v", err)
+	}
+	api := newFleetAPI(store)
+	mux := http.NewServeMux()
+	api.registerRoutes(mux)
+	return api, mux
+}
+
+func doJSON(t *testing.T, mux http.Handler, method, url, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: could not parse response %q: %v", method, url, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestFleet_CRUD(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fleet.json")
+	_, mux := newTestFleet(t, path)
+
+	var org organization
+	if code := doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"Công ty Vận tải ABC"}`, &org); code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating organization, got %d", code)
+	}
+
+	var group vehicleGroup
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/groups", `{"name":"Xe tải"}`, &group); code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating group, got %d", code)
+	}
+
+	var v fleetVehicle
+	body := `{"plate":"51k-123.45","driver":"Nguyễn Văn A","group_ids":["` + group.ID + `"]}`
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles", body, &v); code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating vehicle, got %d", code)
+	}
+	if v.Plate != "51K12345" || v.VehicleType != "oto" {
+		t.Errorf("Expected normalized plate/type, got %s/%s", v.Plate, v.VehicleType)
+	}
+
+	// Same plate twice is a conflict
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles", `{"plate":"51K12345"}`, nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate plate, got %d", code)
+	}
+	// Invalid plate is rejected
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles", `{"plate":"abc"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid plate, got %d", code)
+	}
+
+	var updated fleetVehicle
+	body = `{"plate":"51K12345","vehicle_type":"xemay","driver":"Trần Văn B"}`
+	if code := doJSON(t, mux, "PUT", "/fleet/organizations/"+org.ID+"/vehicles/"+v.ID, body, &updated); code != http.StatusOK {
+		t.Fatalf("Expected 200 updating vehicle, got %d", code)
+	}
+	if updated.Driver != "Trần Văn B" || updated.VehicleType != "xemay" {
+		t.Errorf("Update not applied: %+v", updated)
+	}
+
+	// Data survives a restart
+	_, mux2 := newTestFleet(t, path)
+	var list []fleetVehicle
+	doJSON(t, mux2, "GET", "/fleet/organizations/"+org.ID+"/vehicles", "", &list)
+	if len(list) != 1 || list[0].Driver != "Trần Văn B" {
+		t.Fatalf("Expected persisted vehicle, got %+v", list)
+	}
+
+	if code := doJSON(t, mux2, "DELETE", "/fleet/organizations/"+org.ID, "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 deleting organization, got %d", code)
+	}
+	if code := doJSON(t, mux2, "GET", "/fleet/organizations/"+org.ID+"/vehicles/"+v.ID, "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", code)
+	}
+}
+
+func TestFleet_FailedSaveIsServerError(t *testing.T) {
+	api, mux := newTestFleet(t, "")
+	var org organization
+	doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"Fleet"}`, &org)
+	doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", "plate,driver\n51K12345,Nguyễn Văn A\n", nil)
+
+	// A file where the directory should be: every save fails
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	os.WriteFile(blocked, nil, 0o600)
+	api.store.path = filepath.Join(blocked, "fleet.json")
+
+	body := "plate,driver,groups\n51K12345,Trần Văn B,Xe tải\n98E171478,Lê Văn C,\n"
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", body, nil); code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the save fails, got %d", code)
+	}
+	vehicles, _ := api.store.ListVehicles(org.ID, "")
+	groups, _ := api.store.ListGroups(org.ID)
+	if len(vehicles) != 1 || vehicles[0].Driver != "Nguyễn Văn A" || len(groups) != 0 {
+		t.Errorf("Expected the failed import to change nothing, got %+v %+v", vehicles, groups)
+	}
+
+	// Validation errors stay 400
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles", `{"plate":"51K12346","group_ids":["nope"]}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown group, got %d", code)
+	}
+	if code := doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"`+strings.Repeat("x", maxJSONBodyBytes)+`"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an oversized body, got %d", code)
+	}
+
+	// The refreshed lookups are saved together, and a failed save is reported
+	api.lookup = stubLookup(nil)
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/violations/refresh", "", nil); code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when saving the refreshed lookups fails, got %d", code)
+	}
+}
+
+func TestFleet_CSVImportExport(t *testing.T) {
+	_, mux := newTestFleet(t, "")
+
+	var org organization
+	doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"Fleet"}`, &org)
+
+	csvBody := "\ufeffplate,vehicle_type,driver,notes,groups\n" +
+		"51K-123.45,oto,Nguyễn Văn A,\"ghi chú, có dấu phẩy\",Xe tải;Miền Nam\n" +
+		"98E1-714.78,xemay,Trần Văn B,,Miền Nam\n"
+
+	var result map[string]int
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", csvBody, &result); code != http.StatusOK {
+		t.Fatalf("Expected 200 importing CSV, got %d", code)
+	}
+	if result["created"] != 2 || result["updated"] != 0 {
+		t.Errorf("Unexpected import result: %v", result)
+	}
+
+	var groups []vehicleGroup
+	doJSON(t, mux, "GET", "/fleet/organizations/"+org.ID+"/groups", "", &groups)
+	if len(groups) != 2 {
+		t.Errorf("Expected groups to be created from CSV, got %+v", groups)
+	}
+
+	// Re-importing updates by plate
+	doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", "plate,driver\n51K12345,Lê Văn C\n", &result)
+	if result["created"] != 0 || result["updated"] != 1 {
+		t.Errorf("Expected update on re-import, got %v", result)
+	}
+
+	// An invalid row rejects the whole file
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", "plate\n51K12345\nxyz\n", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid row, got %d", code)
+	}
+
+	req := httptest.NewRequest("GET", "/fleet/organizations/"+org.ID+"/vehicles/export", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	out := rec.Body.String()
+	if !strings.HasPrefix(out, utf8BOM+"plate,vehicle_type,driver,notes,groups\r\n") || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("Unexpected CSV header: %q", out)
+	}
+	if !strings.Contains(out, `51K12345,oto,Lê Văn C,`) || !strings.Contains(out, "98E171478,xemay,Trần Văn B,,Miền Nam") {
+		t.Errorf("Unexpected CSV body: %q", out)
+	}
+
+	// The export can be imported as is
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", out, &result); code != http.StatusOK || result["updated"] != 2 {
+		t.Errorf("Expected the export to import back, got %d %v", code, result)
+	}
+
+	req = httptest.NewRequest("GET", "/fleet/organizations/"+org.ID+"/vehicles/export?format=xlsx", nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mimeXLSX {
+		t.Errorf("Expected an XLSX file, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestFleet_ViolationsView(t *testing.T) {
+	api, mux := newTestFleet(t, "")
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		if plate == "51K12345" {
+			return []*CsgtData{
+				{Plate: plate, Status: "Chưa xử phạt"},
+				{Plate: plate, Status: "Đã xử phạt"},
+			}, nil
+		}
+		return nil, nil
+	}
+
+	var org organization
+	doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"Fleet"}`, &org)
+	doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", "plate\n51K12345\n30A99999\n", nil)
+
+	var view fleetViolationsView
+	doJSON(t, mux, "GET", "/fleet/organizations/"+org.ID+"/violations", "", &view)
+	if view.TotalVehicles != 2 || view.CheckedVehicles != 0 {
+		t.Fatalf("Expected 2 unchecked vehicles, got %+v", view)
+	}
+
+	if code := doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/violations/refresh", "", &view); code != http.StatusOK {
+		t.Fatalf("Expected 200 refreshing, got %d", code)
+	}
+	if view.CheckedVehicles != 2 || view.TotalViolations != 2 || view.UnpaidViolations != 1 || view.VehiclesWithIssue != 1 {
+		t.Errorf("Unexpected aggregate: %+v", view)
+	}
+
+	doJSON(t, mux, "GET", "/fleet/organizations/"+org.ID+"/violations?only_violations=true", "", &view)
+	if len(view.Vehicles) != 1 || view.Vehicles[0].Plate != "51K12345" {
+		t.Errorf("Expected only the vehicle with violations, got %+v", view.Vehicles)
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// ------------------------------------------------------------------------
+// GraphQL: nested queries over lookups, fleets and history
+// ------------------------------------------------------------------------
+
+const graphQLSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+enum VehicleType {
+	OTO
+	XEMAY
+}
+
+type Query {
+	# Looks the plate up through the primary source and the csgt.vn fallback.
+	plate(plate: String!, vehicleType: VehicleType = OTO): PlateLookup!
+	organizations: [Organization!]!
+	organization(id: ID!): Organization
+	history(plate: String!, limit: Int = 20): [HistoryEntry!]!
+}
+
+type PlateLookup {
+	plate: String!
+	vehicleType: VehicleType!
+	# Null when the lookup failed; see errors.
+	violations: [Violation!]
+	history(limit: Int = 20): [HistoryEntry!]!
+}
+
+type Violation {
+	plate: String!
+	plateColor: String!
+	vehicleType: String!
+	violationTime: String!
+	violationPlace: String!
+	violationAction: String!
+	status: String!
+	detectedBy: String!
+	resolutionLocation: String!
+	unpaid: Boolean!
+	resolutionOffices: [ResolutionOffice!]!
+}
+
+type ResolutionOffice {
+	name: String!
+	address: String
+	phone: String
+}
+
+type Organization {
+	id: ID!
+	name: String!
+	createdAt: Time!
+	groups: [Group!]!
+	vehicles(group: ID): [Vehicle!]!
+}
+
+type Group {
+	id: ID!
+	name: String!
+	description: String!
+	createdAt: Time!
+	vehicles: [Vehicle!]!
+}
+
+type Vehicle {
+	id: ID!
+	plate: String!
+	vehicleType: VehicleType!
+	driver: String!
+	notes: String!
+	groups: [Group!]!
+	lastLookup: StoredLookup
+	# The stored lookup, or a live one when there is none yet or refresh is
+	# set. Live lookups are stored as the vehicle's last lookup. Null when
+	# the lookup failed; see errors.
+	violations(refresh: Boolean = false): [Violation!]
+	history(limit: Int = 20): [HistoryEntry!]!
+}
+
+type StoredLookup {
+	checkedAt: Time!
+	error: String
+	violations: [Violation!]!
+}
+
+type HistoryEntry {
+	id: ID!
+	plate: String!
+	vehicleType: VehicleType!
+	source: String!
+	checkedAt: Time!
+	violations: [Violation!]!
+}
+`
+
+// lookupBatchWait is how long the loader collects lookups before running
+// them. Sibling fields resolve in parallel, so a few milliseconds is enough
+// for a whole fleet to queue up.
+const lookupBatchWait = 5 * time.Millisecond
+
+// graphQLMaxDepth allows the deepest useful query, organization > groups >
+// vehicles > history > violations > resolutionOffices, but not endless
+// groups > vehicles > groups cycles.
+const graphQLMaxDepth = 8
+
+// errTooManyPlates is returned for the plates of a query beyond the first
+// maxBatchSize, as a batch that large is refused.
+var errTooManyPlates = fmt.Errorf("too many plates in one query (max %d)", maxBatchSize)
+
+type graphQLAPI struct {
+	schema      *graphql.Schema
+	fleet       *fleetStore   // nil hides organizations
+	history     *historyStore // nil means no history
+	lookup      func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	concurrency int // max lookups in flight per request
+}
+
+func newGraphQLAPI(fleet *fleetStore, history *historyStore, concurrency int) *graphQLAPI {
+	api := &graphQLAPI{fleet: fleet, history: history, lookup: lookupViolations, concurrency: concurrency}
+	api.schema = graphql.MustParseSchema(graphQLSchema, &gqlQuery{api}, graphql.MaxParallelism(maxBatchSize), graphql.MaxDepth(graphQLMaxDepth))
+	return api
+}
+
+func (api *graphQLAPI) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /graphql", api.serveGraphQL)
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// serveGraphQL handles POST /graphql. Every request gets its own loader, so
+// results are never shared between requests.
+func (api *graphQLAPI) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loader := &lookupLoader{ctx: r.Context(), lookup: api.lookup, concurrency: api.concurrency, wait: lookupBatchWait, limit: maxBatchSize}
+	ctx := context.WithValue(r.Context(), lookupLoaderKey{}, loader)
+	writeJSON(w, http.StatusOK, api.schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+// ---- lookup loader ----
+
+type lookupLoaderKey struct{}
+
+type lookupKey struct {
+	plate, vehicleCode string
+}
+
+type lookupCall struct {
+	key  lookupKey
+	done chan struct{}
+	data []*CsgtData
+	err  error
+}
+
+// lookupLoader batches the lookups of one GraphQL request, dataloader style:
+// calls made within the wait window run together with bounded concurrency,
+// and each plate is looked up at most once per request, and at most limit
+// plates are. The lookups run under ctx, the request's context, so they stop
+// when the client goes away.
+type lookupLoader struct {
+	ctx         context.Context
+	lookup      func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	concurrency int
+	wait        time.Duration
+	limit       int // 0 means no limit
+
+	mu      sync.Mutex
+	calls   map[lookupKey]*lookupCall
+	pending []*lookupCall
+}
+
+func loaderFrom(ctx context.Context) (*lookupLoader, error) {
+	l, ok := ctx.Value(lookupLoaderKey{}).(*lookupLoader)
+	if !ok {
+		return nil, errors.New("graphql: no lookup loader for this request")
+	}
+	return l, nil
+}
+
+func (l *lookupLoader) Load(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+	key := lookupKey{plate, vehicleCode}
+
+	l.mu.Lock()
+	if l.calls == nil {
+		l.calls = make(map[lookupKey]*lookupCall)
+	}
+	call, ok := l.calls[key]
+	if !ok && l.limit > 0 && len(l.calls) >= l.limit {
+		l.mu.Unlock()
+		return nil, errTooManyPlates
+	}
+	if !ok {
+		call = &lookupCall{key: key, done: make(chan struct{})}
+		l.calls[key] = call
+
+		// The request paid for its first plate, the others are charged
+		// as they come
+		if len(l.calls) > 1 {
+			l.mu.Unlock()
+			if err := chargeLookups(l.ctx, 1); err != nil {
+				call.err = err
+				close(call.done)
+				return nil, err
+			}
+			l.mu.Lock()
+		}
+		l.pending = append(l.pending, call)
+		if len(l.pending) == 1 {
+			time.AfterFunc(l.wait, l.dispatch)
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.data, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch runs every lookup queued since the last dispatch.
+func (l *lookupLoader) dispatch() {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	forEachBounded(len(batch), l.concurrency, func(i int) {
+		call := batch[i]
+		call.data, call.err = l.lookup(l.ctx, call.key.plate, call.key.vehicleCode)
+		close(call.done)
+	})
+}
+
+// gqlError carries the error model's code to the "extensions" of a GraphQL
+// error.
+type gqlError struct {
+	err error
+	e   *apiError
+}
+
+func newGQLError(err error, fallbackStatus int) error {
+	_, e := apiErrorFor(err, fallbackStatus)
+	return &gqlError{err: err, e: e}
+}
+
+func (e *gqlError) Error() string { return e.e.Message }
+func (e *gqlError) Unwrap() error { return e.err }
+
+func (e *gqlError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.e.Code, "retryable": e.e.Retryable}
+	if e.e.RetryAfter > 0 {
+		ext["retry_after"] = e.e.RetryAfter
+	}
+	return ext
+}
+
+// ---- resolvers ----
+
+func vehicleTypeToGQL(vehicleType string) string {
+	if vehicleType == "xemay" {
+		return "XEMAY"
+	}
+	return "OTO"
+}
+
+type gqlQuery struct {
+	api *graphQLAPI
+}
+
+func (q *gqlQuery) Plate(args struct {
+	Plate       string
+	VehicleType string
+}) (*gqlPlateLookup, error) {
+	plate, vehicleCode, err := parseLookupInput(args.Plate, strings.ToLower(args.VehicleType))
+	if err != nil {
+		return nil, newGQLError(err, http.StatusBadRequest)
+	}
+	return &gqlPlateLookup{api: q.api, plate: plate, vehicleCode: vehicleCode}, nil
+}
+
+func (q *gqlQuery) Organizations() []*gqlOrganization {
+	out := []*gqlOrganization{}
+	if q.api.fleet == nil {
+		return out
+	}
+	for _, org := range q.api.fleet.ListOrganizations() {
+		out = append(out, &gqlOrganization{api: q.api, org: org})
+	}
+	return out
+}
+
+func (q *gqlQuery) Organization(args struct{ ID graphql.ID }) (*gqlOrganization, error) {
+	if q.api.fleet == nil {
+		return nil, nil
+	}
+	org, err := q.api.fleet.GetOrganization(string(args.ID))
+	if errors.Is(err, ErrFleetNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newGQLError(err, http.StatusInternalServerError)
+	}
+	return &gqlOrganization{api: q.api, org: *org}, nil
+}
+
+func (q *gqlQuery) History(args struct {
+	Plate string
+	Limit int32
+}) ([]*gqlHistoryEntry, error) {
+	plate, err := processPlate(args.Plate)
+	if err != nil {
+		return nil, newGQLError(err, http.StatusBadRequest)
+	}
+	return q.api.historyOf(plate, args.Limit), nil
+}
+
+func (api *graphQLAPI) historyOf(plate string, limit int32) []*gqlHistoryEntry {
+	out := []*gqlHistoryEntry{}
+	if api.history == nil {
+		return out
+	}
+	for _, e := range api.history.List(plate, int(limit)) {
+		out = append(out, &gqlHistoryEntry{e})
+	}
+	return out
+}
+
+type gqlPlateLookup struct {
+	api         *graphQLAPI
+	plate       string
+	vehicleCode string
+}
+
+func (p *gqlPlateLookup) Plate() string { return p.plate }
+func (p *gqlPlateLookup) VehicleType() string {
+	return vehicleTypeToGQL(vehicleTypeName(p.vehicleCode))
+}
+
+func (p *gqlPlateLookup) Violations(ctx context.Context) (*[]*gqlViolation, error) {
+	loader, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, newGQLError(err, http.StatusInternalServerError)
+	}
+	data, err := loader.Load(ctx, p.plate, p.vehicleCode)
+	if errors.Is(err, errTooManyPlates) || errors.Is(err, ErrRateLimited) {
+		return nil, newGQLError(err, http.StatusBadRequest)
+	}
+	if err != nil {
+		return nil, newGQLError(err, http.StatusBadGateway)
+	}
+	return gqlViolations(data), nil
+}
+
+func (p *gqlPlateLookup) History(args struct{ Limit int32 }) []*gqlHistoryEntry {
+	return p.api.historyOf(p.plate, args.Limit)
+}
+
+type gqlViolation struct {
+	d *CsgtData
+}
+
+func gqlViolations(data []*CsgtData) *[]*gqlViolation {
+	out := make([]*gqlViolation, 0, len(data))
+	for _, d := range data {
+		out = append(out, &gqlViolation{d})
+	}
+	return &out
+}
+
+func (v *gqlViolation) Plate() string              { return v.d.Plate }
+func (v *gqlViolation) PlateColor() string         { return v.d.PlateColor }
+func (v *gqlViolation) VehicleType() string        { return v.d.VehicleType }
+func (v *gqlViolation) ViolationTime() string      { return v.d.ViolationTime }
+func (v *gqlViolation) ViolationPlace() string     { return v.d.ViolationPlace }
+func (v *gqlViolation) ViolationAction() string    { return v.d.ViolationAction }
+func (v *gqlViolation) Status() string             { return v.d.Status }
+func (v *gqlViolation) DetectedBy() string         { return v.d.DetectedBy }
+func (v *gqlViolation) ResolutionLocation() string { return v.d.ResolutionLocation }
+func (v *gqlViolation) Unpaid() bool               { return isUnpaid(v.d) }
+
+func (v *gqlViolation) ResolutionOffices() []*gqlResolutionOffice {
+	out := []*gqlResolutionOffice{}
+	for _, o := range parseResolutionOffices(v.d.ResolutionLocation) {
+		out = append(out, &gqlResolutionOffice{o})
+	}
+	return out
+}
+
+type gqlResolutionOffice struct {
+	o resolutionOffice
+}
+
+func (o *gqlResolutionOffice) Name() string     { return o.o.Name }
+func (o *gqlResolutionOffice) Address() *string { return optionalString(o.o.Address) }
+func (o *gqlResolutionOffice) Phone() *string   { return optionalString(o.o.Phone) }
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type gqlOrganization struct {
+	api *graphQLAPI
+	org organization
+}
+
+func (o *gqlOrganization) ID() graphql.ID          { return graphql.ID(o.org.ID) }
+func (o *gqlOrganization) Name() string            { return o.org.Name }
+func (o *gqlOrganization) CreatedAt() graphql.Time { return graphql.Time{Time: o.org.CreatedAt} }
+
+func (o *gqlOrganization) Groups() ([]*gqlGroup, error) {
+	groups, err := o.api.fleet.ListGroups(o.org.ID)
+	if err != nil {
+		return nil, newGQLError(err, http.StatusInternalServerError)
+	}
+	out := []*gqlGroup{}
+	for _, g := range groups {
+		out = append(out, &gqlGroup{api: o.api, g: g})
+	}
+	return out, nil
+}
+
+func (o *gqlOrganization) Vehicles(args struct{ Group *graphql.ID }) ([]*gqlVehicle, error) {
+	groupID := ""
+	if args.Group != nil {
+		groupID = string(*args.Group)
+	}
+	return o.api.vehiclesOf(o.org.ID, groupID)
+}
+
+func (api *graphQLAPI) vehiclesOf(orgID, groupID string) ([]*gqlVehicle, error) {
+	vehicles, err := api.fleet.ListVehicles(orgID, groupID)
+	if err != nil {
+		return nil, newGQLError(err, http.StatusInternalServerError)
+	}
+	out := []*gqlVehicle{}
+	for _, v := range vehicles {
+		out = append(out, &gqlVehicle{api: api, v: v})
+	}
+	return out, nil
+}
+
+type gqlGroup struct {
+	api *graphQLAPI
+	g   vehicleGroup
+}
+
+func (g *gqlGroup) ID() graphql.ID          { return graphql.ID(g.g.ID) }
+func (g *gqlGroup) Name() string            { return g.g.Name }
+func (g *gqlGroup) Description() string     { return g.g.Description }
+func (g *gqlGroup) CreatedAt() graphql.Time { return graphql.Time{Time: g.g.CreatedAt} }
+
+func (g *gqlGroup) Vehicles() ([]*gqlVehicle, error) {
+	return g.api.vehiclesOf(g.g.OrgID, g.g.ID)
+}
+
+type gqlVehicle struct {
+	api *graphQLAPI
+	v   fleetVehicle
+}
+
+func (v *gqlVehicle) ID() graphql.ID      { return graphql.ID(v.v.ID) }
+func (v *gqlVehicle) Plate() string       { return v.v.Plate }
+func (v *gqlVehicle) VehicleType() string { return vehicleTypeToGQL(v.v.VehicleType) }
+func (v *gqlVehicle) Driver() string      { return v.v.Driver }
+func (v *gqlVehicle) Notes() string       { return v.v.Notes }
+
+func (v *gqlVehicle) Groups() []*gqlGroup {
+	out := []*gqlGroup{}
+	for _, id := range v.v.GroupIDs {
+		// Groups deleted since the vehicle was listed are skipped
+		if g, err := v.api.fleet.GetGroup(v.v.OrgID, id); err == nil {
+			out = append(out, &gqlGroup{api: v.api, g: *g})
+		}
+	}
+	return out
+}
+
+func (v *gqlVehicle) LastLookup() *gqlStoredLookup {
+	if v.v.LastLookup == nil {
+		return nil
+	}
+	return &gqlStoredLookup{v.v.LastLookup}
+}
+
+func (v *gqlVehicle) Violations(ctx context.Context, args struct{ Refresh bool }) (*[]*gqlViolation, error) {
+	if !args.Refresh && v.v.LastLookup != nil && v.v.LastLookup.Error == "" {
+		return gqlViolations(v.v.LastLookup.Violations), nil
+	}
+
+	vehicleCode, err := vehicleCodeFor(v.v.VehicleType)
+	if err != nil {
+		return nil, newGQLError(err, http.StatusBadRequest)
+	}
+	loader, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, newGQLError(err, http.StatusInternalServerError)
+	}
+	data, err := loader.Load(ctx, v.v.Plate, vehicleCode)
+	if errors.Is(err, errTooManyPlates) || errors.Is(err, ErrRateLimited) {
+		// Not looked up, keep the stored lookup
+		return nil, newGQLError(err, http.StatusBadRequest)
+	}
+	if ctx.Err() != nil {
+		// Aborted, keep the stored lookup
+		return nil, newGQLError(ctx.Err(), http.StatusGatewayTimeout)
+	}
+
+	lookup := &vehicleLookup{CheckedAt: time.Now(), Violations: []*CsgtData{}}
+	if data != nil {
+		lookup.Violations = data
+	}
+	if err != nil {
+		lookup.Error = err.Error()
+	}
+	// The vehicle may have been deleted meanwhile; the result is still returned
+	_ = v.api.fleet.SetLastLookup(v.v.OrgID, v.v.ID, lookup)
+
+	if err != nil {
+		return nil, newGQLError(err, http.StatusBadGateway)
+	}
+	return gqlViolations(data), nil
+}
+
+func (v *gqlVehicle) History(args struct{ Limit int32 }) []*gqlHistoryEntry {
+	return v.api.historyOf(v.v.Plate, args.Limit)
+}
+
+type gqlStoredLookup struct {
+	l *vehicleLookup
+}
+
+func (l *gqlStoredLookup) CheckedAt() graphql.Time     { return graphql.Time{Time: l.l.CheckedAt} }
+func (l *gqlStoredLookup) Error() *string              { return optionalString(l.l.Error) }
+func (l *gqlStoredLookup) Violations() []*gqlViolation { return *gqlViolations(l.l.Violations) }
+
+type gqlHistoryEntry struct {
+	e *historyEntry
+}
+
+func (h *gqlHistoryEntry) ID() graphql.ID              { return graphql.ID(h.e.ID) }
+func (h *gqlHistoryEntry) Plate() string               { return h.e.Plate }
+func (h *gqlHistoryEntry) VehicleType() string         { return vehicleTypeToGQL(h.e.VehicleType) }
+func (h *gqlHistoryEntry) Source() string              { return h.e.Source }
+func (h *gqlHistoryEntry) CheckedAt() graphql.Time     { return graphql.Time{Time: h.e.CheckedAt} }
+func (h *gqlHistoryEntry) Violations() []*gqlViolation { return *gqlViolations(h.e.Violations) }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Path       []interface{}          `json:"path"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func postGraphQL(t *testing.T, api *graphQLAPI, query string, out interface{}) graphQLResponse {
+	t.Helper()
+	mux := http.NewServeMux()
+	api.registerRoutes(mux)
+
+	body, _ := json.Marshal(graphQLRequest{Query: query})
+	var resp graphQLResponse
+	if code := doJSON(t, mux, "POST", "/graphql", string(body), &resp); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if out != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			t.Fatalf("Could not parse data %s: %v", resp.Data, err)
+		}
+	}
+	return resp
+}
+
+func TestGraphQL_FleetQueryBatchesLookups(t *testing.T) {
+	fleet, _ := newFleetStore("")
+	org, _ := fleet.CreateOrganization("Công ty Vận tải ABC")
+	group, _ := fleet.CreateGroup(org.ID, "Xe tải", "")
+	for _, plate := range []string{"51K12345", "51K12346", "51K12347"} {
+		if _, err := fleet.CreateVehicle(org.ID, fleetVehicle{Plate: plate, GroupIDs: []string{group.ID}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, _ := newHistoryStore("", 0)
+	history.Record("51K12345", "1", sourceCSGT, nil)
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	api := newGraphQLAPI(fleet, history, 2)
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		mu.Lock()
+		calls[plate]++
+		mu.Unlock()
+		if plate == "51K12347" {
+			return nil, fmt.Errorf("%w: %w", ErrFallbackFailed, ErrCaptchaRejected)
+		}
+		return []*CsgtData{{
+			Plate:              plate,
+			Status:             "Chưa xử phạt",
+			ResolutionLocation: "1. Đội CSGT số 1\nĐịa chỉ: số 384 đường Xương Giang\nSố điện thoại liên hệ: 0911595121",
+		}}, nil
+	}
+
+	// The same plate is asked for twice; both the vehicle list and the group
+	// list hold every vehicle.
+	query := fmt.Sprintf(`{
+		plate(plate: "51K-123.45") { violations { plate } }
+		organization(id: %q) {
+			name
+			vehicles { plate groups { name } violations { unpaid resolutionOffices { name address phone } } history { source } }
+			groups { vehicles { plate violations { plate } } }
+		}
+	}`, org.ID)
+	var data struct {
+		Plate struct {
+			Violations []struct{ Plate string }
+		}
+		Organization struct {
+			Name     string
+			Vehicles []struct {
+				Plate      string
+				Groups     []struct{ Name string }
+				Violations *[]struct {
+					Unpaid            bool
+					ResolutionOffices []resolutionOffice
+				}
+				History []struct{ Source string }
+			}
+		}
+	}
+	resp := postGraphQL(t, api, query, &data)
+
+	for plate, n := range calls {
+		if n != 1 {
+			t.Errorf("Plate %s looked up %d times, expected once", plate, n)
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("Expected 3 distinct lookups, got %v", calls)
+	}
+
+	if len(data.Plate.Violations) != 1 || data.Organization.Name != "Công ty Vận tải ABC" || len(data.Organization.Vehicles) != 3 {
+		t.Fatalf("Unexpected data %+v", data)
+	}
+	first := data.Organization.Vehicles[0]
+	if first.Violations == nil || len(*first.Violations) != 1 || !(*first.Violations)[0].Unpaid {
+		t.Fatalf("Unexpected violations for %s: %+v", first.Plate, first.Violations)
+	}
+	office := (*first.Violations)[0].ResolutionOffices
+	if len(office) != 1 || office[0] != (resolutionOffice{"Đội CSGT số 1", "số 384 đường Xương Giang", "0911595121"}) {
+		t.Errorf("Unexpected resolution offices %+v", office)
+	}
+	if len(first.Groups) != 1 || first.Groups[0].Name != "Xe tải" || len(first.History) != 1 || first.History[0].Source != sourceCSGT {
+		t.Errorf("Unexpected groups or history for %s: %+v", first.Plate, first)
+	}
+
+	// The failed lookup nulls only its own field, with the error code
+	if data.Organization.Vehicles[2].Violations != nil {
+		t.Errorf("Expected null violations for the failed lookup")
+	}
+	if len(resp.Errors) == 0 || resp.Errors[0].Extensions["code"] != codeCaptchaFailed || resp.Errors[0].Extensions["retryable"] != true {
+		t.Errorf("Expected a captcha_failed error, got %+v", resp.Errors)
+	}
+
+	// Live results are stored on the vehicles
+	vehicles, _ := fleet.ListVehicles(org.ID, "")
+	if vehicles[0].LastLookup == nil || len(vehicles[0].LastLookup.Violations) != 1 || vehicles[2].LastLookup.Error == "" {
+		t.Errorf("Expected the lookups to be stored, got %+v / %+v", vehicles[0].LastLookup, vehicles[2].LastLookup)
+	}
+
+	// ... and reused without refresh
+	calls = make(map[string]int)
+	postGraphQL(t, api, fmt.Sprintf(`{ organization(id: %q) { vehicles { violations { plate } } } }`, org.ID), nil)
+	if len(calls) != 1 || calls["51K12347"] != 1 {
+		t.Errorf("Expected only the failed vehicle to be looked up again, got %v", calls)
+	}
+}
+
+func TestGraphQL_InvalidPlate(t *testing.T) {
+	api := newGraphQLAPI(nil, nil, 2)
+	resp := postGraphQL(t, api, `{ plate(plate: "abc") { plate } organizations { id } }`, nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != codeInvalidPlate {
+		t.Errorf("Expected an invalid_plate error, got %+v", resp.Errors)
+	}
+}
+
+func TestGraphQL_Limits(t *testing.T) {
+	var mu sync.Mutex
+	looked := 0
+	api := newGraphQLAPI(nil, nil, 10)
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		mu.Lock()
+		looked++
+		mu.Unlock()
+		return nil, nil
+	}
+
+	// One alias per plate: the plates beyond maxBatchSize are refused
+	var query strings.Builder
+	query.WriteString("{")
+	for i := 0; i <= maxBatchSize; i++ {
+		fmt.Fprintf(&query, " p%d: plate(plate: \"51K%05d\") { violations { plate } }", i, 10000+i)
+	}
+	query.WriteString(" }")
+	resp := postGraphQL(t, api, query.String(), nil)
+	if looked != maxBatchSize {
+		t.Errorf("Expected %d lookups, got %d", maxBatchSize, looked)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != codeInvalidRequest {
+		t.Errorf("Expected one invalid_request error, got %+v", resp.Errors)
+	}
+
+	// groups > vehicles > groups cycles stop at the depth limit
+	fleet, _ := newFleetStore("")
+	api = newGraphQLAPI(fleet, nil, 10)
+	resp = postGraphQL(t, api, `{ organizations { groups { vehicles { groups { vehicles { groups { vehicles { groups { name } } } } } } } } }`, nil)
+	if len(resp.Errors) == 0 || !strings.Contains(resp.Errors[0].Message, "depth") {
+		t.Errorf("Expected a max depth error, got %+v", resp.Errors)
+	}
+}
+
+func TestGraphQL_NoLoader(t *testing.T) {
+	p := &gqlPlateLookup{plate: "51K12345", vehicleCode: "1"}
+	if _, err := p.Violations(context.Background()); err == nil {
+		t.Error("Expected an error without a loader, not a panic")
+	}
+}
+
+func TestParseResolutionOffices(t *testing.T) {
+	location := "1. Đội Cảnh sát giao thông, Trật tự - Công an thành phố Bắc Giang - Tỉnh Bắc Giang\n" +
+		"Địa chỉ: số 384 đường Xương Giang, phường Ngô Quyền\n" +
+		"Số điện thoại liên hệ: 0911595121\n" +
+		"2. Đội Cảnh sát giao thông, Trật tự - Công an huyện Lục Ngạn - Tỉnh Bắc Giang\n" +
+		"Địa chỉ: huyện Lục Ngạn"
+	want := []resolutionOffice{
+		{"Đội Cảnh sát giao thông, Trật tự - Công an thành phố Bắc Giang - Tỉnh Bắc Giang", "số 384 đường Xương Giang, phường Ngô Quyền", "0911595121"},
+		{"Đội Cảnh sát giao thông, Trật tự - Công an huyện Lục Ngạn - Tỉnh Bắc Giang", "huyện Lục Ngạn", ""},
+	}
+	got := parseResolutionOffices(location)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+
+	if got := parseResolutionOffices("Phòng CSGT Hà Nội"); len(got) != 1 || got[0].Name != "Phòng CSGT Hà Nội" {
+		t.Errorf("Expected one unnumbered office, got %+v", got)
+	}
+	if got := parseResolutionOffices(""); len(got) != 0 {
+		t.Errorf("Expected no offices, got %+v", got)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"henry0hai/kiemtraphatnguoi/platepb"
+)
+
+// ------------------------------------------------------------------------
+// gRPC PlateService (proto/plates.proto)
+// ------------------------------------------------------------------------
+
+// minWatchInterval keeps WatchPlate from hammering the upstream sources.
+const minWatchInterval = time.Minute
+
+type plateGRPCServer struct {
+	platepb.UnimplementedPlateServiceServer
+
+	lookup func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error)
+	batch  *batchAPI
+}
+
+func newPlateGRPCServer(concurrency int) *plateGRPCServer {
+	return &plateGRPCServer{lookup: lookupViolationsWithProgress, batch: newBatchAPI(concurrency)}
+}
+
+// newGRPCServer returns a grpc.Server serving PlateService with srv,
+// metering every RPC with clients like the HTTP API meters its requests.
+func newGRPCServer(srv *plateGRPCServer, clients *clientLimiter) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(clients.unaryInterceptor),
+		grpc.StreamInterceptor(clients.streamInterceptor),
+	)
+	platepb.RegisterPlateServiceServer(s, srv)
+	return s
+}
+
+// meter is middleware for an RPC: it identifies the client from the
+// metadata (x-api-key or "authorization: Bearer") or the peer address,
+// charges one lookup and returns the context handlers charge the rest to.
+func (l *clientLimiter) meter(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := make(http.Header, len(md))
+	for k, vs := range md {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+
+	client, tierName, err := l.identify(header, remoteAddr)
+	if err != nil {
+		return nil, grpcError(err, http.StatusUnauthorized)
+	}
+	d, err := l.take(ctx, client, tierName, 1)
+	if err != nil {
+		log.Printf("Rate limit store failed, letting %s through: %v\n", client, err)
+		return ctx, nil
+	}
+	if d.err != nil {
+		httpStatus, e := apiErrorFor(d.err, http.StatusTooManyRequests)
+		e.RetryAfter = d.resetSeconds()
+		return nil, grpcAPIError(httpStatus, e)
+	}
+	return context.WithValue(ctx, quotaClientKey{}, &quotaClient{l, client, tierName}), nil
+}
+
+func (l *clientLimiter) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := l.meter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (l *clientLimiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := l.meter(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &meteredStream{ss, ctx})
+}
+
+// meteredStream hands the metered context to stream handlers.
+type meteredStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *meteredStream) Context() context.Context { return s.ctx }
+
+// serveGRPC serves s on addr until the listener fails or s is stopped.
+func serveGRPC(addr string, s *grpc.Server) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	log.Printf("Starting gRPC server on %s...\n", addr)
+	return s.Serve(lis)
+}
+
+// stopGRPC lets the running RPCs finish, or cuts them off once ctx is done.
+func stopGRPC(ctx context.Context, s *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *plateGRPCServer) LookupPlate(ctx context.Context, req *platepb.LookupPlateRequest) (*platepb.LookupPlateResponse, error) {
+	plate, vehicleCode, err := parseLookupInput(req.GetPlate(), vehicleTypeFromProto(req.GetVehicleType()))
+	if err != nil {
+		return nil, grpcError(err, http.StatusBadRequest)
+	}
+
+	data, err := s.lookup(ctx, plate, vehicleCode, nil)
+	if err != nil {
+		log.Printf("Lookup for plate %s failed: %v\n", plate, err)
+		return nil, grpcError(err, http.StatusBadGateway)
+	}
+	return lookupResponseToProto(plate, vehicleCode, data), nil
+}
+
+func (s *plateGRPCServer) BatchLookup(ctx context.Context, req *platepb.BatchLookupRequest) (*platepb.BatchLookupResponse, error) {
+	if len(req.GetItems()) == 0 {
+		return nil, grpcError(errors.New("Batch is empty"), http.StatusBadRequest)
+	}
+	if len(req.GetItems()) > maxBatchSize {
+		return nil, grpcError(fmt.Errorf("Batch too large: %d plates (max %d)", len(req.GetItems()), maxBatchSize), http.StatusBadRequest)
+	}
+	if err := chargeLookups(ctx, len(req.GetItems())-1); err != nil {
+		return nil, grpcError(err, http.StatusTooManyRequests)
+	}
+
+	items := make([]batchItem, len(req.GetItems()))
+	for i, it := range req.GetItems() {
+		items[i] = batchItem{Plate: it.GetPlate(), VehicleType: vehicleTypeFromProto(it.GetVehicleType())}
+	}
+	resp := s.batch.run(ctx, items)
+
+	out := &platepb.BatchLookupResponse{
+		Total:     int32(resp.Total),
+		Succeeded: int32(resp.Succeeded),
+		Failed:    int32(resp.Failed),
+	}
+	for _, res := range resp.Results {
+		out.Results = append(out.Results, &platepb.BatchLookupResult{
+			Index:       int32(res.Index),
+			Input:       res.Input,
+			Plate:       res.Plate,
+			VehicleType: vehicleTypeToProto(res.VehicleType),
+			Ok:          res.Status == "ok",
+			Violations:  violationsToProto(res.Data),
+			Error:       res.Error,
+			ErrorCode:   res.Code,
+		})
+	}
+	return out, nil
+}
+
+// WatchPlate streams the stages of a lookup and its result, then, with an
+// interval, re-checks the plate and sends a result whenever it changes.
+// Only the first lookup streams its stages.
+func (s *plateGRPCServer) WatchPlate(req *platepb.WatchPlateRequest, stream grpc.ServerStreamingServer[platepb.WatchPlateEvent]) error {
+	plate, vehicleCode, err := parseLookupInput(req.GetPlate(), vehicleTypeFromProto(req.GetVehicleType()))
+	if err != nil {
+		return grpcError(err, http.StatusBadRequest)
+	}
+	interval := req.GetInterval().AsDuration()
+	if req.GetInterval() != nil && interval != 0 && interval < minWatchInterval {
+		return grpcError(fmt.Errorf("interval must be 0 or at least %s", minWatchInterval), http.StatusBadRequest)
+	}
+
+	// Stages are sent from the lookup goroutine; Send must not run concurrently
+	events := make(chan lookupEvent, 32)
+	type outcome struct {
+		data []*CsgtData
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		progress := func(ev lookupEvent) {
+			select {
+			case events <- ev:
+			default:
+			}
+		}
+		data, err := s.lookup(stream.Context(), plate, vehicleCode, progress)
+		done <- outcome{data, err}
+	}()
+
+	var res outcome
+	for waiting := true; waiting; {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case ev := <-events:
+			if err := stream.Send(stageEventToProto(ev)); err != nil {
+				return err
+			}
+		case res = <-done:
+			waiting = false
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case ev := <-events:
+			if err := stream.Send(stageEventToProto(ev)); err != nil {
+				return err
+			}
+		default:
+			drained = true
+		}
+	}
+	if res.err != nil {
+		return grpcError(res.err, http.StatusBadGateway)
+	}
+	if err := stream.Send(resultEventToProto(plate, vehicleCode, res.data)); err != nil {
+		return err
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	last := violationsFingerprint(res.data)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		data, err := s.lookup(stream.Context(), plate, vehicleCode, nil)
+		if err != nil {
+			if stream.Context().Err() != nil {
+				return nil
+			}
+			log.Printf("WatchPlate: lookup for plate %s failed: %v\n", plate, err)
+			continue
+		}
+		if fp := violationsFingerprint(data); fp != last {
+			last = fp
+			if err := stream.Send(resultEventToProto(plate, vehicleCode, data)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcError turns err into a status carrying the same error code as the
+// HTTP API (as ErrorInfo.reason) and, for retryable errors, a RetryInfo.
+// See apiErrorFor for fallbackStatus.
+func grpcError(err error, fallbackStatus int) error {
+	return grpcAPIError(apiErrorFor(err, fallbackStatus))
+}
+
+func grpcAPIError(httpStatus int, e *apiError) error {
+	code := grpcCodeForHTTP(httpStatus)
+	if code == codes.Unavailable && !e.Retryable {
+		// e.g. upstream_schema_changed: retrying will not help
+		code = codes.Internal
+	}
+	st := status.New(code, e.Message)
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: e.Code, Domain: "kiemtraphatnguoi"}}
+	if e.Retryable {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(e.RetryAfter) * time.Second)})
+	}
+	if withDetails, derr := st.WithDetails(details...); derr == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// grpcCodeForHTTP maps the HTTP statuses used by the API to gRPC codes, as
+// grpc-gateway does in the other direction.
+func grpcCodeForHTTP(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case statusClientClosedRequest:
+		return codes.Canceled
+	}
+	return codes.Internal
+}
+
+func vehicleTypeFromProto(t platepb.VehicleType) string {
+	if t == platepb.VehicleType_VEHICLE_TYPE_XEMAY {
+		return "xemay"
+	}
+	return "oto"
+}
+
+func vehicleTypeToProto(vehicleType string) platepb.VehicleType {
+	switch vehicleType {
+	case "oto":
+		return platepb.VehicleType_VEHICLE_TYPE_OTO
+	case "xemay":
+		return platepb.VehicleType_VEHICLE_TYPE_XEMAY
+	}
+	return platepb.VehicleType_VEHICLE_TYPE_UNSPECIFIED
+}
+
+func lookupResponseToProto(plate, vehicleCode string, data []*CsgtData) *platepb.LookupPlateResponse {
+	return &platepb.LookupPlateResponse{
+		Plate:       plate,
+		VehicleType: vehicleTypeToProto(vehicleTypeName(vehicleCode)),
+		Violations:  violationsToProto(data),
+	}
+}
+
+func violationsToProto(data []*CsgtData) []*platepb.Violation {
+	out := make([]*platepb.Violation, 0, len(data))
+	for _, d := range data {
+		out = append(out, &platepb.Violation{
+			Plate:              d.Plate,
+			PlateColor:         d.PlateColor,
+			VehicleType:        d.VehicleType,
+			ViolationTime:      d.ViolationTime,
+			ViolationPlace:     d.ViolationPlace,
+			ViolationAction:    d.ViolationAction,
+			Status:             d.Status,
+			DetectedBy:         d.DetectedBy,
+			ResolutionLocation: d.ResolutionLocation,
+		})
+	}
+	return out
+}
+
+func stageEventToProto(ev lookupEvent) *platepb.WatchPlateEvent {
+	return &platepb.WatchPlateEvent{Event: &platepb.WatchPlateEvent_Stage{Stage: &platepb.LookupStage{
+		Stage:   ev.Stage,
+		Attempt: int32(ev.Attempt),
+		Message: ev.Message,
+	}}}
+}
+
+func resultEventToProto(plate, vehicleCode string, data []*CsgtData) *platepb.WatchPlateEvent {
+	return &platepb.WatchPlateEvent{Event: &platepb.WatchPlateEvent_Result{Result: lookupResponseToProto(plate, vehicleCode, data)}}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"henry0hai/kiemtraphatnguoi/platepb"
+)
+
+// parityLookup answers like the real chain would, depending on the plate.
+func parityLookup(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+	progress.report(stageQueryingPrimary, "")
+	switch plate {
+	case "51K00001":
+		return nil, fmt.Errorf("%w: %w", ErrFallbackFailed, ErrCaptchaRejected)
+	case "51K00002":
+		return nil, markError(ErrUpstreamUnavailable, fmt.Errorf("server returned status code: %d", 500))
+	case "51K00003":
+		return nil, markError(ErrUpstreamSchema, fmt.Errorf("could not parse JSON from primary"))
+	case "51K00004":
+		return nil, nil
+	}
+	progress.report(stageResultsParsed, "1 violation(s)")
+	return []*CsgtData{{
+		Plate:              plate,
+		VehicleType:        "code " + vehicleCode,
+		ViolationTime:      "14:52, 06/01/2025",
+		Status:             "Chưa xử phạt",
+		ResolutionLocation: "1. Đội CSGT\nĐịa chỉ: ...",
+	}}, nil
+}
+
+// newParityServers serves the same lookup over HTTP and gRPC; gRPC clients
+// are metered by clients, or unlimited when it is nil.
+func newParityServers(t *testing.T, clients *clientLimiter) (http.Handler, platepb.PlateServiceClient) {
+	t.Helper()
+	noProgress := func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		return parityLookup(ctx, plate, vehicleCode, nil)
+	}
+
+	mux := http.NewServeMux()
+	(&plateAPI{lookup: noProgress}).registerRoutes(mux)
+	httpBatch := newBatchAPI(2)
+	httpBatch.lookup = noProgress
+	mux.HandleFunc("/checkplate/batch", httpBatch.checkPlateBatchHandler)
+
+	srv := newPlateGRPCServer(2)
+	srv.lookup = parityLookup
+	srv.batch.lookup = noProgress
+
+	if clients == nil {
+		clients = newClientLimiter(newMemoryCounterStore(), map[string]clientTier{tierAnonymous: {}}, nil)
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer(srv, clients)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return mux, platepb.NewPlateServiceClient(conn)
+}
+
+// protoViolationsJSON renders violations with proto field names, which match
+// the CsgtData JSON names.
+func protoViolationsJSON(t *testing.T, vs []*platepb.Violation) []map[string]interface{} {
+	t.Helper()
+	out := []map[string]interface{}{}
+	for _, v := range vs {
+		raw, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		_ = json.Unmarshal(raw, &m)
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestGRPC_LookupPlateParity(t *testing.T) {
+	mux, client := newParityServers(t, nil)
+
+	cases := []struct {
+		plate       string
+		vehicleType platepb.VehicleType
+		query       string
+	}{
+		{"51K-123.45", platepb.VehicleType_VEHICLE_TYPE_UNSPECIFIED, ""},
+		{"98E1-714.78", platepb.VehicleType_VEHICLE_TYPE_XEMAY, "?vehicle_type=xemay"},
+		{"51K00004", platepb.VehicleType_VEHICLE_TYPE_OTO, "?vehicle_type=oto"},
+	}
+	for _, c := range cases {
+		var env struct {
+			Data []map[string]interface{} `json:"data"`
+			Meta lookupMeta               `json:"meta"`
+		}
+		if code := doJSON(t, mux, "GET", "/v1/plates/"+c.plate+"/violations"+c.query, "", &env); code != http.StatusOK {
+			t.Fatalf("%s: HTTP lookup failed with %d", c.plate, code)
+		}
+
+		resp, err := client.LookupPlate(context.Background(), &platepb.LookupPlateRequest{Plate: c.plate, VehicleType: c.vehicleType})
+		if err != nil {
+			t.Fatalf("%s: gRPC lookup failed: %v", c.plate, err)
+		}
+
+		if resp.Plate != env.Meta.Plate || vehicleTypeFromProto(resp.VehicleType) != env.Meta.VehicleType {
+			t.Errorf("%s: gRPC %s/%s, HTTP %s/%s", c.plate, resp.Plate, resp.VehicleType, env.Meta.Plate, env.Meta.VehicleType)
+		}
+		gotJSON, _ := json.Marshal(protoViolationsJSON(t, resp.Violations))
+		wantJSON, _ := json.Marshal(env.Data)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s: violations differ\n gRPC: %s\n HTTP: %s", c.plate, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestGRPC_ErrorParity(t *testing.T) {
+	mux, client := newParityServers(t, nil)
+
+	cases := []struct {
+		plate, vehicleType string
+		protoType          platepb.VehicleType
+		grpcCode           codes.Code
+	}{
+		{"abc", "oto", platepb.VehicleType_VEHICLE_TYPE_OTO, codes.InvalidArgument},
+		{"51K00001", "oto", platepb.VehicleType_VEHICLE_TYPE_OTO, codes.Unavailable},
+		{"51K00002", "oto", platepb.VehicleType_VEHICLE_TYPE_OTO, codes.Unavailable},
+		{"51K00003", "oto", platepb.VehicleType_VEHICLE_TYPE_OTO, codes.Internal},
+	}
+	for _, c := range cases {
+		var env testEnvelope
+		httpStatus := doJSON(t, mux, "POST", "/v1/lookups", fmt.Sprintf(`{"plate":%q,"vehicle_type":%q}`, c.plate, c.vehicleType), &env)
+		if env.Error == nil {
+			t.Fatalf("%s: expected HTTP error, got %d", c.plate, httpStatus)
+		}
+
+		_, err := client.LookupPlate(context.Background(), &platepb.LookupPlateRequest{Plate: c.plate, VehicleType: c.protoType})
+		st := status.Convert(err)
+		if st.Code() != c.grpcCode {
+			t.Errorf("%s: expected gRPC %s (HTTP %d), got %s", c.plate, c.grpcCode, httpStatus, st.Code())
+		}
+		if st.Message() != env.Error.Message {
+			t.Errorf("%s: messages differ: %q vs %q", c.plate, st.Message(), env.Error.Message)
+		}
+
+		var info *errdetails.ErrorInfo
+		var retry *errdetails.RetryInfo
+		for _, d := range st.Details() {
+			switch d := d.(type) {
+			case *errdetails.ErrorInfo:
+				info = d
+			case *errdetails.RetryInfo:
+				retry = d
+			}
+		}
+		if info == nil || info.Reason != env.Error.Code {
+			t.Errorf("%s: expected ErrorInfo reason %q, got %+v", c.plate, env.Error.Code, info)
+		}
+		if env.Error.Retryable != (retry != nil) {
+			t.Errorf("%s: retryable %v over HTTP but RetryInfo %+v over gRPC", c.plate, env.Error.Retryable, retry)
+		}
+		if retry != nil && int(retry.RetryDelay.AsDuration()/time.Second) != env.Error.RetryAfter {
+			t.Errorf("%s: retry delay %s, HTTP Retry-After %d", c.plate, retry.RetryDelay.AsDuration(), env.Error.RetryAfter)
+		}
+	}
+}
+
+func TestGRPC_BatchLookupParity(t *testing.T) {
+	mux, client := newParityServers(t, nil)
+
+	var httpResp batchResponse
+	body := `[{"bienso":"51K12345"},{"bienso":"98E171478","loaixe":"xemay"},{"bienso":"bad"},{"bienso":"51K00002"}]`
+	if code := doJSON(t, mux, "POST", "/checkplate/batch", body, &httpResp); code != http.StatusOK {
+		t.Fatalf("HTTP batch failed with %d", code)
+	}
+
+	resp, err := client.BatchLookup(context.Background(), &platepb.BatchLookupRequest{Items: []*platepb.LookupPlateRequest{
+		{Plate: "51K12345"},
+		{Plate: "98E171478", VehicleType: platepb.VehicleType_VEHICLE_TYPE_XEMAY},
+		{Plate: "bad"},
+		{Plate: "51K00002"},
+	}})
+	if err != nil {
+		t.Fatalf("gRPC batch failed: %v", err)
+	}
+
+	if int(resp.Total) != httpResp.Total || int(resp.Succeeded) != httpResp.Succeeded || int(resp.Failed) != httpResp.Failed {
+		t.Errorf("Summaries differ: gRPC %d/%d/%d, HTTP %+v", resp.Total, resp.Succeeded, resp.Failed, httpResp)
+	}
+	for i, got := range resp.Results {
+		want := httpResp.Results[i]
+		if got.Plate != want.Plate || got.Ok != (want.Status == "ok") || got.Error != want.Error || got.ErrorCode != want.Code || len(got.Violations) != len(want.Data) {
+			t.Errorf("Result %d differs: gRPC %+v, HTTP %+v", i, got, want)
+		}
+	}
+
+	if _, err := client.BatchLookup(context.Background(), &platepb.BatchLookupRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for an empty batch, got %v", err)
+	}
+}
+
+func TestGRPC_WatchPlate(t *testing.T) {
+	_, client := newParityServers(t, nil)
+
+	stream, err := client.WatchPlate(context.Background(), &platepb.WatchPlateRequest{Plate: "51K-123.45"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stages []string
+	var result *platepb.LookupPlateResponse
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if s := ev.GetStage(); s != nil {
+			stages = append(stages, s.Stage)
+		}
+		if r := ev.GetResult(); r != nil {
+			result = r
+		}
+	}
+	if strings.Join(stages, ",") != stageQueryingPrimary+","+stageResultsParsed {
+		t.Errorf("Unexpected stages %v", stages)
+	}
+	if result == nil || result.Plate != "51K12345" || len(result.Violations) != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	stream, _ = client.WatchPlate(context.Background(), &platepb.WatchPlateRequest{Plate: "51K12345", Interval: durationpb.New(time.Second)})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a too short interval, got %v", err)
+	}
+
+	stream, _ = client.WatchPlate(context.Background(), &platepb.WatchPlateRequest{Plate: "51K00002"})
+	for {
+		_, err := stream.Recv()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("Expected Unavailable for a failed lookup, got %v", err)
+		}
+		break
+	}
+}
+
+func TestGRPC_Quota(t *testing.T) {
+	tiers := map[string]clientTier{tierAnonymous: {PerMinute: 2}, "small": {PerMinute: 3}}
+	_, client := newParityServers(t, newClientLimiter(newMemoryCounterStore(), tiers, map[string]string{"k-small": "small"}))
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+	lookup := &platepb.LookupPlateRequest{Plate: "51K12345", VehicleType: platepb.VehicleType_VEHICLE_TYPE_OTO}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.LookupPlate(context.Background(), lookup); err != nil {
+			t.Fatalf("Expected lookup %d to be allowed, got %v", i+1, err)
+		}
+	}
+	_, err := client.LookupPlate(context.Background(), lookup)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted over the limit, got %v", err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.RetryInfo); ok {
+			retry = d
+		}
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() <= 0 || retry.RetryDelay.AsDuration() > time.Minute {
+		t.Errorf("Expected a RetryInfo within the minute, got %+v", retry)
+	}
+	stream, _ := client.WatchPlate(context.Background(), &platepb.WatchPlateRequest{Plate: "51K12345"})
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected WatchPlate to be metered too, got %v", err)
+	}
+
+	if _, err := client.LookupPlate(withKey("nope"), lookup); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for an unknown key, got %v", err)
+	}
+
+	// A batch is charged per plate; the refused one uses up nothing
+	batch := &platepb.BatchLookupRequest{Items: []*platepb.LookupPlateRequest{{Plate: "51K12345"}, {Plate: "51K12346"}, {Plate: "51K12347"}, {Plate: "51K12348"}}}
+	if _, err := client.BatchLookup(withKey("k-small"), batch); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected a batch over the key's limit to be refused, got %v", err)
+	}
+	batch.Items = batch.Items[:2]
+	if _, err := client.BatchLookup(withKey("k-small"), batch); err != nil {
+		t.Errorf("Expected a batch within the key's limit to pass after a refused one, got %v", err)
+	}
+}
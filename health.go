@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// ------------------------------------------------------------------------
+// Health and readiness probes
+// ------------------------------------------------------------------------
+
+const (
+	dependencyOK       = "ok"
+	dependencyDegraded = "degraded"
+	dependencyDown     = "down"
+)
+
+// dependencyCheck probes one thing the server relies on. A critical
+// dependency that is down makes the server not ready; others only degrade it.
+type dependencyCheck struct {
+	Name     string
+	Kind     string // ocr, storage or upstream
+	Critical bool
+	Probe    func(ctx context.Context) (status, detail string, err error)
+}
+
+// dependencyReport is one entry of GET /readyz.
+type dependencyReport struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Critical   bool   `json:"critical"`
+	Status     string `json:"status"` // ok, degraded or down
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type readinessReport struct {
+	Status string             `json:"status"` // ready, degraded or not_ready
+	Checks []dependencyReport `json:"checks"`
+}
+
+// readiness runs its checks concurrently, each bounded by timeout.
+type readiness struct {
+	checks  []dependencyCheck
+	timeout time.Duration
+}
+
+const readinessTimeout = 2 * time.Second
+
+// report runs every check. The server is not ready when a critical
+// dependency is down, or when every upstream is: then no lookup can succeed.
+func (r *readiness) report(ctx context.Context) readinessReport {
+	reports := make([]dependencyReport, len(r.checks))
+	var wg sync.WaitGroup
+	for i, c := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, r.timeout)
+			defer cancel()
+
+			start := time.Now()
+			status, detail, err := c.Probe(ctx)
+			reports[i] = dependencyReport{
+				Name:       c.Name,
+				Kind:       c.Kind,
+				Critical:   c.Critical,
+				Status:     status,
+				Detail:     detail,
+				DurationMS: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				reports[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	rep := readinessReport{Status: "ready", Checks: reports}
+	upstreams, upstreamsDown := 0, 0
+	for _, d := range reports {
+		if d.Kind == "upstream" {
+			upstreams++
+			if d.Status == dependencyDown {
+				upstreamsDown++
+			}
+		}
+		switch {
+		case d.Status == dependencyDown && d.Critical:
+			rep.Status = "not_ready"
+		case d.Status != dependencyOK && rep.Status == "ready":
+			rep.Status = "degraded"
+		}
+	}
+	if upstreams > 0 && upstreamsDown == upstreams {
+		rep.Status = "not_ready"
+	}
+	return rep
+}
+
+// newReadiness checks Tesseract, the directories the stores write to, the
+// Redis rate limit store if one is used, and each upstream's breaker.
+func newReadiness(cfg *appConfig, clients *clientLimiter) *readiness {
+	checks := []dependencyCheck{{Name: "tesseract", Kind: "ocr", Critical: true, Probe: probeTesseract}}
+
+	files := []string{cfg.FleetFile, cfg.HistoryFile, cfg.JobsFile}
+	if cfg.Chat.TelegramToken != "" || cfg.Chat.ZaloToken != "" {
+		files = append(files, cfg.Chat.SubscriptionsFile)
+	}
+	var dirs []string
+	for _, f := range files {
+		if dir := filepath.Dir(f); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		checks = append(checks, dependencyCheck{Name: "storage:" + dir, Kind: "storage", Critical: true, Probe: probeDir(dir)})
+	}
+	// Requests are let through when Redis is down, so it only degrades
+	if p, ok := clients.store.(interface{ ping(context.Context) error }); ok {
+		checks = append(checks, dependencyCheck{Name: "rate_limit_store", Kind: "storage", Probe: func(ctx context.Context) (string, string, error) {
+			if err := p.ping(ctx); err != nil {
+				return dependencyDown, "requests are not limited", err
+			}
+			return dependencyOK, "redis", nil
+		}})
+	}
+
+	for _, source := range []string{sourcePrimary, sourceCSGT} {
+		checks = append(checks, dependencyCheck{Name: source, Kind: "upstream", Probe: probeUpstream(source)})
+	}
+	return &readiness{checks: checks, timeout: readinessTimeout}
+}
+
+// probeTesseract checks that the Tesseract runtime loads and has the English
+// data captchas are read with, and reports the OCR pool's load.
+func probeTesseract(context.Context) (string, string, error) {
+	langs, err := gosseract.GetAvailableLanguages()
+	if err != nil {
+		return dependencyDown, "", fmt.Errorf("tesseract: %w", err)
+	}
+	if !slices.Contains(langs, "eng") {
+		return dependencyDown, "", fmt.Errorf("tesseract: no eng language data (found %v)", langs)
+	}
+	detail := "tesseract " + gosseract.Version()
+	if p := currentOCRPool.Load(); p != nil {
+		detail += fmt.Sprintf(", %d/%d workers busy, %d/%d queued", p.busy.Load(), p.cfg.Workers, len(p.jobs), p.cfg.Queue)
+		if len(p.jobs) == p.cfg.Queue {
+			return dependencyDegraded, detail, fmt.Errorf("%w: queue full", ErrOCRBusy)
+		}
+	}
+	return dependencyOK, detail, nil
+}
+
+// probeDir checks that a file can be created in dir, as the stores do when
+// they save.
+func probeDir(dir string) func(context.Context) (string, string, error) {
+	return func(context.Context) (string, string, error) {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return dependencyDown, dir, fmt.Errorf("failed to create directory %q: %w", dir, err)
+		}
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return dependencyDown, dir, fmt.Errorf("directory %q is not writable: %w", dir, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+		return dependencyOK, dir, nil
+	}
+}
+
+// probeUpstream reports the source's breaker: open is down, half-open (or a
+// rate limit slowed down by the upstream) is degraded.
+func probeUpstream(source string) func(context.Context) (string, string, error) {
+	return func(context.Context) (string, string, error) {
+		b := breakers[source]
+		if b == nil {
+			return dependencyOK, "no circuit breaker", nil
+		}
+		st := b.status()
+		detail := "circuit " + st.State
+		var err error
+		if st.LastError != "" {
+			err = errors.New(st.LastError)
+		}
+		switch st.State {
+		case breakerOpen:
+			if st.RetryAt != nil {
+				detail += ", retry at " + st.RetryAt.Format(time.RFC3339)
+			}
+			return dependencyDown, detail, err
+		case breakerHalfOpen:
+			return dependencyDegraded, detail, err
+		}
+		if l := upstream.Limiter(source); l != nil {
+			if ls := l.status(); ls.Slowdowns > 0 {
+				return dependencyDegraded, fmt.Sprintf("%s, rate limit slowed down %d times", detail, ls.Slowdowns), nil
+			}
+		}
+		return dependencyOK, detail, nil
+	}
+}
+
+// ---- endpoints ----
+
+var startedAt = time.Now()
+
+type healthReport struct {
+	Status string `json:"status"`
+	Uptime string `json:"uptime"`
+}
+
+// registerHealthRoutes serves GET /healthz, which only says the process is
+// up, and GET /readyz, which probes the dependencies and answers 503 when
+// the server can't serve lookups.
+func registerHealthRoutes(mux *http.ServeMux, ready *readiness) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeEnvelope(w, http.StatusOK, healthReport{Status: "ok", Uptime: time.Since(startedAt).Round(time.Second).String()}, nil)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		rep := ready.report(r.Context())
+		status := http.StatusOK
+		if rep.Status == "not_ready" {
+			status = http.StatusServiceUnavailable
+		}
+		writeEnvelope(w, status, rep, nil)
+	})
+}
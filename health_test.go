@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func readyzReport(t *testing.T, ready *readiness) (int, readinessReport) {
+	t.Helper()
+	mux := http.NewServeMux()
+	registerHealthRoutes(mux, ready)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var resp struct {
+		Data readinessReport `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid /readyz body %s: %v", rec.Body, err)
+	}
+	return rec.Code, resp.Data
+}
+
+func fixedProbe(status string, err error) func(context.Context) (string, string, error) {
+	return func(context.Context) (string, string, error) { return status, "", err }
+}
+
+func TestHealth_Liveness(t *testing.T) {
+	mux := http.NewServeMux()
+	registerHealthRoutes(mux, &readiness{})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+}
+
+func TestHealth_ReadinessStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []dependencyCheck
+		status string
+		code   int
+	}{
+		{"all ok", []dependencyCheck{
+			{Name: "tesseract", Kind: "ocr", Critical: true, Probe: fixedProbe(dependencyOK, nil)},
+			{Name: sourcePrimary, Kind: "upstream", Probe: fixedProbe(dependencyOK, nil)},
+		}, "ready", http.StatusOK},
+		{"critical down", []dependencyCheck{
+			{Name: "tesseract", Kind: "ocr", Critical: true, Probe: fixedProbe(dependencyDown, errors.New("no eng"))},
+			{Name: sourcePrimary, Kind: "upstream", Probe: fixedProbe(dependencyOK, nil)},
+		}, "not_ready", http.StatusServiceUnavailable},
+		{"one upstream down", []dependencyCheck{
+			{Name: sourcePrimary, Kind: "upstream", Probe: fixedProbe(dependencyDown, nil)},
+			{Name: sourceCSGT, Kind: "upstream", Probe: fixedProbe(dependencyOK, nil)},
+		}, "degraded", http.StatusOK},
+		{"every upstream down", []dependencyCheck{
+			{Name: sourcePrimary, Kind: "upstream", Probe: fixedProbe(dependencyDown, nil)},
+			{Name: sourceCSGT, Kind: "upstream", Probe: fixedProbe(dependencyDown, nil)},
+		}, "not_ready", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, rep := readyzReport(t, &readiness{checks: tt.checks, timeout: time.Second})
+			if code != tt.code || rep.Status != tt.status || len(rep.Checks) != len(tt.checks) {
+				t.Errorf("Expected %s/%d, got %d %+v", tt.status, tt.code, code, rep)
+			}
+		})
+	}
+}
+
+func TestHealth_Probes(t *testing.T) {
+	saved := breakers
+	t.Cleanup(func() { breakers = saved })
+	breakers = newBreakers(func(string) (int, time.Duration) { return 1, time.Hour })
+	breakers[sourcePrimary].allow()
+	breakers[sourcePrimary].done(markError(ErrUpstreamUnavailable, errors.New("server returned status code: 503")), false)
+
+	redis := miniredis.RunT(t)
+	store, err := newRedisCounterStore("redis://" + redis.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg := defaultAppConfig()
+	cfg.FleetFile = filepath.Join(dir, "data", "fleet.json")
+	cfg.HistoryFile = filepath.Join(dir, "data", "history.json")
+	blocked := filepath.Join(dir, "blocked")
+	os.WriteFile(blocked, nil, 0o600)
+	cfg.JobsFile = filepath.Join(blocked, "jobs.json") // a file where a directory should be
+
+	_, rep := readyzReport(t, newReadiness(cfg, newClientLimiter(store, defaultClientTiers, nil)))
+	got := map[string]string{}
+	for _, c := range rep.Checks {
+		got[c.Name] = c.Status
+	}
+	want := map[string]string{
+		"tesseract":                             dependencyOK,
+		"storage:" + filepath.Join(dir, "data"): dependencyOK,
+		"storage:" + blocked:                    dependencyDown,
+		"rate_limit_store":                      dependencyOK,
+		sourcePrimary:                           dependencyDown,
+		sourceCSGT:                              dependencyOK,
+	}
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("Expected %s to be %s, got %q", name, status, got[name])
+		}
+	}
+	if rep.Status != "not_ready" {
+		t.Errorf("Expected unwritable storage to make the server not ready, got %s", rep.Status)
+	}
+
+	// Redis being down only degrades: requests are let through unlimited
+	redis.Close()
+	for _, c := range newReadiness(cfg, newClientLimiter(store, defaultClientTiers, nil)).checks {
+		if c.Name != "rate_limit_store" {
+			continue
+		}
+		if status, _, err := c.Probe(context.Background()); status != dependencyDown || c.Critical || err == nil {
+			t.Errorf("Expected a non-critical Redis probe to fail, got %s (%v)", status, err)
+		}
+	}
+}
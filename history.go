@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Lookup history
+// ------------------------------------------------------------------------
+
+// Data sources recorded with every lookup.
+const (
+	sourcePrimary = "checkphatnguoi.vn"
+	sourceCSGT    = "csgt.vn"
+)
+
+const defaultHistoryPerPlate = 50
+
+// historySaveDelay batches the lookups recorded in a burst into one write of
+// the history file.
+var historySaveDelay = 2 * time.Second
+
+// lookupHistory records every successful lookup of the chain when set (see
+// main). Nil disables recording, which is what tests get.
+var lookupHistory *historyStore
+
+// historyEntry is one successful lookup of a plate.
+type historyEntry struct {
+	ID          string      `json:"id"`
+	Plate       string      `json:"plate"`
+	VehicleType string      `json:"vehicle_type"` // "oto" or "xemay"
+	Source      string      `json:"source"`
+	CheckedAt   time.Time   `json:"checked_at"`
+	Violations  []*CsgtData `json:"violations"`
+}
+
+// historyStore keeps the latest lookups of each plate and mirrors them to a
+// JSON file, like the other stores. An empty path keeps them in memory. The
+// file is written historySaveDelay after a lookup rather than on every one;
+// Flush writes what is pending.
+type historyStore struct {
+	mu          sync.Mutex
+	path        string
+	maxPerPlate int
+	entries     map[string][]*historyEntry // plate -> oldest first
+	dirty       bool
+	saveTimer   *time.Timer
+
+	saveMu sync.Mutex // orders file writes, which happen outside mu
+}
+
+func newHistoryStore(path string, maxPerPlate int) (*historyStore, error) {
+	if maxPerPlate < 1 {
+		maxPerPlate = defaultHistoryPerPlate
+	}
+	s := &historyStore{path: path, maxPerPlate: maxPerPlate, entries: make(map[string][]*historyEntry)}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history %q: %w", path, err)
+	}
+
+	var entries []*historyEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse history %q: %w", path, err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CheckedAt.Before(entries[j].CheckedAt) })
+	for _, e := range entries {
+		s.entries[e.Plate] = append(s.entries[e.Plate], e)
+	}
+	return s, nil
+}
+
+// Record stores a lookup result, dropping the oldest entries of the plate
+// beyond maxPerPlate, and returns its entry.
+func (s *historyStore) Record(plate, vehicleCode, source string, data []*CsgtData) *historyEntry {
+	if data == nil {
+		data = []*CsgtData{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &historyEntry{
+		ID:          newID(),
+		Plate:       plate,
+		VehicleType: vehicleTypeName(vehicleCode),
+		Source:      source,
+		CheckedAt:   time.Now(),
+		Violations:  data,
+	}
+	list := append(s.entries[plate], entry)
+	if len(list) > s.maxPerPlate {
+		list = append([]*historyEntry{}, list[len(list)-s.maxPerPlate:]...)
+	}
+	s.entries[plate] = list
+
+	if s.path == "" {
+		return entry
+	}
+	s.dirty = true
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(historySaveDelay, func() {
+			if err := s.Flush(context.Background()); err != nil {
+				log.Printf("Failed to save history: %v\n", err)
+			}
+		})
+	}
+	return entry
+}
+
+// recordedEntryKey is the context key of the **historyEntry a paged lookup
+// wants the recorded entry in (see violationListing.record).
+type recordedEntryKey struct{}
+
+// recordLookup records a lookup in store, if any, and hands the entry to
+// whoever asked for it through ctx.
+func recordLookup(ctx context.Context, store *historyStore, plate, vehicleCode, source string, data []*CsgtData) {
+	if store == nil {
+		return
+	}
+	entry := store.Record(plate, vehicleCode, source, data)
+	if dst, ok := ctx.Value(recordedEntryKey{}).(**historyEntry); ok {
+		*dst = entry
+	}
+}
+
+// List returns up to limit lookups of a plate, newest first. limit <= 0
+// means all of them.
+func (s *historyStore) List(plate string, limit int) []*historyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.entries[plate]
+	out := make([]*historyEntry, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, list[i])
+	}
+	return out
+}
+
+// Entry returns the lookup of a plate with the given ID, or nil once it has
+// been dropped.
+func (s *historyStore) Entry(plate, id string) *historyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries[plate] {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// At returns the latest lookup of a plate made at or before t, or nil.
+func (s *historyStore) At(plate string, t time.Time) *historyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.entries[plate]
+	for i := len(list) - 1; i >= 0; i-- {
+		if !list[i].CheckedAt.After(t) {
+			return list[i]
+		}
+	}
+	return nil
+}
+
+// Flush writes the lookups recorded since the last write to the file. It is
+// called on shutdown so none are lost.
+func (s *historyStore) Flush(context.Context) error {
+	if s.path == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	var all []*historyEntry
+	for _, list := range s.entries {
+		all = append(all, list...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].CheckedAt.Before(all[j].CheckedAt) })
+	raw, err := json.Marshal(all)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode history: %w", err)
+	}
+
+	if err := writeJSONFile(s.path, json.RawMessage(raw)); err != nil {
+		// Try again with the next lookup or on shutdown
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ---- HTTP ----
+
+type historyAPI struct {
+	store *historyStore
+}
+
+func (api *historyAPI) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/plates/{plate}/history", api.getHistory)
+}
+
+// getHistory handles GET /v1/plates/{plate}/history?limit=20. Entries are
+// paged with cursors, newest first; the violation filters and sort of
+// violationQuery apply to the violations of each entry.
+func (api *historyAPI) getHistory(w http.ResponseWriter, r *http.Request) {
+	plate, err := processPlate(r.PathValue("plate"))
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	vq, err := parseViolationQuery(r.URL.Query())
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, _, err := parsePage(r.URL.Query(), "history\x00"+vq.key(), defaultPageSize)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Newest first, ties broken by ID, so the key of the last entry shown
+	// says where the next page starts
+	all := api.store.List(plate, 0)
+	sort.SliceStable(all, func(i, j int) bool { return listedBefore(all[i].CheckedAt, all[i].ID, all[j]) })
+	start := 0
+	if p.afterID != "" {
+		start = sort.Search(len(all), func(i int) bool { return listedBefore(p.afterTime, p.afterID, all[i]) })
+	}
+	end := start + min(p.limit, len(all)-start)
+	var next string
+	if end < len(all) {
+		p.afterTime, p.afterID = all[end-1].CheckedAt, all[end-1].ID
+		next = p.cursor()
+	}
+
+	entries := make([]*historyEntry, 0, end-start)
+	for _, e := range all[start:end] {
+		// Entries are shared with the store, filter a copy
+		filtered := *e
+		filtered.Violations = vq.apply(e.Violations)
+		entries = append(entries, &filtered)
+	}
+
+	meta := map[string]interface{}{"plate": plate, "count": len(entries), "total": len(all)}
+	if next != "" {
+		meta["next_cursor"] = next
+	}
+	writeEnvelope(w, http.StatusOK, entries, meta)
+}
+
+// listedBefore reports whether the entry with the given check time and ID
+// comes before e in the history listing.
+func listedBefore(checkedAt time.Time, id string, e *historyEntry) bool {
+	if !checkedAt.Equal(e.CheckedAt) {
+		return checkedAt.After(e.CheckedAt)
+	}
+	return id > e.ID
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory_RecordTrimAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	store, err := newHistoryStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Record("51K12345", "1", sourcePrimary, nil)
+	store.Record("51K12345", "1", sourceCSGT, []*CsgtData{{Plate: "51K-123.45"}})
+	store.Record("51K12345", "1", sourcePrimary, []*CsgtData{{Plate: "51K-123.45"}, {Plate: "51K-123.45"}})
+	store.Record("98E171478", "2", sourceCSGT, nil)
+
+	list := store.List("51K12345", 0)
+	if len(list) != 2 || len(list[0].Violations) != 2 || list[1].Source != sourceCSGT {
+		t.Fatalf("Expected the 2 newest entries, newest first, got %+v", list)
+	}
+
+	// Lookups are written in a batch, not one by one
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no write before the save delay, got %v", err)
+	}
+	if err := store.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newHistoryStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.List("51K12345", 1); len(got) != 1 || got[0].ID != list[0].ID {
+		t.Errorf("Expected the newest entry after reload, got %+v", got)
+	}
+	if got := reloaded.List("98E171478", 0); len(got) != 1 || got[0].VehicleType != "xemay" || got[0].Violations == nil {
+		t.Errorf("Unexpected entries for the motorbike: %+v", got)
+	}
+}
+
+func TestHistory_Route(t *testing.T) {
+	store, _ := newHistoryStore("", 0)
+	store.Record("51K12345", "1", sourcePrimary, []*CsgtData{{Plate: "51K-123.45"}})
+	store.Record("51K12345", "1", sourcePrimary, nil)
+	mux := http.NewServeMux()
+	(&historyAPI{store: store}).registerRoutes(mux)
+
+	var env struct {
+		Data []historyEntry         `json:"data"`
+		Meta map[string]interface{} `json:"meta"`
+	}
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K-123.45/history?limit=1", "", &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(env.Data) != 1 || len(env.Data[0].Violations) != 0 || env.Meta["plate"] != "51K12345" {
+		t.Errorf("Unexpected history %+v", env)
+	}
+
+	var errEnv testEnvelope
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K12345/history?limit=0", "", &errEnv); code != http.StatusBadRequest || errEnv.Error == nil {
+		t.Errorf("Expected 400 for limit=0, got %d", code)
+	}
+
+	// Filters apply to the violations of each entry, paging to entries
+	store.Record("51K12345", "1", sourcePrimary, querySample)
+	env.Data, env.Meta = nil, nil
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K12345/history?limit=1&province=bac+giang", "", &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(env.Data) != 1 || len(env.Data[0].Violations) != 1 || env.Meta["total"] != float64(3) || env.Meta["next_cursor"] == nil {
+		t.Fatalf("Unexpected filtered history %+v", env)
+	}
+	if got := store.List("51K12345", 1)[0].Violations; len(got) != len(querySample) {
+		t.Errorf("Filtering changed the stored entry: %d violations", len(got))
+	}
+	env.Data = nil
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K12345/history?province=bac+giang&cursor="+env.Meta["next_cursor"].(string), "", &env); code != http.StatusOK || len(env.Data) != 1 || env.Data[0].Violations == nil {
+		t.Errorf("Unexpected second page %d %+v", code, env.Data)
+	}
+}
+
+func TestHistory_CursorIgnoresNewLookups(t *testing.T) {
+	store, _ := newHistoryStore("", 0)
+	for i := 0; i < 3; i++ {
+		store.Record("51K12345", "1", sourcePrimary, nil)
+	}
+	oldest := store.List("51K12345", 0)[2]
+	mux := http.NewServeMux()
+	(&historyAPI{store: store}).registerRoutes(mux)
+
+	var env struct {
+		Data []historyEntry         `json:"data"`
+		Meta map[string]interface{} `json:"meta"`
+	}
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K12345/history?limit=2", "", &env); code != http.StatusOK || env.Meta["next_cursor"] == nil {
+		t.Fatalf("Expected a first page with a cursor, got %d %+v", code, env.Meta)
+	}
+	next := env.Meta["next_cursor"].(string)
+
+	// A lookup recorded in between must not push the second entry onto the
+	// next page again
+	store.Record("51K12345", "1", sourcePrimary, nil)
+	env.Data, env.Meta = nil, nil
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K12345/history?cursor="+next, "", &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(env.Data) != 1 || env.Data[0].ID != oldest.ID || env.Meta["next_cursor"] != nil {
+		t.Errorf("Expected only the oldest entry, got %+v", env)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Asynchronous lookup jobs
+// ------------------------------------------------------------------------
+
+// Job states, in the order a job normally goes through them.
+const (
+	jobQueued         = "queued"
+	jobSolvingCaptcha = "solving_captcha"
+	jobFetching       = "fetching"
+	jobDone           = "done"
+	jobFailed         = "failed"
+)
+
+var ErrJobQueueFull = errors.New("job queue is full, try again later")
+
+type jobProgress struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+}
+
+// lookupJob is a single or batch lookup running in the background. Results
+// use the same shape as /checkplate/batch, one entry per requested plate.
+type lookupJob struct {
+	ID        string        `json:"id"`
+	Kind      string        `json:"kind"` // "single" or "batch"
+	State     string        `json:"state"`
+	Progress  jobProgress   `json:"progress"`
+	Items     []batchItem   `json:"items"`
+	Results   []batchResult `json:"results"`
+	Error     string        `json:"error,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+}
+
+func (j *lookupJob) finished() bool {
+	return j.State == jobDone || j.State == jobFailed
+}
+
+// jobManager queues jobs for a fixed number of workers and mirrors every job
+// to a JSON file, so queued and running jobs are picked up again after a
+// restart. Finished jobs are kept for the retention window.
+type jobManager struct {
+	mu          sync.Mutex
+	path        string
+	jobs        map[string]*lookupJob
+	queue       chan string
+	retention   time.Duration
+	concurrency int // lookups in flight within one batch job
+	lookup      func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error)
+
+	draining     chan struct{} // closed by Shutdown: workers take no new job
+	shutdownOnce sync.Once
+	workers      sync.WaitGroup
+	abort        context.CancelFunc // cancels the lookups of running jobs
+
+	saveMu sync.Mutex // orders file writes, which happen outside mu
+}
+
+func newJobManager(path string, retention time.Duration, queueSize int) (*jobManager, error) {
+	m := &jobManager{
+		path:        path,
+		jobs:        make(map[string]*lookupJob),
+		queue:       make(chan string, queueSize),
+		retention:   retention,
+		concurrency: defaultBatchConcurrency,
+		lookup:      lookupViolationsWithProgress,
+		draining:    make(chan struct{}),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs %q: %w", path, err)
+	}
+
+	var jobs []*lookupJob
+	if err := json.Unmarshal(raw, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs %q: %w", path, err)
+	}
+
+	// Jobs interrupted by the restart start over from the beginning
+	for _, j := range jobs {
+		m.jobs[j.ID] = j
+		if j.finished() {
+			continue
+		}
+		j.State = jobQueued
+		j.Results, _, _ = prepareBatch(j.Items)
+		j.Progress.Completed = 0
+		select {
+		case m.queue <- j.ID:
+		default:
+			j.State = jobFailed
+			j.Error = ErrJobQueueFull.Error()
+		}
+	}
+	return m, nil
+}
+
+// Start runs the workers and the cleanup of expired jobs until stop is closed
+// or Shutdown is called. Closing stop also aborts the lookups of the jobs in
+// progress.
+func (m *jobManager) Start(workers int, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.abort = cancel
+	m.mu.Unlock()
+	if stop != nil {
+		go func() {
+			<-stop
+			cancel()
+		}()
+	}
+
+	m.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer m.workers.Done()
+			for {
+				// A draining manager must not pick another job, even if
+				// one is ready too
+				select {
+				case <-m.draining:
+					return
+				default:
+				}
+				select {
+				case <-stop:
+					return
+				case <-m.draining:
+					return
+				case id := <-m.queue:
+					m.process(ctx, id)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-m.draining:
+				return
+			case <-ticker.C:
+				m.purgeExpired()
+			}
+		}
+	}()
+}
+
+// Shutdown stops taking jobs and waits for the running ones to finish. When
+// ctx is done first, their lookups are aborted and ctx's error returned; like
+// the jobs still queued, they start over after a restart.
+func (m *jobManager) Shutdown(ctx context.Context) error {
+	m.shutdownOnce.Do(func() { close(m.draining) })
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		abort := m.abort
+		m.mu.Unlock()
+		if abort != nil {
+			abort()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Submit queues a new job for items. kind is "single" or "batch".
+func (m *jobManager) Submit(kind string, items []batchItem) (*lookupJob, error) {
+	results, _, _ := prepareBatch(items)
+	now := time.Now()
+	j := &lookupJob{
+		ID:        newID(),
+		Kind:      kind,
+		State:     jobQueued,
+		Progress:  jobProgress{Total: len(items)},
+		Items:     items,
+		Results:   results,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	m.mu.Lock()
+	select {
+	case m.queue <- j.ID:
+	default:
+		m.mu.Unlock()
+		return nil, ErrJobQueueFull
+	}
+	m.jobs[j.ID] = j
+	cp := m.copyLocked(j)
+	m.mu.Unlock()
+
+	m.save()
+	return cp, nil
+}
+
+// Get returns a snapshot of a job. Expired jobs are reported as missing.
+func (m *jobManager) Get(id string) (*lookupJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok || (j.ExpiresAt != nil && time.Now().After(*j.ExpiresAt)) {
+		return nil, false
+	}
+	return m.copyLocked(j), true
+}
+
+func (m *jobManager) process(ctx context.Context, id string) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	items := j.Items
+	m.mu.Unlock()
+
+	results, vehicleCodes, pending := prepareBatch(items)
+	m.update(id, func(j *lookupJob) {
+		j.State = jobFetching
+		j.Results = results
+		j.Progress.Completed = len(items) - len(pending)
+	})
+
+	forEachBounded(len(pending), m.concurrency, func(n int) {
+		i := pending[n]
+		res := results[i]
+
+		progress := func(ev lookupEvent) {
+			state := jobStateForStage(ev.Stage)
+			m.update(id, func(j *lookupJob) { j.State = state })
+		}
+
+		data, err := m.lookup(ctx, res.Plate, vehicleCodes[i], progress)
+		res.complete(data, err)
+
+		m.update(id, func(j *lookupJob) {
+			j.Results[i] = res
+			j.Progress.Completed++
+		})
+	})
+
+	if ctx.Err() != nil {
+		// Aborted: keep the job unfinished so it runs again after a restart
+		log.Printf("Job %s interrupted\n", id)
+		return
+	}
+
+	m.update(id, func(j *lookupJob) {
+		summary := summarizeBatch(j.Results)
+		j.State = jobDone
+		if summary.Succeeded == 0 {
+			j.State = jobFailed
+			j.Error = j.Results[0].Error
+			if summary.Total > 1 {
+				j.Error = "all lookups failed"
+			}
+		}
+		expires := time.Now().Add(m.retention)
+		j.ExpiresAt = &expires
+	})
+}
+
+// jobStateForStage maps a lookup stage to the state a running job reports:
+// every stage of a captcha round until the csgt.vn query is solving_captcha.
+func jobStateForStage(stage string) string {
+	switch stage {
+	case stageSolvingCaptcha, stageCaptchaFetched, stageOCRGuess, stageCaptchaRejected:
+		return jobSolvingCaptcha
+	}
+	return jobFetching
+}
+
+// update applies fn to a job under the lock. The file is only written when
+// the job starts or finishes: a job interrupted halfway runs again from the
+// beginning anyway, so its progress is not worth a write per stage.
+func (m *jobManager) update(id string, fn func(j *lookupJob)) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	before := jobPhase(j)
+	fn(j)
+	j.UpdatedAt = time.Now()
+	changed := jobPhase(j) != before
+	m.mu.Unlock()
+
+	if changed {
+		m.save()
+	}
+}
+
+// jobPhase is what survives a restart of a job's state: queued, running or
+// finished.
+func jobPhase(j *lookupJob) string {
+	switch {
+	case j.finished():
+		return "finished"
+	case j.State == jobQueued:
+		return jobQueued
+	}
+	return "running"
+}
+
+func (m *jobManager) purgeExpired() {
+	m.mu.Lock()
+
+	now := time.Now()
+	removed := false
+	for id, j := range m.jobs {
+		if j.ExpiresAt != nil && now.After(*j.ExpiresAt) {
+			delete(m.jobs, id)
+			removed = true
+		}
+	}
+	m.mu.Unlock()
+
+	if removed {
+		m.save()
+	}
+}
+
+func (m *jobManager) copyLocked(j *lookupJob) *lookupJob {
+	cp := *j
+	cp.Results = append([]batchResult{}, j.Results...)
+	return &cp
+}
+
+// save writes every job to the file. Only encoding holds mu, so reads are
+// not blocked by the disk; saveMu keeps a stale snapshot from being written
+// over a newer one.
+func (m *jobManager) save() {
+	if m.path == "" {
+		return
+	}
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	m.mu.Lock()
+	jobs := make([]*lookupJob, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	raw, err := json.Marshal(jobs)
+	m.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to encode jobs: %v\n", err)
+		return
+	}
+	if err := writeJSONFile(m.path, json.RawMessage(raw)); err != nil {
+		log.Printf("Failed to save jobs: %v\n", err)
+	}
+}
+
+// ---- HTTP ----
+
+func (m *jobManager) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /jobs", m.createJobHandler)
+	mux.HandleFunc("GET /jobs/{id}", m.getJobHandler)
+}
+
+// createJobHandler handles POST /jobs. A JSON object {"bienso", "loaixe"}
+// creates a single lookup; anything /checkplate/batch accepts (JSON array or
+// CSV) creates a batch.
+func (m *jobManager) createJobHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read body: "+err.Error())
+		return
+	}
+
+	var (
+		kind  string
+		items []batchItem
+	)
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		var item batchItem
+		if err := json.Unmarshal(body, &item); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Failed to parse JSON body: "+err.Error())
+			return
+		}
+		if item.Plate == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing or empty parameter: bienso")
+			return
+		}
+
+		// A single lookup is validated up front rather than failing later
+		if results, _, pending := prepareBatch([]batchItem{item}); len(pending) == 0 {
+			writeLegacyError(w, http.StatusBadRequest, &apiError{Code: results[0].Code, Message: results[0].Error})
+			return
+		}
+		kind, items = "single", []batchItem{item}
+	} else {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		items, err = parseBatchRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(items) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "Batch is empty")
+			return
+		}
+		if len(items) > maxBatchSize {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Batch too large: %d plates (max %d)", len(items), maxBatchSize))
+			return
+		}
+		kind = "batch"
+	}
+
+	if err := chargeLookups(r.Context(), len(items)-1); err != nil {
+		writeRequestError(w, r, http.StatusTooManyRequests, err)
+		return
+	}
+	j, err := m.Submit(kind, items)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+func (m *jobManager) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := m.Get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Job not found or expired")
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitJob(t *testing.T, m *jobManager, id string, until func(j *lookupJob) bool) *lookupJob {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, ok := m.Get(id); ok && until(j) {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	j, _ := m.Get(id)
+	t.Fatalf("Timed out waiting for job %s, last state: %+v", id, j)
+	return nil
+}
+
+func TestJobs_SingleLifecycle(t *testing.T) {
+	m, _ := newJobManager("", time.Hour, 10)
+	release := make(chan struct{})
+	m.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		progress.report(stagePrimaryEmpty, "")
+		progress.report(stageSolvingCaptcha, "")
+		<-release
+		return []*CsgtData{{Plate: plate}}, nil
+	}
+
+	mux := http.NewServeMux()
+	m.registerRoutes(mux)
+
+	var created lookupJob
+	code := doJSON(t, mux, "POST", "/jobs", `{"bienso":"51K-123.45"}`, &created)
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", code)
+	}
+	if created.State != jobQueued || created.Kind != "single" || created.Progress.Total != 1 {
+		t.Errorf("Unexpected new job: %+v", created)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	m.Start(1, stop)
+
+	waitJob(t, m, created.ID, func(j *lookupJob) bool { return j.State == jobSolvingCaptcha })
+	close(release)
+	done := waitJob(t, m, created.ID, func(j *lookupJob) bool { return j.finished() })
+
+	if done.State != jobDone || done.Progress.Completed != 1 || done.ExpiresAt == nil {
+		t.Fatalf("Unexpected finished job: %+v", done)
+	}
+	if len(done.Results) != 1 || done.Results[0].Status != "ok" || done.Results[0].Plate != "51K12345" {
+		t.Errorf("Unexpected results: %+v", done.Results)
+	}
+
+	var fetched lookupJob
+	if code := doJSON(t, mux, "GET", "/jobs/"+created.ID, "", &fetched); code != http.StatusOK || fetched.State != jobDone {
+		t.Errorf("Expected GET to report done, got %d %+v", code, fetched)
+	}
+	if code := doJSON(t, mux, "GET", "/jobs/nope", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %d", code)
+	}
+}
+
+func TestJobs_StateFollowsCaptchaStages(t *testing.T) {
+	m, _ := newJobManager("", time.Hour, 10)
+	stages := []struct{ stage, state string }{
+		{stageQueryingPrimary, jobFetching},
+		{stagePrimaryEmpty, jobFetching},
+		{stageSolvingCaptcha, jobSolvingCaptcha},
+		{stageCaptchaFetched, jobSolvingCaptcha},
+		{stageOCRGuess, jobSolvingCaptcha},
+		{stageFetchingCSGT, jobFetching},
+		{stageCaptchaRejected, jobSolvingCaptcha},
+		{stageSolvingCaptcha, jobSolvingCaptcha},
+		{stageCaptchaFetched, jobSolvingCaptcha},
+		{stageOCRGuess, jobSolvingCaptcha},
+		{stageFetchingCSGT, jobFetching},
+		{stageResultsParsed, jobFetching},
+	}
+	reported, next := make(chan struct{}), make(chan struct{})
+	m.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		for _, s := range stages {
+			progress.report(s.stage, "")
+			reported <- struct{}{}
+			<-next
+		}
+		return nil, nil
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	m.Start(1, stop)
+
+	j, err := m.Submit("single", []batchItem{{Plate: "51K12345"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stages {
+		<-reported
+		if got, _ := m.Get(j.ID); got.State != s.state {
+			t.Errorf("Expected %s after %s, got %s", s.state, s.stage, got.State)
+		}
+		next <- struct{}{}
+	}
+	waitJob(t, m, j.ID, func(j *lookupJob) bool { return j.finished() })
+}
+
+func TestJobs_InvalidSingleRejected(t *testing.T) {
+	m, _ := newJobManager("", time.Hour, 10)
+	mux := http.NewServeMux()
+	m.registerRoutes(mux)
+
+	if code := doJSON(t, mux, "POST", "/jobs", `{"bienso":"abc"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid plate, got %d", code)
+	}
+	if code := doJSON(t, mux, "POST", "/jobs", `{"loaixe":"oto"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for missing plate, got %d", code)
+	}
+}
+
+func TestJobs_BatchAndFailure(t *testing.T) {
+	m, _ := newJobManager("", time.Hour, 10)
+	m.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		return nil, nil
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	m.Start(2, stop)
+
+	j, err := m.Submit("batch", []batchItem{{Plate: "51K12345"}, {Plate: "bad"}})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	done := waitJob(t, m, j.ID, func(j *lookupJob) bool { return j.finished() })
+	if done.State != jobDone || done.Progress.Completed != 2 {
+		t.Errorf("Expected done batch with 2 completed, got %+v", done)
+	}
+	if done.Results[0].Status != "ok" || done.Results[1].Status != "error" {
+		t.Errorf("Unexpected batch results: %+v", done.Results)
+	}
+
+	// Every lookup failing marks the job failed
+	j, _ = m.Submit("batch", []batchItem{{Plate: "bad"}})
+	failed := waitJob(t, m, j.ID, func(j *lookupJob) bool { return j.finished() })
+	if failed.State != jobFailed || !strings.Contains(failed.Error, "invalid plate") {
+		t.Errorf("Expected failed job, got %+v", failed)
+	}
+}
+
+func TestJobs_ResumeAfterRestartAndExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	// First "process" queues a job but never runs it
+	m1, _ := newJobManager(path, 50*time.Millisecond, 10)
+	j, err := m1.Submit("single", []batchItem{{Plate: "51K12345"}})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	// After the restart the job is picked up again
+	m2, err := newJobManager(path, 50*time.Millisecond, 10)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	m2.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		return nil, nil
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	m2.Start(1, stop)
+
+	done := waitJob(t, m2, j.ID, func(j *lookupJob) bool { return j.finished() })
+	if done.State != jobDone {
+		t.Fatalf("Expected resumed job to finish, got %+v", done)
+	}
+
+	// Finished jobs disappear after the retention window
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := m2.Get(j.ID); ok {
+		t.Error("Expected job to expire")
+	}
+	m2.purgeExpired()
+	m3, _ := newJobManager(path, time.Hour, 10)
+	if _, ok := m3.jobs[j.ID]; ok {
+		t.Error("Expected expired job to be purged from disk")
+	}
+}
+
+func TestJobs_SavesOnlyWhenPhaseChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	m, _ := newJobManager(path, time.Hour, 10)
+	reported := make(chan struct{})
+	release := make(chan struct{})
+	m.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		progress.report(stageSolvingCaptcha, "")
+		reported <- struct{}{}
+		<-release
+		return []*CsgtData{{Plate: plate}}, nil
+	}
+	j, _ := m.Submit("single", []batchItem{{Plate: "51K12345"}})
+	stop := make(chan struct{})
+	defer close(stop)
+	m.Start(1, stop)
+	<-reported
+
+	// Captcha stages don't touch the file, only starting did
+	var saved []*lookupJob
+	raw, _ := os.ReadFile(path)
+	if err := json.Unmarshal(raw, &saved); err != nil || len(saved) != 1 || saved[0].State != jobFetching {
+		t.Errorf("Expected the file to hold the state the job started with, got %s", raw)
+	}
+	close(release)
+	waitJob(t, m, j.ID, func(j *lookupJob) bool { return j.finished() })
+	if saved, _ := newJobManager(path, time.Hour, 10); saved.jobs[j.ID].State != jobDone {
+		t.Errorf("Expected the finished job on disk, got %+v", saved.jobs[j.ID])
+	}
+}
+
+func TestJobs_ShutdownDrainsThenAborts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	m, _ := newJobManager(path, time.Hour, 10)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	m.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		started <- plate
+		select {
+		case <-release:
+			return []*CsgtData{{Plate: plate}}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	running, _ := m.Submit("single", []batchItem{{Plate: "51K12345"}})
+	queued, _ := m.Submit("single", []batchItem{{Plate: "30A12345"}})
+	m.Start(1, nil)
+	<-started
+
+	// The running job is allowed to finish; the queued one is left for later
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- m.Shutdown(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Expected a clean drain, got %v", err)
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected a second Shutdown to return at once, got %v", err)
+	}
+	if j, _ := m.Get(running.ID); j.State != jobDone {
+		t.Errorf("Expected the running job to finish, got %+v", j)
+	}
+	if j, _ := m.Get(queued.ID); j.State != jobQueued {
+		t.Errorf("Expected the queued job to stay queued, got %+v", j)
+	}
+
+	// Past the deadline the lookups are aborted, and the job runs again
+	// after a restart
+	m2, _ := newJobManager(path, time.Hour, 10)
+	m2.lookup = m.lookup
+	release = make(chan struct{})
+	m2.Start(1, nil)
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m2.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline, got %v", err)
+	}
+	if j, _ := m2.Get(queued.ID); j.finished() {
+		t.Errorf("Expected the aborted job to stay unfinished, got %+v", j)
+	}
+	m3, _ := newJobManager(path, time.Hour, 10)
+	if j := m3.jobs[queued.ID]; j == nil || j.State != jobQueued {
+		t.Errorf("Expected the aborted job to be queued again, got %+v", j)
+	}
+}
+
+func TestJobs_CreateBatchFromCSV(t *testing.T) {
+	m, _ := newJobManager("", time.Hour, 10)
+	mux := http.NewServeMux()
+	m.registerRoutes(mux)
+
+	req := httptest.NewRequest("POST", "/jobs", strings.NewReader("bienso,loaixe\n51K12345,oto\n98E171478,xemay\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/jobs/") {
+		t.Errorf("Expected Location header, got %q", loc)
+	}
+	if !strings.Contains(rec.Body.String(), `"kind": "batch"`) {
+		t.Errorf("Expected batch job, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Shared lookup chain (primary API, then csgt.vn fallback)
+// ------------------------------------------------------------------------
+
+// vehicleCodeFor maps the user-facing vehicle type ("oto", "xemay") to the
+// code expected by csgt.vn ("1" for oto, "2" for xemay). Empty means "oto".
+func vehicleCodeFor(vehicleType string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(vehicleType)) {
+	case "", "oto":
+		return "1", nil
+	case "xemay":
+		return "2", nil
+	default:
+		return "", markError(ErrInvalidVehicleType, fmt.Errorf("Invalid vehicle type: %s", vehicleType))
+	}
+}
+
+// lookupTimeout bounds one whole lookup, captcha retries included, on top of
+// whatever deadline the caller's context already has.
+var lookupTimeout = 2 * time.Minute
+
+// Lookup stages reported while the chain runs.
+const (
+	stageQueryingPrimary    = "querying_primary"
+	stagePrimaryEmpty       = "primary_empty"
+	stagePrimaryUnavailable = "primary_unavailable"
+	stageSolvingCaptcha     = "solving_captcha"
+	stageCaptchaFetched     = "captcha_fetched"
+	stageOCRGuess           = "ocr_guess"
+	stageFetchingCSGT       = "fetching_csgt"
+	stageCaptchaRejected    = "captcha_rejected"
+	stageResultsParsed      = "results_parsed"
+)
+
+type lookupEvent struct {
+	Stage   string `json:"stage"`
+	Attempt int    `json:"attempt,omitempty"` // captcha attempt, for csgt.vn stages
+	Message string `json:"message,omitempty"`
+}
+
+// lookupProgress receives the stages of one lookup. A nil lookupProgress
+// ignores them.
+type lookupProgress func(ev lookupEvent)
+
+func (p lookupProgress) report(stage, message string) {
+	p.reportAttempt(stage, 0, message)
+}
+
+func (p lookupProgress) reportAttempt(stage string, attempt int, message string) {
+	if p != nil {
+		p(lookupEvent{Stage: stage, Attempt: attempt, Message: message})
+	}
+}
+
+// lookupViolations runs the same chain as checkPlateHandler but always returns
+// typed records, so non-HTTP front-ends (bots, watchers) can format them.
+// The plate must already be cleaned by processPlate. Cancelling ctx (e.g. the
+// client went away) aborts every upstream request and the OCR in flight.
+func lookupViolations(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+	return lookupViolationsWithProgress(ctx, plate, vehicleCode, nil)
+}
+
+// lookupViolationsWithProgress is lookupViolations, reporting each stage to
+// progress (which may be nil).
+func lookupViolationsWithProgress(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+	results, _, err := lookupViolationsFrom(ctx, plate, vehicleCode, progress)
+	return results, err
+}
+
+// lookupViolationsFrom is lookupViolationsWithProgress, also returning the
+// source that answered (sourcePrimary or sourceCSGT).
+func lookupViolationsFrom(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	// 1) Check primary source, unless its breaker is open
+	progress.report(stageQueryingPrimary, "")
+	source := sourcePrimary
+	var data interface{}
+	start := time.Now()
+	err := withBreaker(ctx, sourcePrimary, func() error {
+		return withRetry(ctx, sourcePrimary, "lookup", func(ctx context.Context) (err error) {
+			data, err = fetchDataPhatNguoi(ctx, plate)
+			return err
+		})
+	})
+	observeSourceLookup(sourcePrimary, start, err)
+	if err != nil {
+		// A primary that is down degrades to csgt.vn, like one without data
+		var primaryErr error
+		switch {
+		case errors.Is(err, ErrDataNotFound):
+			log.Printf("No data for plate %s from primary API. Attempting fallback to csgt.vn...\n", plate)
+			progress.report(stagePrimaryEmpty, "")
+			fallbacks.WithLabelValues("no_data").Inc()
+		case isUpstreamFailure(err) && ctx.Err() == nil:
+			log.Printf("Primary API unavailable for plate %s (%v). Attempting fallback to csgt.vn...\n", plate, err)
+			progress.report(stagePrimaryUnavailable, err.Error())
+			fallbacks.WithLabelValues("primary_unavailable").Inc()
+			primaryErr = err
+		default:
+			return nil, "", err
+		}
+
+		// 2) Fallback to csgt.vn
+		source = sourceCSGT
+		start = time.Now()
+		err = withBreaker(ctx, sourceCSGT, func() (err error) {
+			data, err = fallbackToCSGTWithProgress(ctx, plate, vehicleCode, progress)
+			return err
+		})
+		observeSourceLookup(sourceCSGT, start, err)
+		if err != nil {
+			if primaryErr != nil {
+				return nil, "", fmt.Errorf("%s unavailable (%v), fallback also failed: %w", sourcePrimary, primaryErr, err)
+			}
+			return nil, "", fmt.Errorf("%w: %w", ErrFallbackFailed, err)
+		}
+	}
+
+	results, err := violationsFromResult(data)
+	if err != nil {
+		return nil, "", err
+	}
+	progress.report(stageResultsParsed, fmt.Sprintf("%d violation(s)", len(results)))
+	recordLookup(ctx, lookupHistory, plate, vehicleCode, source, results)
+	return results, source, nil
+}
+
+// violationsFromResult normalizes whatever the fetchers returned into records.
+// csgt.vn answers without an "href" when it has nothing to show, and
+// parseCSGTHtml returns nil when the page had no violation block; both mean
+// "no violations".
+func violationsFromResult(data interface{}) ([]*CsgtData, error) {
+	switch v := data.(type) {
+	case nil:
+		return nil, nil
+	case []*CsgtData:
+		return v, nil
+	case map[string]interface{}:
+		return nil, nil
+	default:
+		return nil, markError(ErrUpstreamSchema, fmt.Errorf("unexpected lookup result type %T", data))
+	}
+}
+
+// resolutionOffice is one entry of CsgtData.ResolutionLocation.
+type resolutionOffice struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+}
+
+var officeNumberPrefix = regexp.MustCompile(`^\d+\.\s*`)
+
+// parseResolutionOffices splits a resolution location into its offices. Both
+// sources list them as
+//
+//  1. <office>
+//     Địa chỉ: <address>
+//     Số điện thoại liên hệ: <phone>
+//
+// Lines that don't fit are appended to the office name.
+func parseResolutionOffices(location string) []resolutionOffice {
+	var offices []resolutionOffice
+	for _, line := range strings.Split(location, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var cur *resolutionOffice
+		if len(offices) > 0 {
+			cur = &offices[len(offices)-1]
+		}
+		switch {
+		case officeNumberPrefix.MatchString(line) || cur == nil:
+			offices = append(offices, resolutionOffice{Name: officeNumberPrefix.ReplaceAllString(line, "")})
+		case strings.HasPrefix(line, "Địa chỉ:"):
+			cur.Address = strings.TrimSpace(strings.TrimPrefix(line, "Địa chỉ:"))
+		case strings.HasPrefix(line, "Số điện thoại liên hệ:"):
+			cur.Phone = strings.TrimSpace(strings.TrimPrefix(line, "Số điện thoại liên hệ:"))
+		default:
+			cur.Name += " " + line
+		}
+	}
+	return offices
+}
+
+// violationCodePattern matches the code csgt.vn puts in front of a violation
+// action, e.g. "16824.7.9.a.01.Không chấp hành hiệu lệnh của đèn tín hiệu":
+// decree 168/2024, article 7, clause 9, point a, then a sequence number.
+var violationCodePattern = regexp.MustCompile(`^(\d{3,6})\.(\d+)\.(\d+)\.([a-zđ])(?:\.\d+)?\.\s*(.+)$`)
+
+// splitViolationAction separates the legal reference encoded in a violation
+// action from its description. ref is empty when the action has no code.
+func splitViolationAction(action string) (description, ref string) {
+	m := violationCodePattern.FindStringSubmatch(strings.TrimSpace(action))
+	if m == nil {
+		return strings.TrimSpace(action), ""
+	}
+	decree := m[1][:len(m[1])-2] + "/20" + m[1][len(m[1])-2:] + "/NĐ-CP"
+	return m[5], fmt.Sprintf("Điểm %s, Khoản %s, Điều %s, Nghị định %s", m[4], m[3], m[2], decree)
+}
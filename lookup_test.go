@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLookup_CanceledContextSkipsUpstream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// No request leaves the process once ctx is done
+	if _, err := fetchDataPhatNguoi(ctx, "51K12345"); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetchDataPhatNguoi: expected context.Canceled, got %v", err)
+	}
+	if _, _, err := fetchCSGTCaptcha(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetchCSGTCaptcha: expected context.Canceled, got %v", err)
+	}
+	if _, err := solveCaptchaWithOCR(ctx, []byte("png")); !errors.Is(err, context.Canceled) {
+		t.Errorf("solveCaptchaWithOCR: expected context.Canceled, got %v", err)
+	}
+
+	var events []lookupEvent
+	_, err := fallbackToCSGTWithProgress(ctx, "51K12345", "1", func(ev lookupEvent) { events = append(events, ev) })
+	if !errors.Is(err, context.Canceled) || len(events) != 0 {
+		t.Errorf("Expected no captcha attempt, got %v after %d events", err, len(events))
+	}
+	if code := errorCode(err, http.StatusBadGateway); code != codeCanceled {
+		t.Errorf("Expected code %s, got %s", codeCanceled, code)
+	}
+}
+
+func TestLookup_ClientDisconnectReachesLookup(t *testing.T) {
+	aborted := make(chan error, 1)
+	mux := newTestPlateAPI(func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		<-ctx.Done()
+		aborted <- ctx.Err()
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/v1/plates/51K12345/violations", nil).WithContext(ctx)
+	cancel()
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := <-aborted; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the lookup to see the cancellation, got %v", err)
+	}
+}
+
+func TestFleet_AbortedRefreshKeepsLastLookup(t *testing.T) {
+	api, mux := newTestFleet(t, "")
+	api.lookup = stubLookup([]*CsgtData{{Plate: "51K12345", Status: "Chưa xử phạt"}})
+
+	var org organization
+	doJSON(t, mux, "POST", "/fleet/organizations", `{"name":"Fleet"}`, &org)
+	doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/vehicles/import", "plate\n51K12345\n", nil)
+	doJSON(t, mux, "POST", "/fleet/organizations/"+org.ID+"/violations/refresh", "", nil)
+
+	api.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/fleet/organizations/"+org.ID+"/violations/refresh", nil).WithContext(ctx)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	var view fleetViolationsView
+	doJSON(t, mux, "GET", "/fleet/organizations/"+org.ID+"/violations", "", &view)
+	if len(view.Vehicles) != 1 || view.Vehicles[0].Error != "" || view.UnpaidViolations != 1 {
+		t.Errorf("Expected the previous lookup to survive, got %+v", view)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -16,13 +18,29 @@ import (
 
 	"net/http/cookiejar"
 
-	// For OCR (example library):
 	"github.com/PuerkitoBio/goquery"
-	"github.com/otiai10/gosseract/v2"
 )
 
 var (
-	ErrDataNotFound = errors.New("data not found")
+	ErrDataNotFound    = errors.New("data not found")
+	ErrCaptchaRejected = errors.New("csgt.vn: captcha incorrect or request rejected (404)")
+	ErrFallbackFailed  = errors.New("no data found on primary, fallback also failed")
+)
+
+// captchaAttempts is how many captchas the csgt.vn fallback tries before
+// giving up.
+var captchaAttempts = 3
+
+// Upstream endpoints and what the csgt.vn form is sent with; see appConfig.
+var (
+	primaryLookupURL = "https://api.checkphatnguoi.vn/phatnguoi"
+	csgtLookupURL    = "https://www.csgt.vn/?mod=contact&task=tracuu_post&ajax"
+	csgtCaptchaURL   = "https://www.csgt.vn/lib/captcha/captcha.class.php"
+	csgtClientIP     = "9.9.9.91"
+
+	// captchaLogDir is where fetched captchas are saved for debugging; empty
+	// turns saving off.
+	captchaLogDir = "captchaImageLogs"
 )
 
 type CsgtData struct {
@@ -40,103 +58,175 @@ type CsgtData struct {
 }
 
 func main() {
-	http.HandleFunc("/checkplate", checkPlateHandler)
-	http.HandleFunc("/checkplate-csgt", checkPlateCSGTHandler)
-
-	fmt.Println("Starting server on port 8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal("Failed to start server:", err)
+	cfg, settings, err := loadConfig(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-}
-
-func checkPlateHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
-		return
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
 	}
-
-	if err := r.ParseForm(); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
-		return
+	cfg.apply()
+	if cfg.HTTP.WriteTimeout <= cfg.LookupTimeout {
+		log.Printf("Warning: http.write_timeout (%s) does not exceed lookup.timeout (%s); slow lookups will be cut off\n", cfg.HTTP.WriteTimeout, cfg.LookupTimeout)
 	}
 
-	plate := r.FormValue("bienso")
-	if plate == "" {
-		writeJSONError(w, http.StatusBadRequest, "Missing or empty parameter: bienso")
-		return
-	}
+	newPlateAPI().registerRoutes(http.DefaultServeMux)
+	registerOpenAPIRoutes(http.DefaultServeMux)
+	registerStatusRoutes(http.DefaultServeMux)
+	registerConfigRoutes(http.DefaultServeMux, settings)
+	registerMetricsRoutes(http.DefaultServeMux)
 
-	// Get `loaixe` parameter, default to "oto" (1)
-	vehicleType := r.FormValue("loaixe")
-	if vehicleType == "" {
-		vehicleType = "oto" // Default to "oto"
+	upstream, err = cfg.Upstream.clients()
+	if err != nil {
+		log.Fatal("Invalid upstream configuration: ", err)
 	}
-
-	// Map vehicle type to appropriate code ("1" for oto, "2" for xemay)
-	vehicleCode := "1" // Default to "oto"
-	switch strings.ToLower(vehicleType) {
-	case "xemay":
-		vehicleCode = "2"
-	case "oto":
-		vehicleCode = "1"
-	default:
-		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid vehicle type: %s", vehicleType))
-		return
+	retryPolicies = cfg.Upstream.retryPolicies()
+	breakers = cfg.Upstream.breakers()
+	startOCRPool(cfg.OCR)
+	clients, err := clientLimiterFromConfig(cfg.RateLimit)
+	if err != nil {
+		log.Fatal("Failed to set up rate limits: ", err)
 	}
+	registerHealthRoutes(http.DefaultServeMux, newReadiness(cfg, clients))
 
-	// Clean & validate plate
-	plate, err := processPlate(plate)
+	concurrency := cfg.BatchConcurrency
+	batch := newBatchAPI(concurrency)
+	http.HandleFunc("/checkplate/batch", batch.checkPlateBatchHandler)
+	mountV1(http.DefaultServeMux, "/lookups/batch", http.HandlerFunc(batch.checkPlateBatchHandler))
+
+	stream := newStreamAPI()
+	http.HandleFunc("/checkplate/stream", stream.checkPlateStreamHandler)
+	http.HandleFunc("GET /v1/plates/{plate}/violations/stream", stream.getViolationsStream)
+
+	fleet, err := newFleetStore(cfg.FleetFile)
 	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
+		log.Fatal("Failed to load fleet data:", err)
 	}
-
-	// 1) Check primary source
-	data, err := fetchDataPhatNguoi(plate)
+	lookupHistory, err = newHistoryStore(cfg.HistoryFile, cfg.HistoryPerPlate)
 	if err != nil {
-		if errors.Is(err, ErrDataNotFound) {
-			// If no data in primary => fallback
-			log.Printf("No data for plate %s from primary API. Attempting fallback to csgt.vn...\n", plate)
-
-			// 2) Fallback: Solve captcha from csgt.vn, then fetch data
-			fallbackData, fallbackErr := fallbackToCSGTWithVehicleCode(plate, vehicleCode)
-			if fallbackErr != nil {
-				log.Printf("Fallback failed: %v\n", fallbackErr)
-				writeJSONError(w, http.StatusNotFound, "No data found on primary, fallback also failed: "+fallbackErr.Error())
-				return
-			}
+		log.Fatal("Failed to load lookup history:", err)
+	}
+	(&historyAPI{store: lookupHistory}).registerRoutes(http.DefaultServeMux)
+	newGraphQLAPI(fleet, lookupHistory, concurrency).registerRoutes(http.DefaultServeMux)
 
-			// Return fallback data
-			writeJSON(w, http.StatusOK, fallbackData)
-			return
+	fleetAPI := newFleetAPI(fleet)
+	fleetAPI.concurrency = concurrency
+	fleetAPI.registerRoutes(http.DefaultServeMux)
+	fleetMux := http.NewServeMux()
+	fleetAPI.registerRoutes(fleetMux)
+
+	reports := newReportAPI(fleet, lookupHistory)
+	reports.brand = cfg.ReportBrand
+	reports.registerRoutes(http.DefaultServeMux)
+	reports.registerFleetRoutes(http.DefaultServeMux)
+	reports.registerFleetRoutes(fleetMux)
+	mountV1(http.DefaultServeMux, "/fleet/", fleetMux)
+
+	jobs, err := newJobManager(cfg.JobsFile, cfg.JobRetention, 1000)
+	if err != nil {
+		log.Fatal("Failed to load jobs:", err)
+	}
+	jobs.concurrency = concurrency
+	jobs.registerRoutes(http.DefaultServeMux)
+	jobsMux := http.NewServeMux()
+	jobs.registerRoutes(jobsMux)
+	mountV1(http.DefaultServeMux, "/jobs", jobsMux)
+	mountV1(http.DefaultServeMux, "/jobs/", jobsMux)
+	jobs.Start(cfg.JobWorkers, nil)
+
+	// Chat bots and the watcher stop with the server
+	botsStop := make(chan struct{})
+	startChatBots(cfg.Chat, clients, botsStop)
+
+	grpcServer := newGRPCServer(newPlateGRPCServer(concurrency), clients)
+	go func() {
+		if err := serveGRPC(cfg.GRPCAddr, grpcServer); err != nil {
+			log.Fatal("Failed to start gRPC server:", err)
 		}
-		// Some other error from primary
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
+	}()
+
+	srv := newHTTPServer(cfg.HTTP, instrumentHTTP(http.DefaultServeMux, clients.middleware(http.DefaultServeMux)))
+	drain := []func(context.Context) error{
+		jobs.Shutdown,
+		func(ctx context.Context) error { return stopGRPC(ctx, grpcServer) },
+		func(context.Context) error { close(botsStop); return nil },
+	}
+	// Lookups finishing during the drain are still recorded
+	err = runServer(cfg.HTTP, srv, drain, lookupHistory.Flush)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Server failed: ", err)
 	}
+}
 
-	// If found data from primary
-	writeJSON(w, http.StatusOK, data)
+func fallbackToCSGTWithVehicleCode(ctx context.Context, plate, vehicleCode string) (interface{}, error) {
+	return fallbackToCSGTWithProgress(ctx, plate, vehicleCode, nil)
 }
 
-func fallbackToCSGTWithVehicleCode(plate, vehicleCode string) (interface{}, error) {
+// fallbackToCSGTWithProgress is fallbackToCSGTWithVehicleCode, reporting each
+// stage to progress (which may be nil). OCR often misreads the captcha, so a
+// rejected captcha is retried with a fresh one up to captchaAttempts times,
+// unless ctx is done by then. A round that fails on a transient upstream
+// error is retried whole: csgt.vn ties the captcha to its session, so the
+// lookup can't be sent again on its own.
+func fallbackToCSGTWithProgress(ctx context.Context, plate, vehicleCode string, progress lookupProgress) (interface{}, error) {
+	var err error
+	for attempt := 1; attempt <= captchaAttempts; attempt++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		var data interface{}
+		err = withRetry(ctx, sourceCSGT, "captcha round", func(ctx context.Context) (err error) {
+			data, err = tryCSGTOnce(ctx, plate, vehicleCode, attempt, progress)
+			return err
+		})
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, ErrCaptchaRejected) {
+			return nil, err
+		}
+
+		log.Printf("Captcha attempt %d/%d for plate %s rejected\n", attempt, captchaAttempts, plate)
+		if l := upstream.Limiter(sourceCSGT); l != nil {
+			l.observeCaptchaRejected()
+		}
+		progress.reportAttempt(stageCaptchaRejected, attempt, err.Error())
+	}
+	return nil, err
+}
+
+// tryCSGTOnce does a single captcha + csgt.vn lookup round.
+func tryCSGTOnce(ctx context.Context, plate, vehicleCode string, attempt int, progress lookupProgress) (interface{}, error) {
 	// 1) Fetch the captcha image
-	imgBytes, cookieJar, err := fetchCSGTCaptcha()
+	progress.reportAttempt(stageSolvingCaptcha, attempt, "")
+	imgBytes, cookieJar, err := fetchCSGTCaptcha(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("fetch captcha failed: %w", err)
 	}
+	progress.reportAttempt(stageCaptchaFetched, attempt, "")
 
 	// 2) Solve the captcha (OCR)
-	captchaText, err := solveCaptchaWithOCR(imgBytes)
+	captchaText, err := solveCaptchaWithOCR(ctx, imgBytes)
 	if err != nil {
-		return nil, fmt.Errorf("captcha OCR failed: %w", err)
+		if ctx.Err() != nil {
+			// Aborted, not a captcha the engine couldn't read
+			return nil, fmt.Errorf("captcha OCR aborted: %w", err)
+		}
+		err = markError(ErrCaptchaUnsolved, fmt.Errorf("captcha OCR failed: %w", err))
+		observeCaptcha(captchaSolverOCR, err)
+		return nil, err
 	}
 
 	// Debug log: log recognized text
 	log.Printf("Recognized captcha text = %q\n", captchaText)
+	progress.reportAttempt(stageOCRGuess, attempt, captchaText)
 
 	// 3) Use vehicle code and captcha to fetch data
-	data, err := fetchDataCSGTWithSession(plate, vehicleCode, captchaText, cookieJar)
+	progress.reportAttempt(stageFetchingCSGT, attempt, "")
+	data, err := fetchDataCSGTWithSession(ctx, plate, vehicleCode, captchaText, cookieJar)
+	if ctx.Err() == nil {
+		observeCaptcha(captchaSolverOCR, err)
+	}
 
 	log.Printf("data: %v\n", data)
 
@@ -148,15 +238,15 @@ func fallbackToCSGTWithVehicleCode(plate, vehicleCode string) (interface{}, erro
 }
 
 // fallbackToCSGT demonstrates an automatic fallback check to csgt.vn
-func fallbackToCSGT(plate string) (interface{}, error) {
+func fallbackToCSGT(ctx context.Context, plate string) (interface{}, error) {
 	// 1) Fetch the captcha image
-	imgBytes, cookieJar, err := fetchCSGTCaptcha()
+	imgBytes, cookieJar, err := fetchCSGTCaptcha(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("fetch captcha failed: %w", err)
 	}
 
 	// 2) Solve the captcha (OCR)
-	captchaText, err := solveCaptchaWithOCR(imgBytes)
+	captchaText, err := solveCaptchaWithOCR(ctx, imgBytes)
 	if err != nil {
 		return nil, fmt.Errorf("captcha OCR failed: %w", err)
 	}
@@ -167,7 +257,7 @@ func fallbackToCSGT(plate string) (interface{}, error) {
 	// 3) Now we have the recognized text; attempt csgt.vn data fetch.
 	// Typically csgt.vn wants "Xe" param => "1" (ô tô), "2" (xe máy), ...
 	// For demonstration, let's just use "1".
-	data, err := fetchDataCSGTWithSession(plate, "1", captchaText, cookieJar)
+	data, err := fetchDataCSGTWithSession(ctx, plate, "1", captchaText, cookieJar)
 
 	log.Printf("data: %v\n", data)
 
@@ -185,36 +275,34 @@ func processPlate(rawPlate string) (string, error) {
 	cleaned := replacer.Replace(rawPlate)
 
 	if cleaned == "" {
-		return "", errors.New("please provide a valid plate number")
+		return "", markError(ErrInvalidPlate, errors.New("please provide a valid plate number"))
 	}
 	// Example: 51K12345 / 51K123456
 	matched, _ := regexp.MatchString(`^\d{2}[A-Z]\d{5,6}$`, cleaned)
 	if !matched {
-		return "", errors.New("invalid plate number format (expected e.g. 51K12345)")
+		return "", markError(ErrInvalidPlate, errors.New("invalid plate number format (expected e.g. 51K12345)"))
 	}
 	return cleaned, nil
 }
 
-func fetchDataPhatNguoi(bienso string) (interface{}, error) {
-	url := "https://api.checkphatnguoi.vn/phatnguoi"
+func fetchDataPhatNguoi(ctx context.Context, bienso string) (interface{}, error) {
 	formData := "bienso=" + bienso
 
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString(formData))
+	req, err := http.NewRequestWithContext(ctx, "POST", primaryLookupURL, bytes.NewBufferString(formData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := upstream.Client(sourcePrimary).Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("connection error: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned status code: %d", resp.StatusCode)
+		return nil, newUpstreamStatusError(resp, "server returned status code: %d")
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -225,7 +313,7 @@ func fetchDataPhatNguoi(bienso string) (interface{}, error) {
 	// 1) Unmarshal to the wrapper
 	var w primaryWrapper
 	if err := json.Unmarshal(body, &w); err != nil {
-		return nil, fmt.Errorf("could not parse JSON from primary: %w", err)
+		return nil, markError(ErrUpstreamSchema, fmt.Errorf("could not parse JSON from primary: %w", err))
 	}
 
 	// 2) If the wrapper "data" is empty => no records => return ErrDataNotFound
@@ -321,55 +409,23 @@ func parsePrimaryRecord(item map[string]interface{}) *CsgtData {
 // Secondary / Fallback: csgt.vn
 // ------------------------------------------------------------------------
 
-// checkPlateCSGTHandler is the existing handler that requires manual captcha input.
-// For the automatic fallback approach, we won't call this directly from the client,
-// but the logic is the same.
-func checkPlateCSGTHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
-		return
-	}
-
-	if err := r.ParseForm(); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
-		return
-	}
-
-	plate := r.FormValue("bienso")
-	vehicleType := r.FormValue("vehicle_type")
-	captcha := r.FormValue("captcha")
-
-	if plate == "" || vehicleType == "" || captcha == "" {
-		writeJSONError(w, http.StatusBadRequest, "Missing bienso, vehicle_type, or captcha")
-		return
-	}
-
-	data, err := fetchDataCSGT(plate, vehicleType, captcha)
-	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-
-	writeJSON(w, http.StatusOK, data)
-}
-
-// fetchDataCSGT is the direct approach.
+// fetchDataCSGT is the direct approach, with a captcha the client solved.
 // For an automatic fallback, we might need to re-use a cookie jar, etc.
-func fetchDataCSGT(plate, vehicleType, captcha string) (interface{}, error) {
-	return fetchDataCSGTWithSession(plate, vehicleType, captcha, nil)
+func fetchDataCSGT(ctx context.Context, plate, vehicleType, captcha string) (interface{}, error) {
+	data, err := fetchDataCSGTWithSession(ctx, plate, vehicleType, captcha, nil)
+	if ctx.Err() == nil {
+		observeCaptcha(captchaSolverClient, err)
+	}
+	return data, err
 }
 
 // fetchDataCSGTWithSession is the same but allows us to carry cookies from captcha request if needed.
-func fetchDataCSGTWithSession(plate, vehicleType, captcha string, cookieJar http.CookieJar) (interface{}, error) {
-	url := "https://www.csgt.vn/?mod=contact&task=tracuu_post&ajax"
-	formData := fmt.Sprintf("BienKS=%s&Xe=%s&captcha=%s&ipClient=9.9.9.91&cUrl=", plate, vehicleType, captcha)
+func fetchDataCSGTWithSession(ctx context.Context, plate, vehicleType, captcha string, cookieJar http.CookieJar) (interface{}, error) {
+	formData := fmt.Sprintf("BienKS=%s&Xe=%s&captcha=%s&ipClient=%s&cUrl=", plate, vehicleType, captcha, csgtClientIP)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	if cookieJar != nil {
-		client.Jar = cookieJar
-	}
+	client := upstream.ClientWithJar(sourceCSGT, cookieJar)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString(formData))
+	req, err := http.NewRequestWithContext(ctx, "POST", csgtLookupURL, bytes.NewBufferString(formData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -381,13 +437,15 @@ func fetchDataCSGTWithSession(plate, vehicleType, captcha string, cookieJar http
 	if err != nil {
 		return nil, fmt.Errorf("connection error: %w", err)
 	}
-	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned status code: %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, newUpstreamStatusError(resp, "server returned status code: %d")
 	}
 
+	// The body holds a csgt.vn limiter slot; close it before following href,
+	// which needs a slot of its own
 	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
@@ -396,13 +454,13 @@ func fetchDataCSGTWithSession(plate, vehicleType, captcha string, cookieJar http
 	log.Printf("Raw CSGT response: %s\n", content)
 
 	if content == "404" {
-		return nil, errors.New("csgt.vn: captcha incorrect or request rejected (404)")
+		return nil, ErrCaptchaRejected
 	}
 
 	// // Attempt to parse JSON response
 	var result map[string]interface{}
 	if err := json.Unmarshal([]byte(content), &result); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+		return nil, markError(ErrUpstreamSchema, fmt.Errorf("failed to parse JSON: %v", err))
 	}
 
 	log.Printf("CSGT response (JSON): %v\n", result)
@@ -411,7 +469,7 @@ func fetchDataCSGTWithSession(plate, vehicleType, captcha string, cookieJar http
 	hrefVal, ok := result["href"].(string)
 	if ok && hrefVal != "" {
 		// 1) Fetch that HTML page
-		htmlContent, err := fetchCSGTHtml(hrefVal, client)
+		htmlContent, err := fetchCSGTHtml(ctx, hrefVal, client)
 
 		// log.Println("CSGT full HTML:\n", htmlContent)
 
@@ -435,15 +493,19 @@ func fetchDataCSGTWithSession(plate, vehicleType, captcha string, cookieJar http
 	return result, nil
 }
 
-func fetchCSGTHtml(url string, client *http.Client) (string, error) {
-	resp, err := client.Get(url)
+func fetchCSGTHtml(ctx context.Context, url string, client *http.Client) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("GET %q failed: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("csgt.vn returned non-200 status: %d", resp.StatusCode)
+		return "", newUpstreamStatusError(resp, "csgt.vn returned non-200 status: %d")
 	}
 
 	bytes, err := io.ReadAll(resp.Body)
@@ -459,21 +521,16 @@ func fetchCSGTHtml(url string, client *http.Client) (string, error) {
 // ------------------------------------------------------------------------
 
 // fetchCSGTCaptcha retrieves the captcha image and saves it locally for debugging.
-func fetchCSGTCaptcha() ([]byte, http.CookieJar, error) {
-	captchaURL := "https://www.csgt.vn/lib/captcha/captcha.class.php"
-
+func fetchCSGTCaptcha(ctx context.Context) ([]byte, http.CookieJar, error) {
 	// Create a new cookie jar explicitly
 	cookieJar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create cookie jar: %w", err)
 	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Jar:     cookieJar,
-	}
+	client := upstream.ClientWithJar(sourceCSGT, cookieJar)
 
-	req, err := http.NewRequest("GET", captchaURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", csgtCaptchaURL, nil)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create captcha request: %w", err)
 	}
@@ -485,7 +542,7 @@ func fetchCSGTCaptcha() ([]byte, http.CookieJar, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("captcha endpoint returned status code: %d", resp.StatusCode)
+		return nil, nil, newUpstreamStatusError(resp, "captcha endpoint returned status code: %d")
 	}
 
 	imgBytes, err := io.ReadAll(resp.Body)
@@ -494,17 +551,19 @@ func fetchCSGTCaptcha() ([]byte, http.CookieJar, error) {
 	}
 
 	// Save the captcha image to the folder
-	if saveErr := saveCaptchaImage(imgBytes); saveErr != nil {
-		log.Printf("Failed to save captcha image: %v\n", saveErr)
+	if captchaLogDir != "" {
+		if saveErr := saveCaptchaImage(imgBytes); saveErr != nil {
+			log.Printf("Failed to save captcha image: %v\n", saveErr)
+		}
 	}
 
 	return imgBytes, cookieJar, nil
 }
 
-// saveCaptchaImage saves the captcha image to the "captchaImageLogs" folder with a timestamped filename.
+// saveCaptchaImage saves the captcha image to the captchaLogDir folder with a timestamped filename.
 func saveCaptchaImage(imgBytes []byte) error {
 	// Create the directory if it doesn't exist
-	dir := "captchaImageLogs"
+	dir := captchaLogDir
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create directory %q: %w", dir, err)
 	}
@@ -523,27 +582,13 @@ func saveCaptchaImage(imgBytes []byte) error {
 }
 
 // solveCaptchaWithOCR uses an OCR library to decode the captcha text.
-// We'll use github.com/otiai10/gosseract/v2 as an example.
-func solveCaptchaWithOCR(imgBytes []byte) (string, error) {
-	client := gosseract.NewClient()
-	defer client.Close()
-
-	// Feed the raw image bytes to the OCR engine
-	if err := client.SetImageFromBytes(imgBytes); err != nil {
-		return "", fmt.Errorf("failed to set image bytes to OCR: %w", err)
-	}
-
-	// Optionally configure some OCR settings, e.g.:
-	//   client.SetLanguage("eng")
-	//   client.SetWhitelist("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
-
-	text, err := client.Text()
-	if err != nil {
-		return "", fmt.Errorf("failed to recognize text via OCR: %w", err)
-	}
-
-	text = strings.TrimSpace(text)
-	return text, nil
+// We'll use github.com/otiai10/gosseract/v2 as an example, through a bounded
+// pool of workers (see ocrPool).
+//
+// Tesseract can't be interrupted, so when ctx is done first the result is
+// dropped and ctx's error returned; the engine finishes in the background.
+func solveCaptchaWithOCR(ctx context.Context, imgBytes []byte) (string, error) {
+	return captchaOCR().solve(ctx, imgBytes)
 }
 
 // ------------------------------------------------------------------------
@@ -562,7 +607,27 @@ func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 }
 
 func writeJSONError(w http.ResponseWriter, statusCode int, errMsg string) {
-	writeJSON(w, statusCode, map[string]string{"error": errMsg})
+	writeLegacyError(w, statusCode, &apiError{Code: codeForStatus(statusCode), Message: errMsg})
+}
+
+// writeJSONFile stores data as indented JSON at path. It writes to a temp file
+// first so a crash never leaves a truncated file behind.
+func writeJSONFile(path string, data interface{}) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", path, err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", dir, err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write file %q: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
 }
 
 // -----------------------------------------------------------------------
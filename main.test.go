@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,12 +13,12 @@ var captchaURL = "https://www.csgt.vn/lib/captcha/captcha.class.php"
 
 func TestFetchCSGTCaptcha(t *testing.T) {
 	// Call fetchCSGTCaptcha twice
-	_, jar1, err1 := fetchCSGTCaptcha()
+	_, jar1, err1 := fetchCSGTCaptcha(context.Background())
 	if err1 != nil {
 		t.Fatalf("First call to fetchCSGTCaptcha failed: %v", err1)
 	}
 
-	_, jar2, err2 := fetchCSGTCaptcha()
+	_, jar2, err2 := fetchCSGTCaptcha(context.Background())
 	if err2 != nil {
 		t.Fatalf("Second call to fetchCSGTCaptcha failed: %v", err2)
 	}
@@ -46,7 +47,7 @@ func TestFetchCSGTCaptcha_NetworkTimeout(t *testing.T) {
 	defer func() { http.DefaultClient.Timeout = originalTimeout }()
 
 	// Call the function
-	_, _, err := fetchCSGTCaptcha()
+	_, _, err := fetchCSGTCaptcha(context.Background())
 
 	// Check if the error is a timeout error
 	if err == nil {
@@ -70,7 +71,7 @@ func TestFetchCSGTCaptcha_NonOKStatus(t *testing.T) {
 	defer func() { captchaURL = originalCaptchaURL }()
 
 	// Call the function
-	_, _, err := fetchCSGTCaptcha()
+	_, _, err := fetchCSGTCaptcha(context.Background())
 
 	// Check if the error message contains the correct status code
 	if err == nil {
@@ -97,7 +98,7 @@ func TestFetchCSGTCaptcha_Success(t *testing.T) {
 	defer func() { captchaURL = originalCaptchaURL }()
 
 	// Call the function
-	imgBytes, jar, err := fetchCSGTCaptcha()
+	imgBytes, jar, err := fetchCSGTCaptcha(context.Background())
 
 	// Check for errors
 	if err != nil {
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ------------------------------------------------------------------------
+// Prometheus metrics, served on GET /metrics
+// ------------------------------------------------------------------------
+
+const metricsNamespace = "phatnguoi"
+
+// latencyBuckets go from a quick primary answer up to a csgt.vn fallback
+// that went through every captcha attempt.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"endpoint", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, by route pattern and method.",
+		Buckets:   latencyBuckets,
+	}, []string{"endpoint", "method"})
+
+	sourceLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "source_lookups_total",
+		Help:      "Lookups sent to each source, by outcome (found, not_found, circuit_open or error).",
+	}, []string{"source", "outcome"})
+
+	sourceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "source_lookup_duration_seconds",
+		Help:      "Time a source took to answer a lookup, retries and captcha attempts included.",
+		Buckets:   latencyBuckets,
+	}, []string{"source"})
+
+	fallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fallbacks_total",
+		Help:      "Lookups that fell back to csgt.vn, by reason (no_data or primary_unavailable).",
+	}, []string{"reason"})
+
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_retries_total",
+		Help:      "Upstream calls retried after a transient failure, by source and outcome (retried, recovered or exhausted).",
+	}, []string{"source", "outcome"})
+
+	captchaAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "captcha_attempts_total",
+		Help:      "csgt.vn captchas tried, by solver (ocr or client) and result (accepted, rejected, unsolved or error).",
+	}, []string{"solver", "result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, sourceLookups, sourceDuration, fallbacks, upstreamRetries, captchaAttemptsTotal,
+		ocrCollector{},
+	)
+}
+
+// registerMetricsRoutes serves the registry at GET /metrics.
+func registerMetricsRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
+
+// ---- HTTP ----
+
+// instrumentHTTP counts and times the requests next serves, labelled with the
+// pattern mux routes them to so the label set stays bounded. Requests no
+// pattern matches are labelled "unmatched".
+func instrumentHTTP(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, endpoint := mux.Handler(r)
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpDuration.WithLabelValues(endpoint, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(endpoint, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// statusRecorder remembers the status code written through it. It passes
+// Flush on, which the streaming endpoints need.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// ---- lookups ----
+
+// observeSourceLookup records one lookup sent to source that took since
+// start and ended with err.
+func observeSourceLookup(source string, start time.Time, err error) {
+	outcome := "found"
+	switch {
+	case errors.Is(err, ErrDataNotFound):
+		outcome = "not_found"
+	case errors.Is(err, ErrCircuitOpen):
+		// Nothing was sent, so there is no latency to record
+		sourceLookups.WithLabelValues(source, "circuit_open").Inc()
+		return
+	case err != nil:
+		outcome = "error"
+	}
+	sourceLookups.WithLabelValues(source, outcome).Inc()
+	sourceDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+}
+
+const (
+	captchaSolverOCR    = "ocr"
+	captchaSolverClient = "client"
+)
+
+// observeCaptcha records what csgt.vn made of a captcha from solver: err is
+// the lookup's error. An upstream failure says nothing about the captcha and
+// counts as "error". The acceptance rate of a solver is
+// accepted / (accepted + rejected).
+func observeCaptcha(solver string, err error) {
+	result := "accepted"
+	switch {
+	case err == nil, errors.Is(err, ErrDataNotFound):
+	case errors.Is(err, ErrCaptchaRejected):
+		result = "rejected"
+	case errors.Is(err, ErrCaptchaUnsolved):
+		result = "unsolved"
+	default:
+		result = "error"
+	}
+	captchaAttemptsTotal.WithLabelValues(solver, result).Inc()
+}
+
+// ---- OCR ----
+
+// ocrCollector exports the OCR pool's expvar counters (see ocrMetrics).
+type ocrCollector struct{}
+
+var (
+	ocrQueueDepthDesc  = prometheus.NewDesc(metricsNamespace+"_ocr_queue_depth", "Captchas waiting for an OCR worker.", nil, nil)
+	ocrBusyWorkersDesc = prometheus.NewDesc(metricsNamespace+"_ocr_busy_workers", "OCR workers recognizing a captcha.", nil, nil)
+	ocrWorkersDesc     = prometheus.NewDesc(metricsNamespace+"_ocr_workers", "OCR workers in the pool.", nil, nil)
+	ocrCaptchasDesc    = prometheus.NewDesc(metricsNamespace+"_ocr_captchas_total", "Captchas handed to the OCR pool, by result (solved, failed, rejected, timeouts or abandoned).", []string{"result"}, nil)
+	ocrWaitDesc        = prometheus.NewDesc(metricsNamespace+"_ocr_wait_seconds_total", "Time captchas spent in the OCR queue.", nil, nil)
+	ocrSolveDesc       = prometheus.NewDesc(metricsNamespace+"_ocr_solve_seconds_total", "Time OCR workers spent recognizing captchas.", nil, nil)
+)
+
+func (ocrCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{ocrQueueDepthDesc, ocrBusyWorkersDesc, ocrWorkersDesc, ocrCaptchasDesc, ocrWaitDesc, ocrSolveDesc} {
+		ch <- d
+	}
+}
+
+func (ocrCollector) Collect(ch chan<- prometheus.Metric) {
+	var depth, busy, workers float64
+	if p := currentOCRPool.Load(); p != nil {
+		depth, busy, workers = float64(len(p.jobs)), float64(p.busy.Load()), float64(p.cfg.Workers)
+	}
+	ch <- prometheus.MustNewConstMetric(ocrQueueDepthDesc, prometheus.GaugeValue, depth)
+	ch <- prometheus.MustNewConstMetric(ocrBusyWorkersDesc, prometheus.GaugeValue, busy)
+	ch <- prometheus.MustNewConstMetric(ocrWorkersDesc, prometheus.GaugeValue, workers)
+
+	counter := func(name string) float64 {
+		if v, ok := ocrMetrics.Get(name).(*expvar.Int); ok {
+			return float64(v.Value())
+		}
+		return 0
+	}
+	for _, result := range []string{"solved", "failed", "rejected", "timeouts", "abandoned"} {
+		ch <- prometheus.MustNewConstMetric(ocrCaptchasDesc, prometheus.CounterValue, counter(result), result)
+	}
+	ch <- prometheus.MustNewConstMetric(ocrWaitDesc, prometheus.CounterValue, counter("wait_ms")/1000)
+	ch <- prometheus.MustNewConstMetric(ocrSolveDesc, prometheus.CounterValue, counter("solve_ms")/1000)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c *prometheus.CounterVec, labels ...string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.WithLabelValues(labels...).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMetrics_HTTPRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Expected the instrumented writer to be a Flusher")
+		}
+		w.Write([]byte("data: x\n\n"))
+	})
+	h := instrumentHTTP(mux, mux)
+
+	created := counterValue(t, httpRequests, "POST /things/{id}", "POST", "201")
+	streamed := counterValue(t, httpRequests, "GET /stream", "GET", "200")
+	unmatched := counterValue(t, httpRequests, "unmatched", "GET", "404")
+	for _, path := range []string{"/things/1", "/things/2"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/plates/51K12345", nil))
+
+	if d := counterValue(t, httpRequests, "POST /things/{id}", "POST", "201") - created; d != 2 {
+		t.Errorf("Expected both requests under their route pattern, got %v", d)
+	}
+	if d := counterValue(t, httpRequests, "GET /stream", "GET", "200") - streamed; d != 1 {
+		t.Errorf("Expected an implicit 200, got %v", d)
+	}
+	if d := counterValue(t, httpRequests, "unmatched", "GET", "404") - unmatched; d != 1 {
+		t.Errorf("Expected unknown paths to share one label, got %v", d)
+	}
+}
+
+func TestMetrics_Captchas(t *testing.T) {
+	tests := []struct {
+		err    error
+		result string
+	}{
+		{nil, "accepted"},
+		{ErrDataNotFound, "accepted"},
+		{ErrCaptchaRejected, "rejected"},
+		{markError(ErrCaptchaUnsolved, errors.New("unreadable")), "unsolved"},
+		{markError(ErrUpstreamUnavailable, errors.New("503")), "error"},
+	}
+	for _, tt := range tests {
+		before := counterValue(t, captchaAttemptsTotal, captchaSolverClient, tt.result)
+		observeCaptcha(captchaSolverClient, tt.err)
+		if d := counterValue(t, captchaAttemptsTotal, captchaSolverClient, tt.result) - before; d != 1 {
+			t.Errorf("Expected %v to count as %s", tt.err, tt.result)
+		}
+	}
+}
+
+func TestMetrics_LookupChain(t *testing.T) {
+	saved := breakers
+	t.Cleanup(func() { breakers = saved })
+	breakers = newBreakers(func(string) (int, time.Duration) { return 1, time.Hour })
+	for _, b := range breakers {
+		b.allow()
+		b.done(markError(ErrUpstreamUnavailable, errors.New("down")), false)
+	}
+
+	primary := counterValue(t, sourceLookups, sourcePrimary, "circuit_open")
+	csgt := counterValue(t, sourceLookups, sourceCSGT, "circuit_open")
+	fallback := counterValue(t, fallbacks, "primary_unavailable")
+	if _, _, err := lookupViolationsFrom(context.Background(), "51K12345", "1", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected a circuit-open error, got %v", err)
+	}
+	if counterValue(t, sourceLookups, sourcePrimary, "circuit_open")-primary != 1 ||
+		counterValue(t, sourceLookups, sourceCSGT, "circuit_open")-csgt != 1 ||
+		counterValue(t, fallbacks, "primary_unavailable")-fallback != 1 {
+		t.Error("Expected both sources and the fallback to be counted")
+	}
+
+	mux := http.NewServeMux()
+	registerMetricsRoutes(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`phatnguoi_source_lookups_total{outcome="circuit_open",source="csgt.vn"}`,
+		`phatnguoi_fallbacks_total{reason="primary_unavailable"}`,
+		"phatnguoi_ocr_queue_depth",
+		`phatnguoi_ocr_captchas_total{result="solved"}`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected /metrics to include %s", want)
+		}
+	}
+}
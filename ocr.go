@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// ------------------------------------------------------------------------
+// Captcha OCR worker pool
+// ------------------------------------------------------------------------
+
+// ErrOCRBusy is returned when a captcha could not be queued, or waited in the
+// queue until its timeout.
+var ErrOCRBusy = errors.New("OCR workers busy")
+
+// ocrConfig sizes the pool. Each worker holds one Tesseract instance, so
+// Workers bounds the memory OCR can use.
+type ocrConfig struct {
+	Workers int
+	Queue   int           // captchas waiting for a worker
+	Timeout time.Duration // queue wait and recognition, together
+}
+
+var defaultOCRConfig = ocrConfig{
+	Workers: min(runtime.NumCPU(), 4),
+	Queue:   32,
+	Timeout: 20 * time.Second,
+}
+
+// ocrEngine is the part of gosseract.Client a worker uses.
+type ocrEngine interface {
+	SetImageFromBytes(img []byte) error
+	Text() (string, error)
+	Close() error
+}
+
+func newTesseractEngine() ocrEngine {
+	return gosseract.NewClient()
+}
+
+// ocrMetrics are served on /debug/vars under "ocr": the queue depth, busy
+// workers, solves and their outcome, and the total time spent waiting in the
+// queue and recognizing (divide by "solved" + "failed" for averages).
+var ocrMetrics = expvar.NewMap("ocr")
+
+func init() {
+	ocrMetrics.Set("queue_depth", expvar.Func(func() interface{} {
+		if p := currentOCRPool.Load(); p != nil {
+			return len(p.jobs)
+		}
+		return 0
+	}))
+	ocrMetrics.Set("busy_workers", expvar.Func(func() interface{} {
+		if p := currentOCRPool.Load(); p != nil {
+			return p.busy.Load()
+		}
+		return 0
+	}))
+}
+
+type ocrResult struct {
+	text string
+	err  error
+}
+
+type ocrJob struct {
+	ctx     context.Context
+	img     []byte
+	queued  time.Time
+	started atomic.Bool
+	done    chan ocrResult // buffered, so an abandoned job never blocks its worker
+}
+
+// ocrPool runs captchas through a fixed set of workers, each reusing its own
+// engine. Tesseract can't be interrupted: a caller that gives up gets its
+// error right away, and a job it abandoned in the queue is skipped.
+type ocrPool struct {
+	cfg       ocrConfig
+	newEngine func() ocrEngine
+	jobs      chan *ocrJob
+	busy      atomic.Int64
+	wg        sync.WaitGroup
+}
+
+// newOCRPool starts cfg.Workers workers, each creating its engine up front.
+func newOCRPool(cfg ocrConfig, newEngine func() ocrEngine) *ocrPool {
+	p := &ocrPool{cfg: cfg, newEngine: newEngine, jobs: make(chan *ocrJob, cfg.Queue)}
+	for range cfg.Workers {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Close stops the workers once the queue is drained.
+func (p *ocrPool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+func (p *ocrPool) work() {
+	defer p.wg.Done()
+	engine := p.newEngine()
+	defer func() { engine.Close() }()
+
+	for job := range p.jobs {
+		if job.ctx.Err() != nil {
+			ocrMetrics.Add("abandoned", 1)
+			continue
+		}
+		job.started.Store(true)
+		p.busy.Add(1)
+		start := time.Now()
+		ocrMetrics.Add("wait_ms", start.Sub(job.queued).Milliseconds())
+
+		text, err := recognize(engine, job.img)
+
+		ocrMetrics.Add("solve_ms", time.Since(start).Milliseconds())
+		p.busy.Add(-1)
+		if err != nil {
+			ocrMetrics.Add("failed", 1)
+			// Start the next captcha on a fresh engine, in case this one is
+			// left in a bad state
+			engine.Close()
+			engine = p.newEngine()
+		} else {
+			ocrMetrics.Add("solved", 1)
+		}
+		job.done <- ocrResult{text, err}
+	}
+}
+
+func recognize(engine ocrEngine, img []byte) (string, error) {
+	// Feed the raw image bytes to the OCR engine
+	if err := engine.SetImageFromBytes(img); err != nil {
+		return "", fmt.Errorf("failed to set image bytes to OCR: %w", err)
+	}
+
+	// Optionally configure some OCR settings, e.g.:
+	//   client.SetLanguage("eng")
+	//   client.SetWhitelist("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+	text, err := engine.Text()
+	if err != nil {
+		return "", fmt.Errorf("failed to recognize text via OCR: %w", err)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// solve queues img and waits for its text, at most cfg.Timeout. A full queue
+// fails right away with ErrOCRBusy.
+func (p *ocrPool) solve(ctx context.Context, img []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	jobCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	job := &ocrJob{ctx: jobCtx, img: img, queued: time.Now(), done: make(chan ocrResult, 1)}
+	select {
+	case p.jobs <- job:
+	default:
+		ocrMetrics.Add("rejected", 1)
+		return "", fmt.Errorf("%w: %d captchas queued", ErrOCRBusy, p.cfg.Queue)
+	}
+
+	select {
+	case res := <-job.done:
+		return res.text, res.err
+	case <-jobCtx.Done():
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		ocrMetrics.Add("timeouts", 1)
+		if !job.started.Load() {
+			return "", fmt.Errorf("%w: no worker free within %s", ErrOCRBusy, p.cfg.Timeout)
+		}
+		return "", fmt.Errorf("OCR did not finish within %s", p.cfg.Timeout)
+	}
+}
+
+// currentOCRPool serves solveCaptchaWithOCR. main starts it with the
+// configured size; otherwise a default pool starts on first use.
+var (
+	currentOCRPool atomic.Pointer[ocrPool]
+	ocrPoolMu      sync.Mutex
+)
+
+func captchaOCR() *ocrPool {
+	if p := currentOCRPool.Load(); p != nil {
+		return p
+	}
+	ocrPoolMu.Lock()
+	defer ocrPoolMu.Unlock()
+	if p := currentOCRPool.Load(); p != nil {
+		return p
+	}
+	p := newOCRPool(defaultOCRConfig, newTesseractEngine)
+	currentOCRPool.Store(p)
+	return p
+}
+
+// startOCRPool starts the pool with the configured size.
+func startOCRPool(cfg ocrConfig) {
+	currentOCRPool.Store(newOCRPool(cfg, newTesseractEngine))
+	log.Printf("OCR pool: %d workers, queue of %d, timeout %s\n", cfg.Workers, cfg.Queue, cfg.Timeout)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOCR hands out engines that read "text:<answer>" images, optionally
+// blocking on gate first.
+type fakeOCR struct {
+	created atomic.Int32
+	closed  atomic.Int32
+	solved  atomic.Int32
+	gate    chan struct{}
+}
+
+type fakeEngine struct {
+	f   *fakeOCR
+	img []byte
+}
+
+func (f *fakeOCR) newEngine() ocrEngine {
+	f.created.Add(1)
+	return &fakeEngine{f: f}
+}
+
+func (e *fakeEngine) SetImageFromBytes(img []byte) error { e.img = img; return nil }
+func (e *fakeEngine) Close() error                       { e.f.closed.Add(1); return nil }
+
+func (e *fakeEngine) Text() (string, error) {
+	if e.f.gate != nil {
+		<-e.f.gate
+	}
+	e.f.solved.Add(1)
+	if string(e.img) == "garbage" {
+		return "", errors.New("unreadable")
+	}
+	return " " + string(e.img) + "\n", nil
+}
+
+func TestOCR_ReusesWorkers(t *testing.T) {
+	f := &fakeOCR{}
+	p := newOCRPool(ocrConfig{Workers: 2, Queue: 10, Timeout: time.Second}, f.newEngine)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if text, err := p.solve(context.Background(), []byte("ABC12")); err != nil || text != "ABC12" {
+				t.Errorf("Expected ABC12, got %q (%v)", text, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := f.created.Load(); n != 2 {
+		t.Errorf("Expected 2 engines for 10 captchas, got %d", n)
+	}
+
+	// A failed recognition moves its worker to a fresh engine
+	if _, err := p.solve(context.Background(), []byte("garbage")); err == nil {
+		t.Error("Expected an OCR error")
+	}
+	p.Close()
+	if created, closed := f.created.Load(), f.closed.Load(); created != 3 || closed != 3 {
+		t.Errorf("Expected 3 engines, all closed, got %d created and %d closed", created, closed)
+	}
+}
+
+func TestOCR_BoundedQueueAndTimeouts(t *testing.T) {
+	f := &fakeOCR{gate: make(chan struct{})}
+	p := newOCRPool(ocrConfig{Workers: 1, Queue: 1, Timeout: 100 * time.Millisecond}, f.newEngine)
+	defer p.Close()
+
+	// The only worker gets stuck on the first captcha...
+	running := make(chan error, 1)
+	go func() {
+		_, err := p.solve(context.Background(), []byte("slow"))
+		running <- err
+	}()
+	for p.busy.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...the second one waits in the queue, and a third does not fit
+	queued := make(chan error, 1)
+	go func() {
+		_, err := p.solve(context.Background(), []byte("queued"))
+		queued <- err
+	}()
+	for len(p.jobs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := p.solve(context.Background(), []byte("rejected"))
+	if !errors.Is(err, ErrOCRBusy) || errorCode(err, http.StatusBadGateway) != codeOverloaded {
+		t.Fatalf("Expected a full queue to reject right away, got %v", err)
+	}
+
+	if err := <-queued; !errors.Is(err, ErrOCRBusy) {
+		t.Errorf("Expected the queued captcha to time out as busy, got %v", err)
+	}
+	if err := <-running; err == nil || errors.Is(err, ErrOCRBusy) {
+		t.Errorf("Expected the running captcha to time out, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.solve(ctx, []byte("never")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// The worker finishes the stuck captcha and skips the abandoned one
+	close(f.gate)
+	for len(p.jobs) > 0 || p.busy.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if text, err := p.solve(context.Background(), []byte("next")); err != nil || text != "next" {
+		t.Fatalf("Expected the pool to recover, got %q (%v)", text, err)
+	}
+	if n := f.solved.Load(); n != 2 {
+		t.Errorf("Expected the abandoned captcha to be skipped, got %d recognitions", n)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// ------------------------------------------------------------------------
+// OpenAPI document + API explorer
+// ------------------------------------------------------------------------
+
+// openAPISpec describes every route registered in this package. Keep it in
+// sync when adding or changing handlers; openapi_test.go fails otherwise.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIExplorer is a Swagger UI page that loads /openapi.json.
+//
+//go:embed openapi.html
+var openAPIExplorer []byte
+
+func registerOpenAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		_, _ = w.Write(openAPISpec)
+	})
+	mux.HandleFunc("GET /docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(openAPIExplorer)
+	})
+}
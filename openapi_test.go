@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type openAPIDoc struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+// registeredRoute is a pattern passed to HandleFunc/Handle, or a prefix
+// passed to mountV1, found in the package sources.
+type registeredRoute struct {
+	method, path string
+	v1Mount      bool
+	pos          string
+}
+
+// sourceRoutes collects every route pattern registered in the package by
+// looking for string literals passed to HandleFunc, Handle and mountV1.
+func sourceRoutes(t *testing.T) []registeredRoute {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var routes []registeredRoute
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", name, err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var fn string
+			switch f := call.Fun.(type) {
+			case *ast.SelectorExpr:
+				fn = f.Sel.Name
+			case *ast.Ident:
+				fn = f.Name
+			}
+
+			argIdx := 0
+			if fn == "mountV1" {
+				argIdx = 1
+			} else if fn != "HandleFunc" && fn != "Handle" {
+				return true
+			}
+			if len(call.Args) <= argIdx {
+				return true
+			}
+			lit, ok := call.Args[argIdx].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			pattern, _ := strconv.Unquote(lit.Value)
+
+			route := registeredRoute{path: pattern, v1Mount: fn == "mountV1", pos: fset.Position(lit.Pos()).String()}
+			if method, path, found := strings.Cut(pattern, " "); found {
+				route.method, route.path = strings.ToLower(method), path
+			}
+			routes = append(routes, route)
+			return true
+		})
+	}
+	return routes
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("Expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+	return doc
+}
+
+// TestOpenAPI_InSyncWithHandlers fails when a route is registered without
+// being documented, or documented without being registered.
+func TestOpenAPI_InSyncWithHandlers(t *testing.T) {
+	doc := loadOpenAPI(t)
+	routes := sourceRoutes(t)
+	if len(routes) < 20 {
+		t.Fatalf("Found only %d routes in the sources, the scan is probably broken", len(routes))
+	}
+
+	documented := func(method, path string) bool {
+		ops, ok := doc.Paths[path]
+		if !ok {
+			return false
+		}
+		if method == "" {
+			return len(ops) > 0
+		}
+		_, ok = ops[method]
+		return ok
+	}
+
+	// What the sources serve, as "method path" ("" method = any)
+	served := make(map[string]bool)
+	serve := func(method, path string) {
+		served[method+" "+path] = true
+	}
+
+	for _, r := range routes {
+		if !r.v1Mount {
+			serve(r.method, r.path)
+			if !documented(r.method, r.path) {
+				t.Errorf("%s: %s %s is not documented in openapi.json", r.pos, strings.ToUpper(r.method), r.path)
+			}
+		}
+	}
+
+	// mountV1 serves the unversioned routes under its prefix again under /v1.
+	// A prefix without an unversioned route of its own (e.g. /lookups/batch,
+	// which reuses /checkplate/batch) must be documented itself.
+	for _, m := range routes {
+		if !m.v1Mount {
+			continue
+		}
+		matched := false
+		for _, r := range routes {
+			if r.v1Mount {
+				continue
+			}
+			if r.path == m.path || (strings.HasSuffix(m.path, "/") && strings.HasPrefix(r.path, m.path)) {
+				matched = true
+				serve(r.method, "/v1"+r.path)
+				if !documented(r.method, "/v1"+r.path) {
+					t.Errorf("%s: %s /v1%s is not documented in openapi.json", m.pos, strings.ToUpper(r.method), r.path)
+				}
+			}
+		}
+		if !matched && !strings.HasSuffix(m.path, "/") {
+			serve("", "/v1"+m.path)
+			if !documented("", "/v1"+m.path) {
+				t.Errorf("%s: /v1%s is not documented in openapi.json", m.pos, m.path)
+			}
+		}
+	}
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for method := range doc.Paths[path] {
+			if !served[method+" "+path] && !served[" "+path] {
+				t.Errorf("openapi.json documents %s %s, which no handler serves", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_SchemaMatchesCsgtData(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := json.Marshal(CsgtData{})
+	var fields map[string]interface{}
+	_ = json.Unmarshal(raw, &fields)
+
+	props := doc.Components.Schemas["CsgtData"].Properties
+	for field := range fields {
+		if _, ok := props[field]; !ok {
+			t.Errorf("CsgtData field %q missing from the schema", field)
+		}
+	}
+	for prop := range props {
+		if _, ok := fields[prop]; !ok {
+			t.Errorf("Schema property %q is not a CsgtData field", prop)
+		}
+	}
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	mux := http.NewServeMux()
+	registerOpenAPIRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("Expected JSON spec, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/openapi.json") {
+		t.Errorf("Expected explorer page loading the spec, got %d", rec.Code)
+	}
+}
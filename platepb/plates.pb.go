@@ -0,0 +1,874 @@
+// Plate lookups over gRPC. Served by the kiemtraphatnguoi binary on GRPC_ADDR
+// (default :9090), next to the HTTP API, and backed by the same lookup chain:
+// checkphatnguoi.vn first, then csgt.vn with an OCR-solved captcha.
+//
+// Regenerate the Go code in platepb/ with:
+//
+//	protoc -I proto --go_out=. --go_opt=module=henry0hai/kiemtraphatnguoi \
+//	  --go-grpc_out=. --go-grpc_opt=module=henry0hai/kiemtraphatnguoi proto/plates.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: plates.proto
+
+package platepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type VehicleType int32
+
+const (
+	// Treated as VEHICLE_TYPE_OTO.
+	VehicleType_VEHICLE_TYPE_UNSPECIFIED VehicleType = 0
+	VehicleType_VEHICLE_TYPE_OTO         VehicleType = 1
+	VehicleType_VEHICLE_TYPE_XEMAY       VehicleType = 2
+)
+
+// Enum value maps for VehicleType.
+var (
+	VehicleType_name = map[int32]string{
+		0: "VEHICLE_TYPE_UNSPECIFIED",
+		1: "VEHICLE_TYPE_OTO",
+		2: "VEHICLE_TYPE_XEMAY",
+	}
+	VehicleType_value = map[string]int32{
+		"VEHICLE_TYPE_UNSPECIFIED": 0,
+		"VEHICLE_TYPE_OTO":         1,
+		"VEHICLE_TYPE_XEMAY":       2,
+	}
+)
+
+func (x VehicleType) Enum() *VehicleType {
+	p := new(VehicleType)
+	*p = x
+	return p
+}
+
+func (x VehicleType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (VehicleType) Descriptor() protoreflect.EnumDescriptor {
+	return file_plates_proto_enumTypes[0].Descriptor()
+}
+
+func (VehicleType) Type() protoreflect.EnumType {
+	return &file_plates_proto_enumTypes[0]
+}
+
+func (x VehicleType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use VehicleType.Descriptor instead.
+func (VehicleType) EnumDescriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{0}
+}
+
+// Violation mirrors the CsgtData JSON object of the HTTP API.
+type Violation struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Plate              string                 `protobuf:"bytes,1,opt,name=plate,proto3" json:"plate,omitempty"`
+	PlateColor         string                 `protobuf:"bytes,2,opt,name=plate_color,json=plateColor,proto3" json:"plate_color,omitempty"`
+	VehicleType        string                 `protobuf:"bytes,3,opt,name=vehicle_type,json=vehicleType,proto3" json:"vehicle_type,omitempty"`
+	ViolationTime      string                 `protobuf:"bytes,4,opt,name=violation_time,json=violationTime,proto3" json:"violation_time,omitempty"`
+	ViolationPlace     string                 `protobuf:"bytes,5,opt,name=violation_place,json=violationPlace,proto3" json:"violation_place,omitempty"`
+	ViolationAction    string                 `protobuf:"bytes,6,opt,name=violation_action,json=violationAction,proto3" json:"violation_action,omitempty"`
+	Status             string                 `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	DetectedBy         string                 `protobuf:"bytes,8,opt,name=detected_by,json=detectedBy,proto3" json:"detected_by,omitempty"`
+	ResolutionLocation string                 `protobuf:"bytes,9,opt,name=resolution_location,json=resolutionLocation,proto3" json:"resolution_location,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *Violation) Reset() {
+	*x = Violation{}
+	mi := &file_plates_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Violation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Violation) ProtoMessage() {}
+
+func (x *Violation) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Violation.ProtoReflect.Descriptor instead.
+func (*Violation) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Violation) GetPlate() string {
+	if x != nil {
+		return x.Plate
+	}
+	return ""
+}
+
+func (x *Violation) GetPlateColor() string {
+	if x != nil {
+		return x.PlateColor
+	}
+	return ""
+}
+
+func (x *Violation) GetVehicleType() string {
+	if x != nil {
+		return x.VehicleType
+	}
+	return ""
+}
+
+func (x *Violation) GetViolationTime() string {
+	if x != nil {
+		return x.ViolationTime
+	}
+	return ""
+}
+
+func (x *Violation) GetViolationPlace() string {
+	if x != nil {
+		return x.ViolationPlace
+	}
+	return ""
+}
+
+func (x *Violation) GetViolationAction() string {
+	if x != nil {
+		return x.ViolationAction
+	}
+	return ""
+}
+
+func (x *Violation) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Violation) GetDetectedBy() string {
+	if x != nil {
+		return x.DetectedBy
+	}
+	return ""
+}
+
+func (x *Violation) GetResolutionLocation() string {
+	if x != nil {
+		return x.ResolutionLocation
+	}
+	return ""
+}
+
+type LookupPlateRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Any common spelling, e.g. "51K-123.45" or "51K12345".
+	Plate         string      `protobuf:"bytes,1,opt,name=plate,proto3" json:"plate,omitempty"`
+	VehicleType   VehicleType `protobuf:"varint,2,opt,name=vehicle_type,json=vehicleType,proto3,enum=kiemtraphatnguoi.v1.VehicleType" json:"vehicle_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupPlateRequest) Reset() {
+	*x = LookupPlateRequest{}
+	mi := &file_plates_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupPlateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupPlateRequest) ProtoMessage() {}
+
+func (x *LookupPlateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupPlateRequest.ProtoReflect.Descriptor instead.
+func (*LookupPlateRequest) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LookupPlateRequest) GetPlate() string {
+	if x != nil {
+		return x.Plate
+	}
+	return ""
+}
+
+func (x *LookupPlateRequest) GetVehicleType() VehicleType {
+	if x != nil {
+		return x.VehicleType
+	}
+	return VehicleType_VEHICLE_TYPE_UNSPECIFIED
+}
+
+type LookupPlateResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The normalized plate, e.g. "51K12345".
+	Plate         string       `protobuf:"bytes,1,opt,name=plate,proto3" json:"plate,omitempty"`
+	VehicleType   VehicleType  `protobuf:"varint,2,opt,name=vehicle_type,json=vehicleType,proto3,enum=kiemtraphatnguoi.v1.VehicleType" json:"vehicle_type,omitempty"`
+	Violations    []*Violation `protobuf:"bytes,3,rep,name=violations,proto3" json:"violations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupPlateResponse) Reset() {
+	*x = LookupPlateResponse{}
+	mi := &file_plates_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupPlateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupPlateResponse) ProtoMessage() {}
+
+func (x *LookupPlateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupPlateResponse.ProtoReflect.Descriptor instead.
+func (*LookupPlateResponse) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *LookupPlateResponse) GetPlate() string {
+	if x != nil {
+		return x.Plate
+	}
+	return ""
+}
+
+func (x *LookupPlateResponse) GetVehicleType() VehicleType {
+	if x != nil {
+		return x.VehicleType
+	}
+	return VehicleType_VEHICLE_TYPE_UNSPECIFIED
+}
+
+func (x *LookupPlateResponse) GetViolations() []*Violation {
+	if x != nil {
+		return x.Violations
+	}
+	return nil
+}
+
+type BatchLookupRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// At most 100 items.
+	Items         []*LookupPlateRequest `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupRequest) Reset() {
+	*x = BatchLookupRequest{}
+	mi := &file_plates_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupRequest) ProtoMessage() {}
+
+func (x *BatchLookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupRequest.ProtoReflect.Descriptor instead.
+func (*BatchLookupRequest) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchLookupRequest) GetItems() []*LookupPlateRequest {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type BatchLookupResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Index int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	// The plate as sent.
+	Input       string       `protobuf:"bytes,2,opt,name=input,proto3" json:"input,omitempty"`
+	Plate       string       `protobuf:"bytes,3,opt,name=plate,proto3" json:"plate,omitempty"`
+	VehicleType VehicleType  `protobuf:"varint,4,opt,name=vehicle_type,json=vehicleType,proto3,enum=kiemtraphatnguoi.v1.VehicleType" json:"vehicle_type,omitempty"`
+	Ok          bool         `protobuf:"varint,5,opt,name=ok,proto3" json:"ok,omitempty"`
+	Violations  []*Violation `protobuf:"bytes,6,rep,name=violations,proto3" json:"violations,omitempty"`
+	Error       string       `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	// Same codes as the HTTP API, e.g. "invalid_plate" or "captcha_failed".
+	ErrorCode     string `protobuf:"bytes,8,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupResult) Reset() {
+	*x = BatchLookupResult{}
+	mi := &file_plates_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupResult) ProtoMessage() {}
+
+func (x *BatchLookupResult) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupResult.ProtoReflect.Descriptor instead.
+func (*BatchLookupResult) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchLookupResult) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *BatchLookupResult) GetInput() string {
+	if x != nil {
+		return x.Input
+	}
+	return ""
+}
+
+func (x *BatchLookupResult) GetPlate() string {
+	if x != nil {
+		return x.Plate
+	}
+	return ""
+}
+
+func (x *BatchLookupResult) GetVehicleType() VehicleType {
+	if x != nil {
+		return x.VehicleType
+	}
+	return VehicleType_VEHICLE_TYPE_UNSPECIFIED
+}
+
+func (x *BatchLookupResult) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *BatchLookupResult) GetViolations() []*Violation {
+	if x != nil {
+		return x.Violations
+	}
+	return nil
+}
+
+func (x *BatchLookupResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *BatchLookupResult) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+type BatchLookupResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Total         int32                  `protobuf:"varint,1,opt,name=total,proto3" json:"total,omitempty"`
+	Succeeded     int32                  `protobuf:"varint,2,opt,name=succeeded,proto3" json:"succeeded,omitempty"`
+	Failed        int32                  `protobuf:"varint,3,opt,name=failed,proto3" json:"failed,omitempty"`
+	Results       []*BatchLookupResult   `protobuf:"bytes,4,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupResponse) Reset() {
+	*x = BatchLookupResponse{}
+	mi := &file_plates_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupResponse) ProtoMessage() {}
+
+func (x *BatchLookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupResponse.ProtoReflect.Descriptor instead.
+func (*BatchLookupResponse) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchLookupResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *BatchLookupResponse) GetSucceeded() int32 {
+	if x != nil {
+		return x.Succeeded
+	}
+	return 0
+}
+
+func (x *BatchLookupResponse) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *BatchLookupResponse) GetResults() []*BatchLookupResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type WatchPlateRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Plate       string                 `protobuf:"bytes,1,opt,name=plate,proto3" json:"plate,omitempty"`
+	VehicleType VehicleType            `protobuf:"varint,2,opt,name=vehicle_type,json=vehicleType,proto3,enum=kiemtraphatnguoi.v1.VehicleType" json:"vehicle_type,omitempty"`
+	// Zero ends the stream after the first result. Otherwise at least one
+	// minute.
+	Interval      *durationpb.Duration `protobuf:"bytes,3,opt,name=interval,proto3" json:"interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchPlateRequest) Reset() {
+	*x = WatchPlateRequest{}
+	mi := &file_plates_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchPlateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchPlateRequest) ProtoMessage() {}
+
+func (x *WatchPlateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchPlateRequest.ProtoReflect.Descriptor instead.
+func (*WatchPlateRequest) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *WatchPlateRequest) GetPlate() string {
+	if x != nil {
+		return x.Plate
+	}
+	return ""
+}
+
+func (x *WatchPlateRequest) GetVehicleType() VehicleType {
+	if x != nil {
+		return x.VehicleType
+	}
+	return VehicleType_VEHICLE_TYPE_UNSPECIFIED
+}
+
+func (x *WatchPlateRequest) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+type LookupStage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// querying_primary, primary_empty, solving_captcha, captcha_fetched,
+	// ocr_guess, fetching_csgt, captcha_rejected or results_parsed.
+	Stage         string `protobuf:"bytes,1,opt,name=stage,proto3" json:"stage,omitempty"`
+	Attempt       int32  `protobuf:"varint,2,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Message       string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupStage) Reset() {
+	*x = LookupStage{}
+	mi := &file_plates_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupStage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupStage) ProtoMessage() {}
+
+func (x *LookupStage) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupStage.ProtoReflect.Descriptor instead.
+func (*LookupStage) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *LookupStage) GetStage() string {
+	if x != nil {
+		return x.Stage
+	}
+	return ""
+}
+
+func (x *LookupStage) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *LookupStage) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type WatchPlateEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*WatchPlateEvent_Stage
+	//	*WatchPlateEvent_Result
+	Event         isWatchPlateEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchPlateEvent) Reset() {
+	*x = WatchPlateEvent{}
+	mi := &file_plates_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchPlateEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchPlateEvent) ProtoMessage() {}
+
+func (x *WatchPlateEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_plates_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchPlateEvent.ProtoReflect.Descriptor instead.
+func (*WatchPlateEvent) Descriptor() ([]byte, []int) {
+	return file_plates_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchPlateEvent) GetEvent() isWatchPlateEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *WatchPlateEvent) GetStage() *LookupStage {
+	if x != nil {
+		if x, ok := x.Event.(*WatchPlateEvent_Stage); ok {
+			return x.Stage
+		}
+	}
+	return nil
+}
+
+func (x *WatchPlateEvent) GetResult() *LookupPlateResponse {
+	if x != nil {
+		if x, ok := x.Event.(*WatchPlateEvent_Result); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+type isWatchPlateEvent_Event interface {
+	isWatchPlateEvent_Event()
+}
+
+type WatchPlateEvent_Stage struct {
+	Stage *LookupStage `protobuf:"bytes,1,opt,name=stage,proto3,oneof"`
+}
+
+type WatchPlateEvent_Result struct {
+	Result *LookupPlateResponse `protobuf:"bytes,2,opt,name=result,proto3,oneof"`
+}
+
+func (*WatchPlateEvent_Stage) isWatchPlateEvent_Event() {}
+
+func (*WatchPlateEvent_Result) isWatchPlateEvent_Event() {}
+
+var File_plates_proto protoreflect.FileDescriptor
+
+const file_plates_proto_rawDesc = "" +
+	"\n" +
+	"\fplates.proto\x12\x13kiemtraphatnguoi.v1\x1a\x1egoogle/protobuf/duration.proto\"\xca\x02\n" +
+	"\tViolation\x12\x14\n" +
+	"\x05plate\x18\x01 \x01(\tR\x05plate\x12\x1f\n" +
+	"\vplate_color\x18\x02 \x01(\tR\n" +
+	"plateColor\x12!\n" +
+	"\fvehicle_type\x18\x03 \x01(\tR\vvehicleType\x12%\n" +
+	"\x0eviolation_time\x18\x04 \x01(\tR\rviolationTime\x12'\n" +
+	"\x0fviolation_place\x18\x05 \x01(\tR\x0eviolationPlace\x12)\n" +
+	"\x10violation_action\x18\x06 \x01(\tR\x0fviolationAction\x12\x16\n" +
+	"\x06status\x18\a \x01(\tR\x06status\x12\x1f\n" +
+	"\vdetected_by\x18\b \x01(\tR\n" +
+	"detectedBy\x12/\n" +
+	"\x13resolution_location\x18\t \x01(\tR\x12resolutionLocation\"o\n" +
+	"\x12LookupPlateRequest\x12\x14\n" +
+	"\x05plate\x18\x01 \x01(\tR\x05plate\x12C\n" +
+	"\fvehicle_type\x18\x02 \x01(\x0e2 .kiemtraphatnguoi.v1.VehicleTypeR\vvehicleType\"\xb0\x01\n" +
+	"\x13LookupPlateResponse\x12\x14\n" +
+	"\x05plate\x18\x01 \x01(\tR\x05plate\x12C\n" +
+	"\fvehicle_type\x18\x02 \x01(\x0e2 .kiemtraphatnguoi.v1.VehicleTypeR\vvehicleType\x12>\n" +
+	"\n" +
+	"violations\x18\x03 \x03(\v2\x1e.kiemtraphatnguoi.v1.ViolationR\n" +
+	"violations\"S\n" +
+	"\x12BatchLookupRequest\x12=\n" +
+	"\x05items\x18\x01 \x03(\v2'.kiemtraphatnguoi.v1.LookupPlateRequestR\x05items\"\x9f\x02\n" +
+	"\x11BatchLookupResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x14\n" +
+	"\x05input\x18\x02 \x01(\tR\x05input\x12\x14\n" +
+	"\x05plate\x18\x03 \x01(\tR\x05plate\x12C\n" +
+	"\fvehicle_type\x18\x04 \x01(\x0e2 .kiemtraphatnguoi.v1.VehicleTypeR\vvehicleType\x12\x0e\n" +
+	"\x02ok\x18\x05 \x01(\bR\x02ok\x12>\n" +
+	"\n" +
+	"violations\x18\x06 \x03(\v2\x1e.kiemtraphatnguoi.v1.ViolationR\n" +
+	"violations\x12\x14\n" +
+	"\x05error\x18\a \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\b \x01(\tR\terrorCode\"\xa3\x01\n" +
+	"\x13BatchLookupResponse\x12\x14\n" +
+	"\x05total\x18\x01 \x01(\x05R\x05total\x12\x1c\n" +
+	"\tsucceeded\x18\x02 \x01(\x05R\tsucceeded\x12\x16\n" +
+	"\x06failed\x18\x03 \x01(\x05R\x06failed\x12@\n" +
+	"\aresults\x18\x04 \x03(\v2&.kiemtraphatnguoi.v1.BatchLookupResultR\aresults\"\xa5\x01\n" +
+	"\x11WatchPlateRequest\x12\x14\n" +
+	"\x05plate\x18\x01 \x01(\tR\x05plate\x12C\n" +
+	"\fvehicle_type\x18\x02 \x01(\x0e2 .kiemtraphatnguoi.v1.VehicleTypeR\vvehicleType\x125\n" +
+	"\binterval\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\binterval\"W\n" +
+	"\vLookupStage\x12\x14\n" +
+	"\x05stage\x18\x01 \x01(\tR\x05stage\x12\x18\n" +
+	"\aattempt\x18\x02 \x01(\x05R\aattempt\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"\x98\x01\n" +
+	"\x0fWatchPlateEvent\x128\n" +
+	"\x05stage\x18\x01 \x01(\v2 .kiemtraphatnguoi.v1.LookupStageH\x00R\x05stage\x12B\n" +
+	"\x06result\x18\x02 \x01(\v2(.kiemtraphatnguoi.v1.LookupPlateResponseH\x00R\x06resultB\a\n" +
+	"\x05event*Y\n" +
+	"\vVehicleType\x12\x1c\n" +
+	"\x18VEHICLE_TYPE_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10VEHICLE_TYPE_OTO\x10\x01\x12\x16\n" +
+	"\x12VEHICLE_TYPE_XEMAY\x10\x022\xb0\x02\n" +
+	"\fPlateService\x12`\n" +
+	"\vLookupPlate\x12'.kiemtraphatnguoi.v1.LookupPlateRequest\x1a(.kiemtraphatnguoi.v1.LookupPlateResponse\x12`\n" +
+	"\vBatchLookup\x12'.kiemtraphatnguoi.v1.BatchLookupRequest\x1a(.kiemtraphatnguoi.v1.BatchLookupResponse\x12\\\n" +
+	"\n" +
+	"WatchPlate\x12&.kiemtraphatnguoi.v1.WatchPlateRequest\x1a$.kiemtraphatnguoi.v1.WatchPlateEvent0\x01B$Z\"henry0hai/kiemtraphatnguoi/platepbb\x06proto3"
+
+var (
+	file_plates_proto_rawDescOnce sync.Once
+	file_plates_proto_rawDescData []byte
+)
+
+func file_plates_proto_rawDescGZIP() []byte {
+	file_plates_proto_rawDescOnce.Do(func() {
+		file_plates_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_plates_proto_rawDesc), len(file_plates_proto_rawDesc)))
+	})
+	return file_plates_proto_rawDescData
+}
+
+var file_plates_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_plates_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_plates_proto_goTypes = []any{
+	(VehicleType)(0),            // 0: kiemtraphatnguoi.v1.VehicleType
+	(*Violation)(nil),           // 1: kiemtraphatnguoi.v1.Violation
+	(*LookupPlateRequest)(nil),  // 2: kiemtraphatnguoi.v1.LookupPlateRequest
+	(*LookupPlateResponse)(nil), // 3: kiemtraphatnguoi.v1.LookupPlateResponse
+	(*BatchLookupRequest)(nil),  // 4: kiemtraphatnguoi.v1.BatchLookupRequest
+	(*BatchLookupResult)(nil),   // 5: kiemtraphatnguoi.v1.BatchLookupResult
+	(*BatchLookupResponse)(nil), // 6: kiemtraphatnguoi.v1.BatchLookupResponse
+	(*WatchPlateRequest)(nil),   // 7: kiemtraphatnguoi.v1.WatchPlateRequest
+	(*LookupStage)(nil),         // 8: kiemtraphatnguoi.v1.LookupStage
+	(*WatchPlateEvent)(nil),     // 9: kiemtraphatnguoi.v1.WatchPlateEvent
+	(*durationpb.Duration)(nil), // 10: google.protobuf.Duration
+}
+var file_plates_proto_depIdxs = []int32{
+	0,  // 0: kiemtraphatnguoi.v1.LookupPlateRequest.vehicle_type:type_name -> kiemtraphatnguoi.v1.VehicleType
+	0,  // 1: kiemtraphatnguoi.v1.LookupPlateResponse.vehicle_type:type_name -> kiemtraphatnguoi.v1.VehicleType
+	1,  // 2: kiemtraphatnguoi.v1.LookupPlateResponse.violations:type_name -> kiemtraphatnguoi.v1.Violation
+	2,  // 3: kiemtraphatnguoi.v1.BatchLookupRequest.items:type_name -> kiemtraphatnguoi.v1.LookupPlateRequest
+	0,  // 4: kiemtraphatnguoi.v1.BatchLookupResult.vehicle_type:type_name -> kiemtraphatnguoi.v1.VehicleType
+	1,  // 5: kiemtraphatnguoi.v1.BatchLookupResult.violations:type_name -> kiemtraphatnguoi.v1.Violation
+	5,  // 6: kiemtraphatnguoi.v1.BatchLookupResponse.results:type_name -> kiemtraphatnguoi.v1.BatchLookupResult
+	0,  // 7: kiemtraphatnguoi.v1.WatchPlateRequest.vehicle_type:type_name -> kiemtraphatnguoi.v1.VehicleType
+	10, // 8: kiemtraphatnguoi.v1.WatchPlateRequest.interval:type_name -> google.protobuf.Duration
+	8,  // 9: kiemtraphatnguoi.v1.WatchPlateEvent.stage:type_name -> kiemtraphatnguoi.v1.LookupStage
+	3,  // 10: kiemtraphatnguoi.v1.WatchPlateEvent.result:type_name -> kiemtraphatnguoi.v1.LookupPlateResponse
+	2,  // 11: kiemtraphatnguoi.v1.PlateService.LookupPlate:input_type -> kiemtraphatnguoi.v1.LookupPlateRequest
+	4,  // 12: kiemtraphatnguoi.v1.PlateService.BatchLookup:input_type -> kiemtraphatnguoi.v1.BatchLookupRequest
+	7,  // 13: kiemtraphatnguoi.v1.PlateService.WatchPlate:input_type -> kiemtraphatnguoi.v1.WatchPlateRequest
+	3,  // 14: kiemtraphatnguoi.v1.PlateService.LookupPlate:output_type -> kiemtraphatnguoi.v1.LookupPlateResponse
+	6,  // 15: kiemtraphatnguoi.v1.PlateService.BatchLookup:output_type -> kiemtraphatnguoi.v1.BatchLookupResponse
+	9,  // 16: kiemtraphatnguoi.v1.PlateService.WatchPlate:output_type -> kiemtraphatnguoi.v1.WatchPlateEvent
+	14, // [14:17] is the sub-list for method output_type
+	11, // [11:14] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_plates_proto_init() }
+func file_plates_proto_init() {
+	if File_plates_proto != nil {
+		return
+	}
+	file_plates_proto_msgTypes[8].OneofWrappers = []any{
+		(*WatchPlateEvent_Stage)(nil),
+		(*WatchPlateEvent_Result)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_plates_proto_rawDesc), len(file_plates_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_plates_proto_goTypes,
+		DependencyIndexes: file_plates_proto_depIdxs,
+		EnumInfos:         file_plates_proto_enumTypes,
+		MessageInfos:      file_plates_proto_msgTypes,
+	}.Build()
+	File_plates_proto = out.File
+	file_plates_proto_goTypes = nil
+	file_plates_proto_depIdxs = nil
+}
@@ -0,0 +1,224 @@
+// Plate lookups over gRPC. Served by the kiemtraphatnguoi binary on GRPC_ADDR
+// (default :9090), next to the HTTP API, and backed by the same lookup chain:
+// checkphatnguoi.vn first, then csgt.vn with an OCR-solved captcha.
+//
+// Regenerate the Go code in platepb/ with:
+//
+//	protoc -I proto --go_out=. --go_opt=module=henry0hai/kiemtraphatnguoi \
+//	  --go-grpc_out=. --go-grpc_opt=module=henry0hai/kiemtraphatnguoi proto/plates.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: plates.proto
+
+package platepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PlateService_LookupPlate_FullMethodName = "/kiemtraphatnguoi.v1.PlateService/LookupPlate"
+	PlateService_BatchLookup_FullMethodName = "/kiemtraphatnguoi.v1.PlateService/BatchLookup"
+	PlateService_WatchPlate_FullMethodName  = "/kiemtraphatnguoi.v1.PlateService/WatchPlate"
+)
+
+// PlateServiceClient is the client API for PlateService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PlateServiceClient interface {
+	// LookupPlate is POST /v1/lookups.
+	LookupPlate(ctx context.Context, in *LookupPlateRequest, opts ...grpc.CallOption) (*LookupPlateResponse, error)
+	// BatchLookup is POST /checkplate/batch: every plate gets a result, and
+	// one failing plate does not fail the call.
+	BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error)
+	// WatchPlate streams the stages of a lookup (as /checkplate/stream does)
+	// followed by its result. With an interval, the plate is then looked up
+	// again periodically and a new result is sent whenever it changes, until
+	// the client cancels.
+	WatchPlate(ctx context.Context, in *WatchPlateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchPlateEvent], error)
+}
+
+type plateServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPlateServiceClient(cc grpc.ClientConnInterface) PlateServiceClient {
+	return &plateServiceClient{cc}
+}
+
+func (c *plateServiceClient) LookupPlate(ctx context.Context, in *LookupPlateRequest, opts ...grpc.CallOption) (*LookupPlateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupPlateResponse)
+	err := c.cc.Invoke(ctx, PlateService_LookupPlate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *plateServiceClient) BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchLookupResponse)
+	err := c.cc.Invoke(ctx, PlateService_BatchLookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *plateServiceClient) WatchPlate(ctx context.Context, in *WatchPlateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchPlateEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PlateService_ServiceDesc.Streams[0], PlateService_WatchPlate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchPlateRequest, WatchPlateEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PlateService_WatchPlateClient = grpc.ServerStreamingClient[WatchPlateEvent]
+
+// PlateServiceServer is the server API for PlateService service.
+// All implementations must embed UnimplementedPlateServiceServer
+// for forward compatibility.
+type PlateServiceServer interface {
+	// LookupPlate is POST /v1/lookups.
+	LookupPlate(context.Context, *LookupPlateRequest) (*LookupPlateResponse, error)
+	// BatchLookup is POST /checkplate/batch: every plate gets a result, and
+	// one failing plate does not fail the call.
+	BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error)
+	// WatchPlate streams the stages of a lookup (as /checkplate/stream does)
+	// followed by its result. With an interval, the plate is then looked up
+	// again periodically and a new result is sent whenever it changes, until
+	// the client cancels.
+	WatchPlate(*WatchPlateRequest, grpc.ServerStreamingServer[WatchPlateEvent]) error
+	mustEmbedUnimplementedPlateServiceServer()
+}
+
+// UnimplementedPlateServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPlateServiceServer struct{}
+
+func (UnimplementedPlateServiceServer) LookupPlate(context.Context, *LookupPlateRequest) (*LookupPlateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupPlate not implemented")
+}
+func (UnimplementedPlateServiceServer) BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedPlateServiceServer) WatchPlate(*WatchPlateRequest, grpc.ServerStreamingServer[WatchPlateEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPlate not implemented")
+}
+func (UnimplementedPlateServiceServer) mustEmbedUnimplementedPlateServiceServer() {}
+func (UnimplementedPlateServiceServer) testEmbeddedByValue()                      {}
+
+// UnsafePlateServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PlateServiceServer will
+// result in compilation errors.
+type UnsafePlateServiceServer interface {
+	mustEmbedUnimplementedPlateServiceServer()
+}
+
+func RegisterPlateServiceServer(s grpc.ServiceRegistrar, srv PlateServiceServer) {
+	// If the following call pancis, it indicates UnimplementedPlateServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PlateService_ServiceDesc, srv)
+}
+
+func _PlateService_LookupPlate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupPlateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlateServiceServer).LookupPlate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PlateService_LookupPlate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlateServiceServer).LookupPlate(ctx, req.(*LookupPlateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PlateService_BatchLookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchLookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlateServiceServer).BatchLookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PlateService_BatchLookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlateServiceServer).BatchLookup(ctx, req.(*BatchLookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PlateService_WatchPlate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPlateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PlateServiceServer).WatchPlate(m, &grpc.GenericServerStream[WatchPlateRequest, WatchPlateEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PlateService_WatchPlateServer = grpc.ServerStreamingServer[WatchPlateEvent]
+
+// PlateService_ServiceDesc is the grpc.ServiceDesc for PlateService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PlateService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kiemtraphatnguoi.v1.PlateService",
+	HandlerType: (*PlateServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LookupPlate",
+			Handler:    _PlateService_LookupPlate_Handler,
+		},
+		{
+			MethodName: "BatchLookup",
+			Handler:    _PlateService_BatchLookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPlate",
+			Handler:       _PlateService_WatchPlate_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "plates.proto",
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ------------------------------------------------------------------------
+// /v1 plate lookups + legacy /checkplate shims
+// ------------------------------------------------------------------------
+
+// lookupMeta describes the lookup that produced a /v1 result.
+type lookupMeta struct {
+	Plate       string `json:"plate"`
+	VehicleType string `json:"vehicle_type"`
+	Count       int    `json:"count"`
+	Total       int    `json:"total"` // matches of the filters, before paging
+	NextCursor  string `json:"next_cursor,omitempty"`
+}
+
+// lookupRequest is the JSON body of POST /v1/lookups and /v1/lookups/csgt.
+type lookupRequest struct {
+	Plate       string `json:"plate"`
+	VehicleType string `json:"vehicle_type"`
+	Captcha     string `json:"captcha,omitempty"`
+}
+
+type plateAPI struct {
+	lookup    func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	fetchCSGT func(ctx context.Context, plate, vehicleCode, captcha string) (interface{}, error)
+}
+
+func newPlateAPI() *plateAPI {
+	return &plateAPI{lookup: lookupViolations, fetchCSGT: fetchDataCSGT}
+}
+
+func (api *plateAPI) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/plates/{plate}/violations", api.getViolations)
+	mux.HandleFunc("POST /v1/lookups", api.postLookup)
+	mux.HandleFunc("POST /v1/lookups/csgt", api.postLookupCSGT)
+
+	// Legacy, form/query-string based routes
+	mux.HandleFunc("/checkplate", api.checkPlateHandler)
+	mux.HandleFunc("/checkplate-csgt", api.checkPlateCSGTHandler)
+}
+
+// parseLookupInput cleans the plate and maps the vehicle type ("oto" when
+// empty) to its csgt.vn code.
+func parseLookupInput(rawPlate, vehicleType string) (plate, vehicleCode string, err error) {
+	if strings.TrimSpace(rawPlate) == "" {
+		return "", "", markError(ErrInvalidPlate, errors.New("Missing or empty parameter: plate"))
+	}
+	vehicleCode, err = vehicleCodeFor(vehicleType)
+	if err != nil {
+		return "", "", err
+	}
+	plate, err = processPlate(rawPlate)
+	if err != nil {
+		return "", "", err
+	}
+	return plate, vehicleCode, nil
+}
+
+func vehicleTypeName(vehicleCode string) string {
+	if vehicleCode == "2" {
+		return "xemay"
+	}
+	return "oto"
+}
+
+// violations runs the lookup chain for already validated input.
+func (api *plateAPI) violations(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, *lookupMeta, error) {
+	data, err := api.lookup(ctx, plate, vehicleCode)
+	if err != nil {
+		log.Printf("Lookup for plate %s failed: %v\n", plate, err)
+		return nil, nil, err
+	}
+	if data == nil {
+		data = []*CsgtData{}
+	}
+	return data, &lookupMeta{Plate: plate, VehicleType: vehicleTypeName(vehicleCode), Count: len(data)}, nil
+}
+
+// getViolations handles GET /v1/plates/{plate}/violations?vehicle_type=...
+// Like every lookup route, it answers with CSV or XLSX when asked to (see
+// negotiateFormat), and takes the filter, sort and paging parameters of
+// violationQuery and parsePage.
+func (api *plateAPI) getViolations(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	listing, err := parseViolationListing(r.URL.Query())
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	plate, vehicleCode, err := parseLookupInput(r.PathValue("plate"), r.URL.Query().Get("vehicle_type"))
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, stored, err := listing.stored(plate, vehicleCode)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	meta := &lookupMeta{Plate: plate, VehicleType: vehicleTypeName(vehicleCode)}
+	if !stored {
+		data, meta, err = api.violations(listing.record(r.Context()), plate, vehicleCode)
+		if err != nil {
+			writeEnvelopeError(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+	writeViolations(w, format, plate, listing.apply(data, meta), meta)
+}
+
+// writeViolations writes a /v1 lookup result in the negotiated format.
+func writeViolations(w http.ResponseWriter, format, plate string, data []*CsgtData, meta *lookupMeta) {
+	if format != formatJSON {
+		writeTable(w, format, exportFilename("violations", plate), violationsTable(data))
+		return
+	}
+	writeEnvelope(w, http.StatusOK, data, meta)
+}
+
+// postLookup handles POST /v1/lookups with {"plate": ..., "vehicle_type": ...}.
+func (api *plateAPI) postLookup(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	listing, err := parseViolationListing(r.URL.Query())
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var req lookupRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	plate, vehicleCode, err := parseLookupInput(req.Plate, req.VehicleType)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, stored, err := listing.stored(plate, vehicleCode)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	meta := &lookupMeta{Plate: plate, VehicleType: vehicleTypeName(vehicleCode)}
+	if !stored {
+		data, meta, err = api.violations(listing.record(r.Context()), plate, vehicleCode)
+		if err != nil {
+			writeEnvelopeError(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+	writeViolations(w, format, plate, listing.apply(data, meta), meta)
+}
+
+// postLookupCSGT handles POST /v1/lookups/csgt, the manual flow where the
+// client solved the csgt.vn captcha itself.
+func (api *plateAPI) postLookupCSGT(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	listing, err := parseViolationListing(r.URL.Query())
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var req lookupRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Later pages come from the history, the captcha is spent by then
+	if listing.page.entryID == "" && strings.TrimSpace(req.Captcha) == "" {
+		writeEnvelopeError(w, http.StatusBadRequest, errors.New("Missing or empty parameter: captcha"))
+		return
+	}
+
+	plate, vehicleCode, err := parseLookupInput(req.Plate, req.VehicleType)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	data, stored, err := listing.stored(plate, vehicleCode)
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !stored {
+		result, err := api.fetchCSGT(r.Context(), plate, vehicleCode, strings.TrimSpace(req.Captcha))
+		if err != nil {
+			writeEnvelopeError(w, http.StatusBadGateway, err)
+			return
+		}
+		data, err = violationsFromResult(result)
+		if err != nil {
+			writeEnvelopeError(w, http.StatusBadGateway, err)
+			return
+		}
+		if data == nil {
+			data = []*CsgtData{}
+		}
+		recordLookup(listing.record(r.Context()), lookupHistory, plate, vehicleCode, sourceCSGT, data)
+	}
+	meta := &lookupMeta{Plate: plate, VehicleType: vehicleTypeName(vehicleCode)}
+	writeViolations(w, format, plate, listing.apply(data, meta), meta)
+}
+
+// checkPlateHandler is the legacy POST /checkplate?bienso=...&loaixe=... route.
+// It answers with the bare result array, as it always has, unless CSV or
+// XLSX is asked for.
+func (api *plateAPI) checkPlateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+		return
+	}
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
+		return
+	}
+
+	if r.FormValue("bienso") == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing or empty parameter: bienso")
+		return
+	}
+
+	plate, vehicleCode, err := parseLookupInput(r.FormValue("bienso"), r.FormValue("loaixe"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, _, err := api.violations(r.Context(), plate, vehicleCode)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if format != formatJSON {
+		writeTable(w, format, exportFilename("violations", plate), violationsTable(data))
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// checkPlateCSGTHandler is the legacy POST /checkplate-csgt route, which takes
+// a captcha solved by the client. Unlike /v1/lookups/csgt, its vehicle_type is
+// the raw csgt.vn code ("1", "2", ...) and is passed through unchanged.
+func (api *plateAPI) checkPlateCSGTHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse form data: "+err.Error())
+		return
+	}
+
+	plate := r.FormValue("bienso")
+	vehicleType := r.FormValue("vehicle_type")
+	captcha := r.FormValue("captcha")
+
+	if plate == "" || vehicleType == "" || captcha == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing bienso, vehicle_type, or captcha")
+		return
+	}
+
+	data, err := api.fetchCSGT(r.Context(), plate, vehicleType, captcha)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEnvelope struct {
+	Data  json.RawMessage `json:"data"`
+	Meta  *lookupMeta     `json:"meta"`
+	Error *apiError       `json:"error"`
+}
+
+func newTestPlateAPI(lookup func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)) *http.ServeMux {
+	api := &plateAPI{
+		lookup: lookup,
+		fetchCSGT: func(ctx context.Context, plate, vehicleCode, captcha string) (interface{}, error) {
+			return []*CsgtData{{Plate: plate, VehicleType: vehicleCode, Status: captcha}}, nil
+		},
+	}
+	mux := http.NewServeMux()
+	api.registerRoutes(mux)
+	return mux
+}
+
+func TestPlates_V1GetAndPost(t *testing.T) {
+	var gotCode string
+	mux := newTestPlateAPI(func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		gotCode = vehicleCode
+		return []*CsgtData{{Plate: plate}}, nil
+	})
+
+	var env testEnvelope
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K-123.45/violations?vehicle_type=xemay", "", &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if gotCode != "2" || env.Meta == nil || *env.Meta != (lookupMeta{Plate: "51K12345", VehicleType: "xemay", Count: 1, Total: 1}) {
+		t.Errorf("Unexpected meta %+v (vehicle code %q)", env.Meta, gotCode)
+	}
+	var data []*CsgtData
+	if err := json.Unmarshal(env.Data, &data); err != nil || len(data) != 1 || data[0].Plate != "51K12345" {
+		t.Errorf("Unexpected data %s", env.Data)
+	}
+
+	env = testEnvelope{}
+	if code := doJSON(t, mux, "POST", "/v1/lookups", `{"plate":"51K12345","vehicle_type":"oto"}`, &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if gotCode != "1" || env.Meta.Count != 1 || env.Error != nil {
+		t.Errorf("Unexpected POST response %+v", env)
+	}
+
+	env = testEnvelope{}
+	if code := doJSON(t, mux, "POST", "/v1/lookups/csgt", `{"plate":"51K12345","vehicle_type":"xemay","captcha":"abc12"}`, &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if !strings.Contains(string(env.Data), `"status": "abc12"`) || env.Meta.VehicleType != "xemay" {
+		t.Errorf("Unexpected csgt response %+v", env)
+	}
+}
+
+func TestPlates_V1Errors(t *testing.T) {
+	mux := newTestPlateAPI(func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		return nil, fmt.Errorf("%w: %w", ErrFallbackFailed, ErrCaptchaRejected)
+	})
+
+	cases := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"GET", "/v1/plates/abc/violations", "", http.StatusBadRequest},
+		{"GET", "/v1/plates/51K12345/violations?vehicle_type=tau", "", http.StatusBadRequest},
+		{"POST", "/v1/lookups", `{"vehicle_type":"oto"}`, http.StatusBadRequest},
+		{"POST", "/v1/lookups", `not json`, http.StatusBadRequest},
+		{"POST", "/v1/lookups/csgt", `{"plate":"51K12345"}`, http.StatusBadRequest},
+		{"GET", "/v1/plates/51K12345/violations", "", http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		var env testEnvelope
+		code := doJSON(t, mux, c.method, c.url, c.body, &env)
+		if code != c.want || env.Error == nil || env.Error.Message == "" || env.Error.Code == "" || string(env.Data) != "null" {
+			t.Errorf("%s %s: expected %d error envelope, got %d %+v", c.method, c.url, c.want, code, env)
+		}
+	}
+}
+
+func TestPlates_LegacyRoutesUnchanged(t *testing.T) {
+	mux := newTestPlateAPI(stubLookup([]*CsgtData{{Plate: "51K12345"}}))
+
+	var data []*CsgtData
+	if code := doJSON(t, mux, "POST", "/checkplate?bienso=51K-123.45&loaixe=oto", "", &data); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(data) != 1 || data[0].Plate != "51K12345" {
+		t.Errorf("Expected bare result array, got %+v", data)
+	}
+
+	if code := doJSON(t, mux, "GET", "/checkplate?bienso=51K12345", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET /checkplate, got %d", code)
+	}
+
+	var legacyErr legacyError
+	if code := doJSON(t, mux, "POST", "/checkplate", "", &legacyErr); code != http.StatusBadRequest || legacyErr.Error != "Missing or empty parameter: bienso" {
+		t.Errorf("Expected legacy error body, got %d %v", code, legacyErr)
+	}
+
+	data = nil
+	if code := doJSON(t, mux, "POST", "/checkplate-csgt?bienso=51K12345&vehicle_type=2&captcha=x", "", &data); code != http.StatusOK || data[0].VehicleType != "2" {
+		t.Errorf("Expected raw csgt code passed through, got %d %+v", code, data)
+	}
+}
+
+func TestPlates_MountV1WrapsLegacyHandlers(t *testing.T) {
+	jobs, _ := newJobManager("", time.Hour, 10)
+	jobsMux := http.NewServeMux()
+	jobs.registerRoutes(jobsMux)
+
+	mux := http.NewServeMux()
+	mountV1(mux, "/jobs", jobsMux)
+	mountV1(mux, "/jobs/", jobsMux)
+
+	req := httptest.NewRequest("POST", "/v1/jobs", strings.NewReader(`{"bienso":"51K12345"}`))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	loc := rec.Header().Get("Location")
+	if !strings.HasPrefix(loc, "/v1/jobs/") {
+		t.Errorf("Expected /v1 Location header, got %q", loc)
+	}
+	var created struct {
+		Data lookupJob `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Data.State != jobQueued {
+		t.Fatalf("Expected enveloped job, got %s", rec.Body.String())
+	}
+
+	var env testEnvelope
+	if code := doJSON(t, mux, "GET", loc, "", &env); code != http.StatusOK || !strings.Contains(string(env.Data), created.Data.ID) {
+		t.Errorf("Expected enveloped GET, got %d %s", code, env.Data)
+	}
+	env = testEnvelope{}
+	if code := doJSON(t, mux, "GET", "/v1/jobs/nope", "", &env); code != http.StatusNotFound || env.Error == nil || env.Error.Message != "Job not found or expired" {
+		t.Errorf("Expected enveloped 404, got %d %+v", code, env)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ------------------------------------------------------------------------
+// Inbound rate limits and daily quotas per client
+// ------------------------------------------------------------------------
+
+var (
+	ErrRateLimited    = errors.New("rate limit exceeded")
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyRequired = errors.New("API key required")
+)
+
+const (
+	tierAnonymous = "anonymous" // clients without an API key, counted per IP
+	tierStandard  = "standard"  // API keys without an explicit tier
+)
+
+// clientTier is the allowance of a class of clients. 0 means unlimited.
+type clientTier struct {
+	PerMinute int
+	PerDay    int // resets at midnight, Vietnam time
+}
+
+var defaultClientTiers = map[string]clientTier{
+	tierAnonymous: {PerMinute: 30, PerDay: 500},
+	tierStandard:  {PerMinute: 120, PerDay: 10000},
+	"unlimited":   {},
+}
+
+// unmeteredPaths are never limited: docs, status pages and health probes.
+// The chat webhooks are authenticated by their secrets and come from the
+// messengers' servers, so their lookups are metered per chat user instead
+// (see chargeChat). Entries ending in "/" are prefixes.
+var unmeteredPaths = []string{"/docs", "/openapi.json", "/healthz", "/readyz", "/metrics", "/telegram/webhook", "/zalo/webhook", "/v1/status/"}
+
+// keyOnlyPaths need an API key: they show how the server is configured.
+var keyOnlyPaths = []string{"/debug/"}
+
+func unmetered(path string) bool {
+	for _, p := range unmeteredPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// counterStore keeps the request counters. Counters are shared by every
+// instance of the server when the store is.
+type counterStore interface {
+	// incr adds n to key, which expires ttl from now, and returns its value.
+	incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// clientLimiter meters every request by API key (X-API-Key or
+// "Authorization: Bearer") or, without one, by client IP.
+type clientLimiter struct {
+	store counterStore
+	tiers map[string]clientTier
+	keys  map[string]string // API key -> tier
+
+	// trustProxy takes the client IP from X-Forwarded-For / X-Real-IP, set
+	// by a reverse proxy in front of the server.
+	trustProxy bool
+	now        func() time.Time
+}
+
+func newClientLimiter(store counterStore, tiers map[string]clientTier, keys map[string]string) *clientLimiter {
+	return &clientLimiter{store: store, tiers: tiers, keys: keys, now: time.Now}
+}
+
+// middleware rejects requests over their client's limits with 429 and adds
+// RateLimit-* headers to the others. A request costs one lookup; handlers
+// that look up more plates charge the rest with chargeLookups. When the
+// store fails, requests go through: the API stays up without its limits.
+func (l *clientLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unmetered(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, tierName, err := l.identify(r.Header, r.RemoteAddr)
+		if err == nil && tierName == tierAnonymous && slices.ContainsFunc(keyOnlyPaths, func(p string) bool { return strings.HasPrefix(r.URL.Path, p) }) {
+			err = ErrAPIKeyRequired
+		}
+		if err != nil {
+			writeRequestError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		d, err := l.take(r.Context(), client, tierName, 1)
+		if err != nil {
+			log.Printf("Rate limit store failed, letting %s through: %v\n", client, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		d.setHeaders(w.Header())
+		if d.err != nil {
+			status, e := apiErrorFor(d.err, http.StatusTooManyRequests)
+			e.RetryAfter = d.resetSeconds()
+			writeRequestAPIError(w, r, status, e)
+			return
+		}
+		ctx := context.WithValue(r.Context(), quotaClientKey{}, &quotaClient{l, client, tierName})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type quotaClientKey struct{}
+
+// quotaClient is the client a request is metered as, for the handlers that
+// charge more lookups to it.
+type quotaClient struct {
+	limiter *clientLimiter
+	client  string
+	tier    string
+}
+
+// chargeLookups charges extra lookups, beyond the one the request paid for,
+// to the request's client: a batch of n plates calls it with n-1. It returns
+// an ErrRateLimited error when that goes over a limit. Requests that were not
+// metered are not charged, and a failing store lets the lookups through like
+// the middleware does.
+func chargeLookups(ctx context.Context, extra int) error {
+	q, ok := ctx.Value(quotaClientKey{}).(*quotaClient)
+	if !ok || extra <= 0 {
+		return nil
+	}
+	d, err := q.limiter.take(ctx, q.client, q.tier, extra)
+	if err != nil {
+		log.Printf("Rate limit store failed, letting %s through: %v\n", q.client, err)
+		return nil
+	}
+	return d.err
+}
+
+// chargeChat charges one lookup to a chat user, with the anonymous tier's
+// limits.
+func (l *clientLimiter) chargeChat(ctx context.Context, channel, recipient string) error {
+	d, err := l.take(ctx, "chat:"+channel+":"+recipient, tierAnonymous, 1)
+	if err != nil {
+		log.Printf("Rate limit store failed, letting %s:%s through: %v\n", channel, recipient, err)
+		return nil
+	}
+	return d.err
+}
+
+// identify returns the counter identity and tier of the client that sent
+// header from remoteAddr; gRPC metadata is passed as a header too. API keys
+// are hashed so they never reach the store.
+func (l *clientLimiter) identify(header http.Header, remoteAddr string) (client, tierName string, err error) {
+	key := strings.TrimSpace(header.Get("X-API-Key"))
+	if auth := header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key == "" {
+		return "ip:" + l.clientIP(header, remoteAddr), tierAnonymous, nil
+	}
+	tierName, ok := l.keys[key]
+	if !ok {
+		return "", "", ErrInvalidAPIKey
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:8]), tierName, nil
+}
+
+func (l *clientLimiter) clientIP(header http.Header, remoteAddr string) string {
+	if l.trustProxy {
+		// The last hop is the one our proxy added; earlier ones are the
+		// client's word
+		if fwd := header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+		if ip := strings.TrimSpace(header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// quotaWindow is one limit of a tier over a fixed window.
+type quotaWindow struct {
+	name     string // "m" or "d", part of the counter key
+	limit    int
+	length   time.Duration
+	start    time.Time
+	end      time.Time
+	describe string
+}
+
+// limitDecision is the outcome of metering one request.
+type limitDecision struct {
+	policies  []quotaWindow
+	limit     int // of the window closest to its limit
+	remaining int
+	reset     time.Duration
+	err       error // ErrRateLimited when the request is over a limit
+}
+
+// take counts n lookups of client in each window of its tier. Refused
+// lookups are taken back out of the windows they were counted in, so they
+// use up neither limit.
+func (l *clientLimiter) take(ctx context.Context, client, tierName string, n int) (*limitDecision, error) {
+	tier := l.tiers[tierName]
+	now := l.now()
+	var windows []quotaWindow
+	if tier.PerMinute > 0 {
+		start := now.Truncate(time.Minute)
+		windows = append(windows, quotaWindow{"m", tier.PerMinute, time.Minute, start, start.Add(time.Minute), "requests per minute"})
+	}
+	if tier.PerDay > 0 {
+		y, m, d := now.In(vietnamLocation).Date()
+		start := time.Date(y, m, d, 0, 0, 0, 0, vietnamLocation)
+		windows = append(windows, quotaWindow{"d", tier.PerDay, 24 * time.Hour, start, start.AddDate(0, 0, 1), "requests per day"})
+	}
+
+	dec := &limitDecision{policies: windows, remaining: -1}
+	var counted []string
+	for _, win := range windows {
+		key := fmt.Sprintf("ratelimit:%s:%s:%d", client, win.name, win.start.Unix())
+		count, err := l.store.incr(ctx, key, int64(n), win.end.Sub(now))
+		if err != nil {
+			return nil, err
+		}
+		counted = append(counted, key)
+		remaining := max(win.limit-int(count), 0)
+		if dec.remaining < 0 || remaining < dec.remaining {
+			dec.limit, dec.remaining, dec.reset = win.limit, remaining, win.end.Sub(now)
+		}
+		if int(count) > win.limit {
+			dec.limit, dec.remaining, dec.reset = win.limit, 0, win.end.Sub(now)
+			dec.err = fmt.Errorf("%w: %d %s (%s tier)", ErrRateLimited, win.limit, win.describe, tierName)
+			break
+		}
+	}
+	if dec.err != nil {
+		for i, key := range counted {
+			if _, err := l.store.incr(ctx, key, -int64(n), windows[i].end.Sub(now)); err != nil {
+				log.Printf("Failed to give back %d refused lookup(s) of %s: %v\n", n, client, err)
+			}
+		}
+	}
+	return dec, nil
+}
+
+func (d *limitDecision) resetSeconds() int {
+	return int((d.reset + time.Second - 1) / time.Second)
+}
+
+// setHeaders adds the RateLimit-* headers of the IETF draft: the window
+// closest to its limit, and every policy of the tier.
+func (d *limitDecision) setHeaders(h http.Header) {
+	if len(d.policies) == 0 {
+		return
+	}
+	policies := make([]string, len(d.policies))
+	for i, win := range d.policies {
+		policies[i] = fmt.Sprintf("%d;w=%d", win.limit, int(win.length/time.Second))
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(d.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(d.resetSeconds()))
+	h.Set("RateLimit-Policy", strings.Join(policies, ", "))
+}
+
+// writeRequestError writes err as an envelope under /v1 and in the
+// unversioned format elsewhere, for code that runs before the routes.
+func writeRequestError(w http.ResponseWriter, r *http.Request, fallbackStatus int, err error) {
+	status, e := apiErrorFor(err, fallbackStatus)
+	writeRequestAPIError(w, r, status, e)
+}
+
+func writeRequestAPIError(w http.ResponseWriter, r *http.Request, status int, e *apiError) {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeEnvelopeAPIError(w, status, e)
+		return
+	}
+	writeLegacyError(w, status, e)
+}
+
+// ---- stores ----
+
+// memoryCounterStore keeps counters in this process.
+type memoryCounterStore struct {
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+	now      func() time.Time
+	writes   int
+}
+
+type memoryCounter struct {
+	n        int64
+	expireAt time.Time
+}
+
+func newMemoryCounterStore() *memoryCounterStore {
+	return &memoryCounterStore{counters: make(map[string]memoryCounter), now: time.Now}
+}
+
+func (s *memoryCounterStore) incr(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expireAt) {
+		c = memoryCounter{expireAt: now.Add(ttl)}
+	}
+	c.n += n
+	s.counters[key] = c
+
+	// Drop expired counters now and then
+	if s.writes++; s.writes%1024 == 0 {
+		for k, c := range s.counters {
+			if !now.Before(c.expireAt) {
+				delete(s.counters, k)
+			}
+		}
+	}
+	return c.n, nil
+}
+
+// redisCounterStore keeps counters in Redis (or anything speaking its
+// protocol), so several instances share the limits.
+type redisCounterStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func newRedisCounterStore(rawURL string) (*redisCounterStore, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return &redisCounterStore{client: redis.NewClient(opts), timeout: 500 * time.Millisecond}, nil
+}
+
+func (s *redisCounterStore) incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+	defer cancel()
+
+	pipe := s.client.TxPipeline()
+	count := pipe.IncrBy(ctx, key, n)
+	pipe.PExpire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("redis: %w", err)
+	}
+	return count.Val(), nil
+}
+
+// ping checks that Redis answers, for /readyz.
+func (s *redisCounterStore) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+// ---- configuration ----
+
+// parseClientTiers reads tiers as "name:per_minute:per_day,..." on top of
+// base, e.g. "anonymous:10:100,partner:600:0" (0 = unlimited).
+func parseClientTiers(raw string, base map[string]clientTier) (map[string]clientTier, error) {
+	tiers := make(map[string]clientTier, len(base))
+	for name, t := range base {
+		tiers[name] = t
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tier %q (expected name:per_minute:per_day)", entry)
+		}
+		perMinute, err1 := strconv.Atoi(parts[1])
+		perDay, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || perMinute < 0 || perDay < 0 {
+			return nil, fmt.Errorf("invalid limits in tier %q", entry)
+		}
+		tiers[parts[0]] = clientTier{PerMinute: perMinute, PerDay: perDay}
+	}
+	return tiers, nil
+}
+
+// parseAPIKeys reads keys as "key[:tier],..."; keys without a tier get
+// tierStandard.
+func parseAPIKeys(raw string, tiers map[string]clientTier) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		key, tierName, ok := strings.Cut(entry, ":")
+		if !ok {
+			tierName = tierStandard
+		}
+		if key == "" {
+			return nil, errors.New("empty API key")
+		}
+		if _, known := tiers[tierName]; !known || tierName == tierAnonymous {
+			return nil, fmt.Errorf("API key %s…: unknown tier %q", key[:min(4, len(key))], tierName)
+		}
+		keys[key] = tierName
+	}
+	return keys, nil
+}
+
+// clientLimiterFromConfig builds the limiter rate_limit.* describes (see
+// appConfig), connecting to Redis if that is the store.
+func clientLimiterFromConfig(cfg rateLimitConfig) (*clientLimiter, error) {
+	tiers, err := parseClientTiers(cfg.Tiers, defaultClientTiers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit tiers: %w", err)
+	}
+	keys, err := parseAPIKeys(cfg.APIKeys, tiers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys: %w", err)
+	}
+
+	var store counterStore
+	switch cfg.Store {
+	case "memory":
+		store = newMemoryCounterStore()
+	case "redis":
+		if store, err = newRedisCounterStore(cfg.RedisURL); err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid rate limit store %q (expected memory or redis)", cfg.Store)
+	}
+
+	l := newClientLimiter(store, tiers, keys)
+	l.trustProxy = cfg.TrustProxy
+	return l, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func quotaTestHandler(store counterStore, now *time.Time) http.Handler {
+	tiers := map[string]clientTier{
+		tierAnonymous: {PerMinute: 2, PerDay: 3},
+		tierStandard:  {PerMinute: 5},
+	}
+	l := newClientLimiter(store, tiers, map[string]string{"k-standard": tierStandard})
+	l.now = func() time.Time { return *now }
+	return l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"ok": "yes"})
+	}))
+}
+
+func quotaRequest(h http.Handler, path, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestQuota_LimitsPerClient(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisStore, err := newRedisCounterStore("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]counterStore{"memory": newMemoryCounterStore(), "redis": redisStore}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2025, 3, 1, 10, 0, 15, 0, vietnamLocation)
+			h := quotaTestHandler(store, &now)
+
+			rec := quotaRequest(h, "/v1/plates/30A12345/violations", "10.0.0.1:5000", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", rec.Code)
+			}
+			for k, want := range map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "45", "RateLimit-Policy": "2;w=60, 3;w=86400"} {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("%s: expected %q, got %q", k, want, got)
+				}
+			}
+			quotaRequest(h, "/checkplate", "10.0.0.1:5001", nil)
+
+			// Third request within the minute, under /v1 and unversioned
+			rec = quotaRequest(h, "/v1/plates/30A12345/violations", "10.0.0.1:5002", nil)
+			var env apiEnvelope
+			json.Unmarshal(rec.Body.Bytes(), &env)
+			if rec.Code != http.StatusTooManyRequests || env.Error == nil || env.Error.Code != codeRateLimited || env.Error.RetryAfter != 45 {
+				t.Fatalf("Expected a rate_limited envelope, got %d %s", rec.Code, rec.Body)
+			}
+			if rec.Header().Get("Retry-After") != "45" || rec.Header().Get("RateLimit-Remaining") != "0" {
+				t.Errorf("Unexpected headers %v", rec.Header())
+			}
+			rec = quotaRequest(h, "/checkplate", "10.0.0.1:5003", nil)
+			var legacy legacyError
+			json.Unmarshal(rec.Body.Bytes(), &legacy)
+			if rec.Code != http.StatusTooManyRequests || legacy.Code != codeRateLimited {
+				t.Fatalf("Expected a legacy rate_limited error, got %d %s", rec.Code, rec.Body)
+			}
+
+			// Other IPs, API keys and unmetered routes are not affected
+			if rec := quotaRequest(h, "/checkplate", "10.0.0.2:5000", nil); rec.Code != http.StatusOK {
+				t.Errorf("Expected another IP to pass, got %d", rec.Code)
+			}
+			key := http.Header{"Authorization": {"Bearer k-standard"}}
+			if rec := quotaRequest(h, "/checkplate", "10.0.0.1:5004", key); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "5" {
+				t.Errorf("Expected the API key's own tier, got %d %v", rec.Code, rec.Header())
+			}
+			if rec := quotaRequest(h, "/v1/status/upstreams", "10.0.0.1:5005", nil); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+				t.Errorf("Expected status routes to be unmetered, got %d", rec.Code)
+			}
+
+			// The next minute is allowed again, until the daily quota
+			now = now.Add(time.Minute)
+			if rec := quotaRequest(h, "/checkplate", "10.0.0.1:5006", nil); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "0" {
+				t.Fatalf("Expected the last request of the day, got %d %v", rec.Code, rec.Header())
+			}
+			rec = quotaRequest(h, "/checkplate", "10.0.0.1:5007", nil)
+			json.Unmarshal(rec.Body.Bytes(), &legacy)
+			if rec.Code != http.StatusTooManyRequests || rec.Header().Get("RateLimit-Limit") != "3" {
+				t.Fatalf("Expected the daily quota to be used up, got %d %v", rec.Code, rec.Header())
+			}
+			if want := int((14*time.Hour - time.Minute - 15*time.Second) / time.Second); legacy.RetryAfter != want {
+				t.Errorf("Expected to retry at midnight (%ds), got %d", want, legacy.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestQuota_InvalidKeyAndStoreFailure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := newRedisCounterStore("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	h := quotaTestHandler(store, &now)
+
+	rec := quotaRequest(h, "/v1/lookups", "10.0.0.1:5000", http.Header{"X-Api-Key": {"nope"}})
+	var env apiEnvelope
+	json.Unmarshal(rec.Body.Bytes(), &env)
+	if rec.Code != http.StatusUnauthorized || env.Error == nil || env.Error.Code != codeUnauthorized {
+		t.Fatalf("Expected 401 for an unknown key, got %d %s", rec.Code, rec.Body)
+	}
+
+	// Without its store the API keeps serving, unlimited
+	mr.Close()
+	for range 5 {
+		if rec := quotaRequest(h, "/checkplate", "10.0.0.1:5000", nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected requests to go through, got %d", rec.Code)
+		}
+	}
+}
+
+func TestQuota_ChargesEveryPlate(t *testing.T) {
+	tiers := map[string]clientTier{tierAnonymous: {PerMinute: 3}, tierStandard: {}}
+	l := newClientLimiter(newMemoryCounterStore(), tiers, map[string]string{"k-standard": tierStandard})
+	mux := http.NewServeMux()
+	batch := newBatchAPI(2)
+	batch.lookup = stubLookup(nil)
+	mux.HandleFunc("/checkplate/batch", batch.checkPlateBatchHandler)
+	gql := newGraphQLAPI(nil, nil, 2)
+	gql.lookup = stubLookup(nil)
+	gql.registerRoutes(mux)
+	registerConfigRoutes(mux, nil)
+	h := l.middleware(mux)
+
+	post := func(path, body, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Three plates use up the minute; a fourth doesn't fit
+	if rec := post("/checkplate/batch", `[{"bienso":"51K12345"},{"bienso":"51K12346"},{"bienso":"51K12347"}]`, "10.0.0.1:1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a batch within the limit to pass, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("/checkplate/batch", `[{"bienso":"51K12345"},{"bienso":"51K12346"}]`, "10.0.0.2:1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if rec := post("/checkplate/batch", `[{"bienso":"51K12345"},{"bienso":"51K12346"}]`, "10.0.0.2:2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the second batch to go over the limit, got %d", rec.Code)
+	}
+
+	// GraphQL aliases are charged per distinct plate
+	var resp graphQLResponse
+	rec := post("/graphql", `{"query":"{ a: plate(plate: \"51K12345\") { violations { plate } } b: plate(plate: \"51K12345\") { violations { plate } } c: plate(plate: \"51K12346\") { violations { plate } } d: plate(plate: \"51K12347\") { violations { plate } } e: plate(plate: \"51K12348\") { violations { plate } } }"}`, "10.0.0.3:1")
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	// Plates are charged as their resolvers run, so any one of them can be
+	// the one over the limit; a and b share theirs
+	aliasPlates := map[string]string{"a": "51K12345", "b": "51K12345", "c": "51K12346", "d": "51K12347", "e": "51K12348"}
+	refused := map[string]bool{}
+	for _, e := range resp.Errors {
+		if e.Extensions["code"] != codeRateLimited || len(e.Path) == 0 {
+			t.Fatalf("Expected only rate limit errors, got %+v", e)
+		}
+		alias, _ := e.Path[0].(string)
+		refused[aliasPlates[alias]] = true
+	}
+	if len(refused) != 1 {
+		t.Errorf("Expected exactly one distinct plate to be rate limited, got %v (%+v)", refused, resp.Errors)
+	}
+
+	// /debug/ needs an API key
+	get := func(header http.Header) int {
+		return quotaRequest(h, "/debug/config", "10.0.0.4:1", header).Code
+	}
+	if code := get(nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for /debug/ without a key, got %d", code)
+	}
+	if code := get(http.Header{"X-Api-Key": {"k-standard"}}); code != http.StatusOK {
+		t.Errorf("Expected 200 for /debug/ with a key, got %d", code)
+	}
+}
+
+func TestQuota_ChatLookups(t *testing.T) {
+	subs, _ := newSubscriptionStore("")
+	c := newChatCommands(telegramChannel, subs)
+	c.lookup = stubLookup(nil)
+	c.limiter = newClientLimiter(newMemoryCounterStore(), map[string]clientTier{tierAnonymous: {PerMinute: 2}}, nil)
+
+	for i := 0; i < 2; i++ {
+		if reply := c.replyTo(context.Background(), "42", "51K12345"); reply == chatRateLimitedText {
+			t.Fatalf("Expected lookup %d to be allowed", i+1)
+		}
+	}
+	if reply := c.replyTo(context.Background(), "42", "/subscribe 51K12345"); reply != chatRateLimitedText {
+		t.Errorf("Expected the third lookup to be refused, got %s", reply)
+	}
+	if reply := c.replyTo(context.Background(), "43", "51K12345"); reply == chatRateLimitedText {
+		t.Error("Expected another chat user to have their own limit")
+	}
+}
+
+func TestQuota_ClientIP(t *testing.T) {
+	l := newClientLimiter(newMemoryCounterStore(), defaultClientTiers, nil)
+	req := httptest.NewRequest(http.MethodGet, "/checkplate", nil)
+	req.RemoteAddr = "10.0.0.9:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7")
+
+	if ip := l.clientIP(req.Header, req.RemoteAddr); ip != "10.0.0.9" {
+		t.Errorf("Expected the peer address without a trusted proxy, got %s", ip)
+	}
+	l.trustProxy = true
+	if ip := l.clientIP(req.Header, req.RemoteAddr); ip != "203.0.113.7" {
+		t.Errorf("Expected the hop added by the proxy, got %s", ip)
+	}
+}
+
+func TestQuota_Config(t *testing.T) {
+	tiers, err := parseClientTiers("anonymous:10:100, partner:600:0", defaultClientTiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tiers[tierAnonymous] != (clientTier{10, 100}) || tiers["partner"] != (clientTier{600, 0}) || tiers[tierStandard] != defaultClientTiers[tierStandard] {
+		t.Errorf("Unexpected tiers %v", tiers)
+	}
+	keys, err := parseAPIKeys("abc, def:partner", tiers)
+	if err != nil || keys["abc"] != tierStandard || keys["def"] != "partner" {
+		t.Errorf("Unexpected keys %v (%v)", keys, err)
+	}
+
+	for _, bad := range []string{"anonymous:10", "x:-1:0", "x:a:1"} {
+		if _, err := parseClientTiers(bad, defaultClientTiers); err == nil {
+			t.Errorf("Expected an error for tier %q", bad)
+		}
+	}
+	for _, bad := range []string{"abc:gold", "abc:anonymous", ":standard"} {
+		if _, err := parseAPIKeys(bad, tiers); err == nil {
+			t.Errorf("Expected an error for keys %q", bad)
+		}
+	}
+	if errorCode(fmt.Errorf("%w: 2 requests per minute", ErrRateLimited), http.StatusBadRequest) != codeRateLimited || errorCode(ErrInvalidAPIKey, http.StatusBadRequest) != codeUnauthorized {
+		t.Error("Unexpected error classes")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ------------------------------------------------------------------------
+// Outbound rate limits toward the lookup sources
+// ------------------------------------------------------------------------
+
+// ErrUpstreamBusy is returned when a request to an upstream could not get a
+// rate-limit token or a connection slot within the allowed wait.
+var ErrUpstreamBusy = errors.New("too many requests queued for the upstream")
+
+// limitConfig is the politeness budget toward one source.
+type limitConfig struct {
+	Rate        float64       // requests per second
+	Burst       int           // requests allowed at once after a quiet period
+	Concurrency int           // requests in flight
+	MaxWait     time.Duration // queueing for a token and a slot, together
+}
+
+var defaultLimits = map[string]limitConfig{
+	sourcePrimary: {Rate: 5, Burst: 10, Concurrency: 8, MaxWait: 10 * time.Second},
+	// A csgt.vn lookup is 3 requests (captcha, form, result page)
+	sourceCSGT: {Rate: 1, Burst: 3, Concurrency: 2, MaxWait: 30 * time.Second},
+}
+
+const (
+	// Each slowdown halves the rate, down to 1/2^maxSlowdowns of it.
+	maxSlowdowns = 4
+	// A slowed-down limiter doubles its rate again after this long without
+	// new trouble.
+	slowdownRecovery = time.Minute
+	// Captcha rejections within captchaWindow that count as a spike.
+	captchaSpike  = 5
+	captchaWindow = time.Minute
+)
+
+// upstreamLimiter throttles the requests to one source: a token bucket for
+// the rate, a semaphore for concurrency. It slows itself down when the source
+// answers 429/403 or rejects captchas in bulk, which is how csgt.vn behaves
+// before blocking an IP.
+type upstreamLimiter struct {
+	source  string
+	cfg     limitConfig
+	limiter *rate.Limiter
+	slots   chan struct{}
+	now     func() time.Time
+
+	mu              sync.Mutex
+	slowdowns       int
+	lastTrouble     time.Time
+	captchaRejects  int
+	captchaWindowAt time.Time
+}
+
+func newUpstreamLimiter(source string, cfg limitConfig) *upstreamLimiter {
+	return &upstreamLimiter{
+		source:  source,
+		cfg:     cfg,
+		limiter: rate.NewLimiter(rate.Limit(cfg.Rate), cfg.Burst),
+		slots:   make(chan struct{}, cfg.Concurrency),
+		now:     time.Now,
+	}
+}
+
+// acquire waits, at most cfg.MaxWait, for a slot and a token. release must
+// be called once the request is over.
+func (l *upstreamLimiter) acquire(ctx context.Context) (release func(), err error) {
+	l.recover()
+
+	waitCtx, cancel := context.WithTimeout(ctx, l.cfg.MaxWait)
+	defer cancel()
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-waitCtx.Done():
+		return nil, l.waitError(ctx)
+	}
+	release = func() { <-l.slots }
+
+	// Wait fails right away when the token would come after the deadline
+	if err := l.limiter.Wait(waitCtx); err != nil {
+		release()
+		return nil, l.waitError(ctx)
+	}
+	return release, nil
+}
+
+func (l *upstreamLimiter) waitError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s: %w (waited up to %s)", l.source, ErrUpstreamBusy, l.cfg.MaxWait)
+}
+
+// observeStatus slows down on 429 Too Many Requests and 403 Forbidden.
+func (l *upstreamLimiter) observeStatus(status int) {
+	if status == http.StatusTooManyRequests || status == http.StatusForbidden {
+		l.slowDown(fmt.Sprintf("status %d", status))
+	}
+}
+
+// observeCaptchaRejected counts a rejected captcha; a spike of them slows
+// down, as OCR is not usually that bad.
+func (l *upstreamLimiter) observeCaptchaRejected() {
+	l.mu.Lock()
+	now := l.now()
+	if now.Sub(l.captchaWindowAt) > captchaWindow {
+		l.captchaWindowAt, l.captchaRejects = now, 0
+	}
+	l.captchaRejects++
+	spike := l.captchaRejects >= captchaSpike
+	if spike {
+		l.captchaRejects = 0
+	}
+	l.mu.Unlock()
+
+	if spike {
+		l.slowDown(fmt.Sprintf("%d captcha rejections within %s", captchaSpike, captchaWindow))
+	}
+}
+
+func (l *upstreamLimiter) slowDown(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastTrouble = l.now()
+	if l.slowdowns == maxSlowdowns {
+		return
+	}
+	l.slowdowns++
+	r := l.currentRateLocked()
+	l.limiter.SetLimitAt(l.now(), rate.Limit(r))
+	log.Printf("Rate limit for %s lowered to %.2f req/s (%s)\n", l.source, r, reason)
+}
+
+// recover undoes one slowdown per slowdownRecovery without trouble.
+func (l *upstreamLimiter) recover() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.slowdowns == 0 || l.now().Sub(l.lastTrouble) < slowdownRecovery {
+		return
+	}
+	l.slowdowns--
+	l.lastTrouble = l.now()
+	r := l.currentRateLocked()
+	l.limiter.SetLimitAt(l.now(), rate.Limit(r))
+	log.Printf("Rate limit for %s raised back to %.2f req/s\n", l.source, r)
+}
+
+func (l *upstreamLimiter) currentRateLocked() float64 {
+	return l.cfg.Rate / float64(int(1)<<l.slowdowns)
+}
+
+// limiterStatus is the rate_limit member of GET /v1/status/upstreams.
+type limiterStatus struct {
+	Rate           float64 `json:"rate"` // current requests per second
+	ConfiguredRate float64 `json:"configured_rate"`
+	Burst          int     `json:"burst"`
+	Concurrency    int     `json:"concurrency"`
+	InFlight       int     `json:"in_flight"`
+	Slowdowns      int     `json:"slowdowns"`
+	MaxWait        string  `json:"max_wait"`
+}
+
+func (l *upstreamLimiter) status() *limiterStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &limiterStatus{
+		Rate:           l.currentRateLocked(),
+		ConfiguredRate: l.cfg.Rate,
+		Burst:          l.cfg.Burst,
+		Concurrency:    l.cfg.Concurrency,
+		InFlight:       len(l.slots),
+		Slowdowns:      l.slowdowns,
+		MaxWait:        l.cfg.MaxWait.String(),
+	}
+}
+
+// ---- transport ----
+
+// limitedTransport puts every request of a source through its limiter. The
+// slot is held until the response body is closed.
+type limitedTransport struct {
+	next    http.RoundTripper
+	limiter *upstreamLimiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.acquire(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	t.limiter.observeStatus(resp.StatusCode)
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_ConcurrencyAndBoundedWait(t *testing.T) {
+	l := newUpstreamLimiter(sourceCSGT, limitConfig{Rate: 1000, Burst: 10, Concurrency: 1, MaxWait: 50 * time.Millisecond})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.acquire(context.Background())
+	if !errors.Is(err, ErrUpstreamBusy) || errorCode(err, http.StatusBadGateway) != codeOverloaded {
+		t.Fatalf("Expected a busy error once the wait is over, got %v", err)
+	}
+	if isUpstreamFailure(err) || defaultRetryPolicies[sourceCSGT].retryable(err) {
+		t.Error("A full queue must neither trip the breaker nor be retried")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the caller's cancellation, got %v", err)
+	}
+
+	release()
+	if release, err = l.acquire(context.Background()); err != nil {
+		t.Fatalf("Expected the freed slot, got %v", err)
+	}
+	release()
+
+	// A token that would come after MaxWait is not waited for
+	slow := newUpstreamLimiter(sourceCSGT, limitConfig{Rate: 0.1, Burst: 1, Concurrency: 5, MaxWait: time.Second})
+	release, _ = slow.acquire(context.Background())
+	release()
+	start := time.Now()
+	if _, err := slow.acquire(context.Background()); !errors.Is(err, ErrUpstreamBusy) {
+		t.Fatalf("Expected a busy error, got %v", err)
+	}
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("Expected to give up right away, waited %s", waited)
+	}
+}
+
+func TestRateLimit_SlowsDownAndRecovers(t *testing.T) {
+	now := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	l := newUpstreamLimiter(sourceCSGT, limitConfig{Rate: 4, Burst: 100, Concurrency: 5, MaxWait: time.Second})
+	l.now = func() time.Time { return now }
+
+	l.observeStatus(http.StatusNotFound)
+	l.observeStatus(http.StatusTooManyRequests)
+	if st := l.status(); st.Rate != 2 || st.Slowdowns != 1 {
+		t.Fatalf("Expected 429 to halve the rate, got %+v", st)
+	}
+	for i := 1; i < captchaSpike; i++ {
+		l.observeCaptchaRejected()
+	}
+	if st := l.status(); st.Rate != 2 {
+		t.Fatalf("Expected a few rejected captchas to be tolerated, got %+v", st)
+	}
+	l.observeCaptchaRejected()
+	if st := l.status(); st.Rate != 1 {
+		t.Fatalf("Expected a captcha spike to halve the rate, got %+v", st)
+	}
+	for range 10 {
+		l.observeStatus(http.StatusForbidden)
+	}
+	if st := l.status(); st.Slowdowns != maxSlowdowns || st.Rate != 4.0/16 {
+		t.Fatalf("Expected the rate to stop at its floor, got %+v", st)
+	}
+
+	// Each quiet period undoes one slowdown, on the next request
+	now = now.Add(slowdownRecovery)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if st := l.status(); st.Slowdowns != maxSlowdowns-1 || st.Rate != 4.0/8 {
+		t.Errorf("Expected one step of recovery, got %+v", st)
+	}
+	l.recover()
+	if st := l.status(); st.Slowdowns != maxSlowdowns-1 {
+		t.Errorf("Expected recovery to wait for another quiet period, got %+v", st)
+	}
+}
+
+func TestRateLimit_TransportHoldsSlotUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	u, err := newUpstreamClients(defaultUpstreamConfig, nil, map[string]limitConfig{
+		sourcePrimary: {Rate: 100, Burst: 10, Concurrency: 2, MaxWait: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Limiter(sourceCSGT) != nil {
+		t.Fatal("Expected only the configured sources to be limited")
+	}
+	l := u.Limiter(sourcePrimary)
+
+	resp, err := u.Client(sourcePrimary).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := l.status(); st.InFlight != 1 || st.Slowdowns != 1 {
+		t.Errorf("Expected one request in flight and a slowdown after 429, got %+v", st)
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if st := l.status(); st.InFlight != 0 {
+		t.Errorf("Expected the slot back once the body is closed, got %+v", st)
+	}
+}
+
+func TestRateLimit_ConcurrentFallbacksDoNotDeadlock(t *testing.T) {
+	var srv *httptest.Server
+	arrived := make(chan struct{}, 2)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`<div id="bodyPrint123"></div>`))
+			return
+		}
+		// Both lookups hold their form slot at the same time
+		arrived <- struct{}{}
+		for len(arrived) < 2 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Write([]byte(`{"success":"true","href":"` + srv.URL + `/result"}`))
+	}))
+	defer srv.Close()
+
+	u, err := newUpstreamClients(defaultUpstreamConfig, nil, map[string]limitConfig{
+		sourceCSGT: {Rate: 1000, Burst: 10, Concurrency: 2, MaxWait: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldUpstream, oldURL := upstream, csgtLookupURL
+	upstream, csgtLookupURL = u, srv.URL
+	defer func() { upstream, csgtLookupURL = oldUpstream, oldURL }()
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := fetchDataCSGTWithSession(context.Background(), "51K12345", "1", "abc", nil)
+			errs <- err
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected both lookups to finish, got %v", err)
+		}
+	}
+	if st := u.Limiter(sourceCSGT).status(); st.InFlight != 0 {
+		t.Errorf("Expected every slot back, got %+v", st)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// ------------------------------------------------------------------------
+// Printable PDF reports, for one plate or a whole fleet
+// ------------------------------------------------------------------------
+
+// DejaVu Sans covers every Vietnamese diacritic; the PDF core fonts don't.
+// See fonts/LICENSE.
+var (
+	//go:embed fonts/DejaVuSans.ttf
+	reportFontRegular []byte
+	//go:embed fonts/DejaVuSans-Bold.ttf
+	reportFontBold []byte
+)
+
+const (
+	defaultReportBrand = "Kiểm Tra Phạt Nguội"
+	reportFont         = "DejaVu"
+	reportTimeLayout   = "15:04 02/01/2006"
+)
+
+// reportSection is the lookup of one plate.
+type reportSection struct {
+	Plate       string
+	VehicleType string // "oto" or "xemay"
+	Driver      string // fleet reports only
+	Source      string // empty when unknown
+	CheckedAt   time.Time
+	Error       string
+	Violations  []*CsgtData
+}
+
+type report struct {
+	Title       string
+	Subtitle    string
+	GeneratedAt time.Time
+	Summary     bool // fleet reports open with a table of every plate
+	Sections    []reportSection
+}
+
+type reportAPI struct {
+	brand   string
+	lookup  func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, string, error)
+	fleet   *fleetStore
+	history *historyStore // resolves the source of stored fleet lookups; may be nil
+}
+
+func newReportAPI(fleet *fleetStore, history *historyStore) *reportAPI {
+	return &reportAPI{brand: defaultReportBrand, lookup: lookupViolationsFrom, fleet: fleet, history: history}
+}
+
+func (api *reportAPI) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/plates/{plate}/report", api.plateReport)
+}
+
+// registerFleetRoutes is separate so the fleet report can also be mounted
+// with the other fleet routes under /v1.
+func (api *reportAPI) registerFleetRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /fleet/organizations/{orgID}/report", api.fleetReport)
+}
+
+// plateReport handles GET /v1/plates/{plate}/report?vehicle_type=... It runs a
+// fresh lookup and answers with a PDF.
+func (api *reportAPI) plateReport(w http.ResponseWriter, r *http.Request) {
+	plate, vehicleCode, err := parseLookupInput(r.PathValue("plate"), r.URL.Query().Get("vehicle_type"))
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, source, err := api.lookup(r.Context(), plate, vehicleCode, nil)
+	if err != nil {
+		log.Printf("Lookup for plate %s failed: %v\n", plate, err)
+		writeEnvelopeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	now := time.Now()
+	api.writePDF(w, exportFilename("report", plate), &report{
+		Title:       "Báo cáo vi phạm giao thông",
+		Subtitle:    "Biển số " + plate,
+		GeneratedAt: now,
+		Sections: []reportSection{{
+			Plate:       plate,
+			VehicleType: vehicleTypeName(vehicleCode),
+			Source:      source,
+			CheckedAt:   now,
+			Violations:  data,
+		}},
+	})
+}
+
+// fleetReport handles GET /fleet/organizations/{orgID}/report from the stored
+// lookups of the vehicles. ?group= and ?only_violations=true filter like the
+// violations view.
+func (api *reportAPI) fleetReport(w http.ResponseWriter, r *http.Request) {
+	org, err := api.fleet.GetOrganization(r.PathValue("orgID"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	vehicles, err := api.fleet.ListVehicles(org.ID, r.URL.Query().Get("group"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rep := &report{Title: "Báo cáo vi phạm đội xe", Subtitle: org.Name, GeneratedAt: time.Now(), Summary: true}
+	for _, v := range vehicles {
+		sec := reportSection{Plate: v.Plate, VehicleType: v.VehicleType, Driver: v.Driver}
+		if v.LastLookup != nil {
+			sec.CheckedAt = v.LastLookup.CheckedAt
+			sec.Error = v.LastLookup.Error
+			sec.Violations = v.LastLookup.Violations
+			if api.history != nil && sec.Error == "" {
+				if e := api.history.At(v.Plate, sec.CheckedAt); e != nil {
+					sec.Source = e.Source
+				}
+			}
+		}
+		if r.URL.Query().Get("only_violations") == "true" && len(sec.Violations) == 0 {
+			continue
+		}
+		rep.Sections = append(rep.Sections, sec)
+	}
+	api.writePDF(w, exportFilename("report", org.ID), rep)
+}
+
+func (api *reportAPI) writePDF(w http.ResponseWriter, filename string, rep *report) {
+	pdf := renderReport(api.brand, rep)
+	if err := pdf.Error(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to render report: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, filename))
+	w.WriteHeader(http.StatusOK)
+	if err := pdf.Output(w); err != nil {
+		log.Printf("Failed to write report: %v\n", err)
+	}
+}
+
+// ---- rendering ----
+
+// Layout of an A4 page, in mm.
+const (
+	reportMargin     = 15.0
+	reportLabelWidth = 42.0
+	reportLineHeight = 5.5
+)
+
+type reportWriter struct {
+	*fpdf.Fpdf
+	width float64 // printable width
+}
+
+// renderReport lays the report out; check Error() before writing it.
+func renderReport(brand string, rep *report) *fpdf.Fpdf {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(reportFont, "", reportFontRegular)
+	pdf.AddUTF8FontFromBytes(reportFont, "B", reportFontBold)
+	pdf.SetMargins(reportMargin, reportMargin, reportMargin)
+	pdf.SetAutoPageBreak(true, reportMargin+5)
+	pdf.SetTitle(rep.Title+" - "+rep.Subtitle, true)
+	pdf.SetAuthor(brand, true)
+	pdf.SetCreationDate(rep.GeneratedAt)
+	pdf.AliasNbPages("{nb}")
+
+	pw, _ := pdf.GetPageSize()
+	rw := &reportWriter{Fpdf: pdf, width: pw - 2*reportMargin}
+
+	pdf.SetHeaderFunc(func() { rw.header(brand, rep) })
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-reportMargin)
+		pdf.SetFont(reportFont, "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(rw.width/2, 5, "Tạo lúc "+rep.GeneratedAt.In(vietnamLocation).Format(reportTimeLayout), "", 0, "L", false, 0, "")
+		pdf.CellFormat(rw.width/2, 5, fmt.Sprintf("Trang %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	pdf.AddPage()
+	if !rep.Summary {
+		for _, sec := range rep.Sections {
+			rw.section(sec)
+		}
+		return pdf
+	}
+
+	// Clean vehicles are only listed in the summary
+	rw.summary(rep.Sections)
+	for _, sec := range rep.Sections {
+		if len(sec.Violations) > 0 || sec.Error != "" {
+			pdf.AddPage()
+			rw.section(sec)
+		}
+	}
+	return pdf
+}
+
+// header prints the brand band and the report title on every page.
+func (rw *reportWriter) header(brand string, rep *report) {
+	rw.SetFillColor(22, 78, 140)
+	rw.Rect(0, 0, reportMargin*2+rw.width, 22, "F")
+	rw.SetTextColor(255, 255, 255)
+	rw.SetXY(reportMargin, 5)
+	rw.SetFont(reportFont, "B", 14)
+	rw.CellFormat(rw.width, 7, brand, "", 1, "L", false, 0, "")
+	rw.SetFont(reportFont, "", 10)
+	rw.CellFormat(rw.width, 6, rep.Title+" - "+rep.Subtitle, "", 1, "L", false, 0, "")
+	rw.SetTextColor(0, 0, 0)
+	rw.SetY(30)
+}
+
+// summary is the table of contents of a fleet report.
+func (rw *reportWriter) summary(sections []reportSection) {
+	var total, unpaid, checked int
+	for _, sec := range sections {
+		total += len(sec.Violations)
+		unpaid += countUnpaid(sec.Violations)
+		if !sec.CheckedAt.IsZero() {
+			checked++
+		}
+	}
+	rw.SetFont(reportFont, "B", 12)
+	rw.CellFormat(rw.width, 7, "Tổng quan", "", 1, "L", false, 0, "")
+	rw.SetFont(reportFont, "", 10)
+	rw.MultiCell(rw.width, reportLineHeight, fmt.Sprintf("%d xe, %d đã tra cứu, %d vi phạm (%d chưa xử phạt).", len(sections), checked, total, unpaid), "", "L", false)
+	rw.Ln(3)
+
+	cols := []struct {
+		title string
+		width float64
+	}{{"Biển số", 28}, {"Tài xế", 50}, {"Tra cứu lúc", 36}, {"Vi phạm", 22}, {"Chưa xử phạt", 0}}
+	cols[len(cols)-1].width = rw.width - 136
+
+	rw.SetFont(reportFont, "B", 9)
+	rw.SetFillColor(230, 236, 245)
+	for _, c := range cols {
+		rw.CellFormat(c.width, 7, c.title, "1", 0, "L", true, 0, "")
+	}
+	rw.Ln(-1)
+	rw.SetFont(reportFont, "", 9)
+	for _, sec := range sections {
+		checkedAt := "chưa tra cứu"
+		if !sec.CheckedAt.IsZero() {
+			checkedAt = sec.CheckedAt.In(vietnamLocation).Format(reportTimeLayout)
+		}
+		violations := fmt.Sprint(len(sec.Violations))
+		if sec.Error != "" {
+			violations = "lỗi"
+		}
+		cells := []string{sec.Plate, sec.Driver, checkedAt, violations, fmt.Sprint(countUnpaid(sec.Violations))}
+		for i, c := range cols {
+			rw.CellFormat(c.width, 6, cells[i], "1", 0, "L", false, 0, "")
+		}
+		rw.Ln(-1)
+	}
+}
+
+// section prints the lookup details of one plate and each of its violations.
+func (rw *reportWriter) section(sec reportSection) {
+	rw.SetFont(reportFont, "B", 13)
+	rw.CellFormat(rw.width, 8, "Biển số "+sec.Plate, "", 1, "L", false, 0, "")
+
+	rw.SetFont(reportFont, "", 10)
+	vehicleType := "Ô tô"
+	if sec.VehicleType == "xemay" {
+		vehicleType = "Xe máy"
+	}
+	rw.field("Loại xe", vehicleType)
+	if sec.Driver != "" {
+		rw.field("Tài xế", sec.Driver)
+	}
+	if sec.CheckedAt.IsZero() {
+		rw.field("Thời điểm tra cứu", "chưa tra cứu")
+	} else {
+		rw.field("Thời điểm tra cứu", sec.CheckedAt.In(vietnamLocation).Format(reportTimeLayout))
+	}
+	source := sec.Source
+	if source == "" {
+		source = "không rõ"
+	}
+	rw.field("Nguồn dữ liệu", source)
+	if sec.Error != "" {
+		rw.SetTextColor(180, 30, 30)
+		rw.field("Lỗi tra cứu", sec.Error)
+		rw.SetTextColor(0, 0, 0)
+		return
+	}
+	rw.field("Số vi phạm", fmt.Sprintf("%d (%d chưa xử phạt)", len(sec.Violations), countUnpaid(sec.Violations)))
+	rw.Ln(3)
+
+	if len(sec.Violations) == 0 {
+		rw.SetFont(reportFont, "B", 11)
+		rw.SetTextColor(30, 120, 60)
+		rw.CellFormat(rw.width, 8, "Không có vi phạm.", "", 1, "L", false, 0, "")
+		rw.SetTextColor(0, 0, 0)
+		return
+	}
+	for i, d := range sec.Violations {
+		rw.violation(i+1, d)
+	}
+}
+
+func (rw *reportWriter) violation(n int, d *CsgtData) {
+	// Keep a violation title from being the last line of a page
+	_, pageHeight := rw.GetPageSize()
+	if rw.GetY() > pageHeight-60 {
+		rw.AddPage()
+	}
+
+	rw.SetFont(reportFont, "B", 11)
+	if isUnpaid(d) {
+		rw.SetFillColor(250, 225, 225)
+	} else {
+		rw.SetFillColor(222, 242, 226)
+	}
+	rw.CellFormat(rw.width, 7, fmt.Sprintf("Vi phạm %d - %s", n, d.Status), "", 1, "L", true, 0, "")
+	rw.Ln(1)
+
+	description, ref := splitViolationAction(d.ViolationAction)
+	rw.SetFont(reportFont, "", 10)
+	rw.field("Thời gian", d.ViolationTime)
+	rw.field("Địa điểm", d.ViolationPlace)
+	rw.field("Hành vi", description)
+	if ref != "" {
+		rw.field("Căn cứ pháp lý", ref)
+	}
+	rw.field("Đơn vị phát hiện", d.DetectedBy)
+	rw.field("Màu biển", d.PlateColor)
+	rw.field("Loại phương tiện", d.VehicleType)
+
+	offices := parseResolutionOffices(d.ResolutionLocation)
+	for i, o := range offices {
+		label := ""
+		if i == 0 {
+			label = "Nơi giải quyết"
+		}
+		text := fmt.Sprintf("%d. %s", i+1, o.Name)
+		if o.Address != "" {
+			text += "\nĐịa chỉ: " + o.Address
+		}
+		if o.Phone != "" {
+			text += "\nĐiện thoại: " + o.Phone
+		}
+		rw.field(label, text)
+	}
+	rw.Ln(4)
+}
+
+// field prints a label and a value that wraps within its column.
+func (rw *reportWriter) field(label, value string) {
+	if value == "" {
+		value = "-"
+	}
+	y := rw.GetY()
+	rw.SetFont(reportFont, "B", 10)
+	rw.SetXY(reportMargin, y)
+	rw.CellFormat(reportLabelWidth, reportLineHeight, label, "", 0, "L", false, 0, "")
+
+	rw.SetFont(reportFont, "", 10)
+	rw.SetLeftMargin(reportMargin + reportLabelWidth)
+	rw.SetXY(reportMargin+reportLabelWidth, y)
+	rw.MultiCell(rw.width-reportLabelWidth, reportLineHeight, value, "", "L", false)
+	rw.SetLeftMargin(reportMargin)
+	rw.SetX(reportMargin)
+}
+
+func countUnpaid(data []*CsgtData) int {
+	n := 0
+	for _, d := range data {
+		if isUnpaid(d) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// pdfText is how the report encodes text with its Unicode font.
+func pdfText(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
+
+func TestSplitViolationAction(t *testing.T) {
+	cases := []struct {
+		action, description, ref string
+	}{
+		{"12321.5.3.a.01.Điều khiển xe chạy quá tốc độ quy định", "Điều khiển xe chạy quá tốc độ quy định", "Điểm a, Khoản 3, Điều 5, Nghị định 123/2021/NĐ-CP"},
+		{"16824.7.9.a.Không chấp hành hiệu lệnh của đèn tín hiệu giao thông", "Không chấp hành hiệu lệnh của đèn tín hiệu giao thông", "Điểm a, Khoản 9, Điều 7, Nghị định 168/2024/NĐ-CP"},
+		{"Dừng, đỗ xe không đúng nơi quy định", "Dừng, đỗ xe không đúng nơi quy định", ""},
+	}
+	for _, c := range cases {
+		description, ref := splitViolationAction(c.action)
+		if description != c.description || ref != c.ref {
+			t.Errorf("%q: got %q / %q", c.action, description, ref)
+		}
+	}
+}
+
+func TestReport_RendersVietnameseWithEmbeddedFont(t *testing.T) {
+	paid := *exportSample[0]
+	paid.Status = "Đã xử phạt"
+	rep := &report{
+		Title:       "Báo cáo vi phạm đội xe",
+		Subtitle:    "Công ty ABC",
+		GeneratedAt: time.Date(2025, 1, 7, 8, 0, 0, 0, time.UTC),
+		Summary:     true,
+		Sections: []reportSection{
+			{Plate: "98E171478", VehicleType: "xemay", Driver: "Nguyễn Văn A", Source: sourceCSGT, CheckedAt: time.Now(), Violations: []*CsgtData{exportSample[0], &paid}},
+			{Plate: "30A99999", VehicleType: "oto", CheckedAt: time.Now(), Violations: []*CsgtData{}},
+			{Plate: "51K12345", VehicleType: "oto", CheckedAt: time.Now(), Error: "csgt.vn: captcha incorrect"},
+		},
+	}
+	pdf := renderReport(defaultReportBrand, rep)
+	pdf.SetCompression(false)
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-")) || !bytes.Contains(out, []byte("/FontFile2")) {
+		t.Fatal("Expected a PDF with an embedded TrueType font")
+	}
+	for _, s := range []string{defaultReportBrand, "Nguyễn Văn A", "Nghị định 123/2021/NĐ-CP", "Đội CSGT", "0911595121", sourceCSGT} {
+		if !bytes.Contains(out, pdfText(s)) {
+			t.Errorf("Expected %q in the report", s)
+		}
+	}
+	// Summary page, then one page per vehicle with violations or an error
+	if pages := len(regexp.MustCompile(`/Type /Page[^s]`).FindAll(out, -1)); pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+}
+
+func TestReport_Routes(t *testing.T) {
+	fleet, _ := newFleetStore("")
+	history, _ := newHistoryStore("", 0)
+	api := newReportAPI(fleet, history)
+	api.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, string, error) {
+		if plate == "51K00002" {
+			return nil, "", markError(ErrUpstreamUnavailable, errors.New("server returned status code: 500"))
+		}
+		return exportSample, sourcePrimary, nil
+	}
+	mux := http.NewServeMux()
+	api.registerRoutes(mux)
+	api.registerFleetRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/plates/98E1-714.78/report?vehicle_type=xemay", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/pdf" || !strings.HasPrefix(rec.Body.String(), "%PDF-") {
+		t.Fatalf("Expected a PDF, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), `filename="report-98E171478.pdf"`) {
+		t.Errorf("Unexpected Content-Disposition %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	var env testEnvelope
+	if code := doJSON(t, mux, "GET", "/v1/plates/51K00002/report", "", &env); code != http.StatusServiceUnavailable || env.Error == nil || env.Error.Code != codeUpstreamUnavailable {
+		t.Errorf("Expected a 503 envelope, got %d %+v", code, env.Error)
+	}
+
+	org, _ := fleet.CreateOrganization("Fleet")
+	v, _ := fleet.CreateVehicle(org.ID, fleetVehicle{Plate: "98E171478", VehicleType: "xemay"})
+	history.Record(v.Plate, "2", sourceCSGT, exportSample)
+	_ = fleet.SetLastLookup(org.ID, v.ID, &vehicleLookup{CheckedAt: time.Now(), Violations: exportSample})
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/fleet/organizations/"+org.ID+"/report", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "%PDF-") {
+		t.Fatalf("Expected a fleet PDF, got %d", rec.Code)
+	}
+	if code := doJSON(t, mux, "GET", "/fleet/organizations/nope/report", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown organization, got %d", code)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Retries with backoff for transient upstream failures
+// ------------------------------------------------------------------------
+
+// retryPolicy says how one source's calls are retried. Waits grow
+// exponentially from BaseDelay up to MaxDelay, with full jitter, and stop
+// when the next wait would end past Budget.
+type retryPolicy struct {
+	MaxAttempts int // 1 disables retries
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Budget      time.Duration // all attempts and waits of one call
+	Statuses    []int         // upstream statuses worth retrying
+}
+
+var defaultRetryPolicies = map[string]retryPolicy{
+	sourcePrimary: {
+		MaxAttempts: 3,
+		BaseDelay:   300 * time.Millisecond,
+		MaxDelay:    3 * time.Second,
+		Budget:      20 * time.Second,
+		Statuses:    []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	},
+	// csgt.vn is slower and every retried round may cost a captcha
+	sourceCSGT: {
+		MaxAttempts: 2,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Second,
+		Budget:      30 * time.Second,
+		Statuses:    []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	},
+}
+
+// retryPolicies is what the lookup chain uses; main applies the retry.*
+// settings to it.
+var retryPolicies = defaultRetryPolicies
+
+// retryMetrics counts, per source, every attempt, every retry, calls that
+// succeeded after retrying ("recovered") and calls that ran out of attempts
+// or budget ("exhausted"). Keys look like "csgt.vn.retries"; they are served
+// on /debug/vars. Everything but the attempts is also counted in
+// upstream_retries_total.
+var retryMetrics = expvar.NewMap("upstream_retries")
+
+// countRetry records an outcome of retrying a call to source: "retried",
+// "recovered" or "exhausted".
+func countRetry(source, outcome string) {
+	key := outcome
+	if outcome == "retried" {
+		key = "retries"
+	}
+	retryMetrics.Add(source+"."+key, 1)
+	upstreamRetries.WithLabelValues(source, outcome).Inc()
+}
+
+// retryable reports whether err is a transient failure under p: a network
+// error or one of p.Statuses. Cancellation, schema changes and "no data"
+// answers are final, and so is a full rate-limit queue.
+func (p retryPolicy) retryable(err error) bool {
+	var statusErr *upstreamStatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrUpstreamBusy):
+		return false
+	case errors.As(err, &statusErr):
+		return slices.Contains(p.Statuses, statusErr.Status)
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// backoff is the wait before retry number n (1-based): a random duration up
+// to BaseDelay*2^(n-1), capped at MaxDelay.
+func (p retryPolicy) backoff(n int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < n && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, p.MaxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// withRetry calls op until it succeeds, fails for good, or the source's
+// policy gives up, and returns op's last error. A Retry-After sent by the
+// upstream is honoured when it fits the budget.
+func withRetry(ctx context.Context, source, what string, op func(ctx context.Context) error) error {
+	p, ok := retryPolicies[source]
+	if !ok || p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	deadline := time.Now().Add(p.Budget)
+
+	for attempt := 1; ; attempt++ {
+		retryMetrics.Add(source+".attempts", 1)
+		err := op(ctx)
+		if err == nil {
+			if attempt > 1 {
+				countRetry(source, "recovered")
+			}
+			return nil
+		}
+		if !p.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			countRetry(source, "exhausted")
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+		if p.Budget > 0 && time.Now().Add(wait).After(deadline) {
+			log.Printf("%s: %s failed, retry budget of %s spent: %v\n", source, what, p.Budget, err)
+			countRetry(source, "exhausted")
+			return err
+		}
+
+		log.Printf("%s: %s failed (attempt %d/%d), retrying in %s: %v\n", source, what, attempt, p.MaxAttempts, wait.Round(time.Millisecond), err)
+		countRetry(source, "retried")
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
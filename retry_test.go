@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testRetryPolicy(t *testing.T, p retryPolicy) {
+	t.Helper()
+	saved := retryPolicies
+	retryPolicies = map[string]retryPolicy{"test": p}
+	t.Cleanup(func() { retryPolicies = saved })
+}
+
+func statusErrorFrom(t *testing.T, status int, retryAfter string) error {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if retryAfter != "" {
+		rec.Header().Set("Retry-After", retryAfter)
+	}
+	rec.WriteHeader(status)
+	return newUpstreamStatusError(rec.Result(), "server returned status code: %d")
+}
+
+func TestRetry_Retryable(t *testing.T) {
+	p := defaultRetryPolicies[sourcePrimary]
+	reset := fmt.Errorf("connection error: %w", &url.Error{Op: "Post", URL: "x", Err: syscall.ECONNRESET})
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection reset", reset, true},
+		{"503", statusErrorFrom(t, http.StatusServiceUnavailable, ""), true},
+		{"429", statusErrorFrom(t, http.StatusTooManyRequests, "1"), true},
+		{"404", statusErrorFrom(t, http.StatusNotFound, ""), false},
+		{"no data", ErrDataNotFound, false},
+		{"schema", markError(ErrUpstreamSchema, errors.New("bad JSON")), false},
+		{"canceled", fmt.Errorf("connection error: %w", &url.Error{Op: "Post", URL: "x", Err: context.Canceled}), false},
+	}
+	for _, c := range cases {
+		if got := p.retryable(c.err); got != c.want {
+			t.Errorf("%s: expected retryable=%v", c.name, c.want)
+		}
+	}
+
+	if err := statusErrorFrom(t, http.StatusServiceUnavailable, ""); !errors.Is(err, ErrUpstreamUnavailable) || err.Error() != "server returned status code: 503" {
+		t.Errorf("Status errors must keep their class and wording, got %v", err)
+	}
+}
+
+func TestRetry_RecoversFromTransientFailures(t *testing.T) {
+	testRetryPolicy(t, retryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Budget: time.Second, Statuses: []int{503}})
+
+	calls := 0
+	err := withRetry(context.Background(), "test", "lookup", func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return statusErrorFrom(t, http.StatusServiceUnavailable, "")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Expected success on the 3rd attempt, got %v after %d calls", err, calls)
+	}
+	if v := retryMetrics.Get("test.recovered"); v == nil || v.String() != "1" {
+		t.Errorf("Expected 1 recovered call in the metrics, got %v", v)
+	}
+
+	calls = 0
+	err = withRetry(context.Background(), "test", "lookup", func(ctx context.Context) error {
+		calls++
+		return ErrDataNotFound
+	})
+	if !errors.Is(err, ErrDataNotFound) || calls != 1 {
+		t.Errorf("Expected no retry for a final error, got %d calls", calls)
+	}
+}
+
+func TestRetry_GivesUp(t *testing.T) {
+	testRetryPolicy(t, retryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: time.Second, Statuses: []int{429, 503}})
+
+	// Retry-After beyond the budget ends the call right away
+	calls := 0
+	err := withRetry(context.Background(), "test", "lookup", func(ctx context.Context) error {
+		calls++
+		return statusErrorFrom(t, http.StatusTooManyRequests, "120")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected to give up after 1 call, got %d", calls)
+	}
+
+	calls = 0
+	_ = withRetry(context.Background(), "test", "lookup", func(ctx context.Context) error {
+		calls++
+		return statusErrorFrom(t, http.StatusServiceUnavailable, "")
+	})
+	if calls != 5 {
+		t.Errorf("Expected MaxAttempts calls, got %d", calls)
+	}
+
+	// Cancelling stops the wait between attempts
+	testRetryPolicy(t, retryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour, Statuses: []int{503}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	calls = 0
+	_ = withRetry(ctx, "test", "lookup", func(ctx context.Context) error {
+		calls++
+		return statusErrorFrom(t, http.StatusServiceUnavailable, "")
+	})
+	if calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected the wait to end with the context, got %d calls in %s", calls, time.Since(start))
+	}
+}
+
+func TestRetry_BackoffBounds(t *testing.T) {
+	p := retryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for n := 1; n <= 40; n++ {
+		ceiling := min(p.BaseDelay<<(min(n, 30)-1), p.MaxDelay)
+		if d := p.backoff(n); d < 0 || d > ceiling {
+			t.Errorf("backoff(%d) = %s, expected at most %s", n, d, ceiling)
+		}
+	}
+}
+
+func TestRetry_CSGTRoundStartsOver(t *testing.T) {
+	var captchas, lookups []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/captcha":
+			captcha := fmt.Sprintf("C%d", len(captchas)+1)
+			captchas = append(captchas, captcha)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: captcha})
+			w.Write([]byte(captcha))
+		case r.URL.Path == "/lookup":
+			r.ParseForm()
+			session, _ := r.Cookie("session")
+			if session == nil || session.Value != r.FormValue("captcha") {
+				t.Errorf("Captcha %q sent with session %v", r.FormValue("captcha"), session)
+			}
+			lookups = append(lookups, r.FormValue("captcha"))
+			if len(lookups) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"success":"true","href":"` + srv.URL + `/result"}`))
+		default:
+			w.Write([]byte(`<div id="bodyPrint123"></div>`))
+		}
+	}))
+	defer srv.Close()
+
+	oldCaptcha, oldLookup, oldPolicies := csgtCaptchaURL, csgtLookupURL, retryPolicies
+	csgtCaptchaURL, csgtLookupURL = srv.URL+"/captcha", srv.URL+"/lookup"
+	retryPolicies = map[string]retryPolicy{sourceCSGT: {MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: time.Second, Statuses: []int{503}}}
+	oldOCR := currentOCRPool.Load()
+	ocr := newOCRPool(ocrConfig{Workers: 1, Queue: 2, Timeout: time.Second}, (&fakeOCR{}).newEngine)
+	currentOCRPool.Store(ocr)
+	defer func() {
+		csgtCaptchaURL, csgtLookupURL, retryPolicies = oldCaptcha, oldLookup, oldPolicies
+		currentOCRPool.Store(oldOCR)
+		ocr.Close()
+	}()
+
+	retried := counterValue(t, upstreamRetries, sourceCSGT, "retried")
+	if _, err := fallbackToCSGTWithProgress(context.Background(), "51K12345", "1", nil); err != nil {
+		t.Fatalf("Expected the retried round to succeed, got %v", err)
+	}
+	if len(captchas) != 2 || len(lookups) != 2 || lookups[1] != "C2" {
+		t.Errorf("Expected the lookup to be retried with a fresh captcha, got captchas %v and lookups %v", captchas, lookups)
+	}
+	if d := counterValue(t, upstreamRetries, sourceCSGT, "retried") - retried; d != 1 {
+		t.Errorf("Expected 1 retry in upstream_retries_total, got %v", d)
+	}
+}
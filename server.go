@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// HTTP server lifecycle: timeouts, TLS and graceful shutdown
+// ------------------------------------------------------------------------
+
+// serverConfig tunes the HTTP server. WriteTimeout bounds a whole response,
+// so it must leave room for the slowest lookups, batches and streams.
+type serverConfig struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// ShutdownTimeout is how long in-flight requests and jobs may take to
+	// finish after SIGINT/SIGTERM before they are cut off.
+	ShutdownTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile enable HTTPS. The files are reloaded when
+	// they change, and on SIGHUP.
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+var defaultServerConfig = serverConfig{
+	Addr:              ":8080",
+	ReadHeaderTimeout: 10 * time.Second,
+	ReadTimeout:       30 * time.Second,
+	WriteTimeout:      5 * time.Minute,
+	IdleTimeout:       2 * time.Minute,
+	MaxHeaderBytes:    1 << 20,
+	ShutdownTimeout:   30 * time.Second,
+}
+
+func newHTTPServer(cfg serverConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// ---- TLS ----
+
+// certReloader serves the certificate in certFile/keyFile, loading it again
+// when either file changes (checked at most every certCheckInterval) or on
+// reload. A certificate that fails to load leaves the previous one in use.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+const certCheckInterval = 10 * time.Second
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	modTime := c.latestModTime()
+
+	c.mu.Lock()
+	c.cert, c.modTime, c.checked = &cert, modTime, time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate is the tls.Config hook.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	cert, modTime, stale := c.cert, c.modTime, time.Since(c.checked) >= certCheckInterval
+	c.mu.RUnlock()
+	if !stale {
+		return cert, nil
+	}
+
+	c.mu.Lock()
+	c.checked = time.Now()
+	c.mu.Unlock()
+	if c.latestModTime().After(modTime) {
+		if err := c.reload(); err != nil {
+			log.Printf("Keeping the current TLS certificate: %v\n", err)
+		} else {
+			log.Printf("Reloaded TLS certificate from %s\n", c.certFile)
+		}
+		c.mu.RLock()
+		cert = c.cert
+		c.mu.RUnlock()
+	}
+	return cert, nil
+}
+
+// ---- run and shut down ----
+
+// runServer serves srv until SIGINT or SIGTERM, then stops taking requests
+// and gives in-flight ones, and whatever drain runs (jobs, gRPC), up to
+// cfg.ShutdownTimeout to finish. flush runs once all of them have returned,
+// to save what they left behind (e.g. lookup history). It returns once
+// everything has stopped.
+func runServer(cfg serverConfig, srv *http.Server, drain []func(context.Context) error, flush ...func(context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	if cfg.TLSCertFile != "" {
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+		go reloadCertsOnSIGHUP(ctx, certs)
+		fmt.Printf("Starting server on %s (TLS)...\n", cfg.Addr)
+		go func() { serveErr <- srv.ListenAndServeTLS("", "") }()
+	} else {
+		fmt.Printf("Starting server on %s...\n", cfg.Addr)
+		go func() { serveErr <- srv.ListenAndServe() }()
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+	log.Printf("Shutting down, waiting up to %s for requests and jobs to finish\n", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(drain)+1)
+	for i, fn := range append([]func(context.Context) error{srv.Shutdown}, drain...) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(shutdownCtx)
+		}()
+	}
+	wg.Wait()
+	for _, fn := range flush {
+		errs = append(errs, fn(shutdownCtx))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown incomplete: %w", err)
+	}
+	log.Printf("Server stopped\n")
+	return nil
+}
+
+func reloadCertsOnSIGHUP(ctx context.Context, certs *certReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := certs.reload(); err != nil {
+				log.Printf("SIGHUP: keeping the current TLS certificate: %v\n", err)
+			} else {
+				log.Printf("SIGHUP: reloaded TLS certificate from %s\n", certs.certFile)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// serveUntilSignal runs runServer on h, and sends SIGTERM once a request
+// has reached the handler.
+func serveUntilSignal(t *testing.T, cfg serverConfig, h http.Handler, drain []func(context.Context) error, flush ...func(context.Context) error) (entered chan struct{}, result chan error) {
+	t.Helper()
+	entered = make(chan struct{}, 1)
+	wrapped := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		h.ServeHTTP(w, r)
+	})
+	result = make(chan error, 1)
+	go func() { result <- runServer(cfg, newHTTPServer(cfg, wrapped), drain, flush...) }()
+	return entered, result
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	cfg := defaultServerConfig
+	cfg.Addr = freeAddr(t)
+	cfg.ShutdownTimeout = 5 * time.Second
+
+	release := make(chan struct{})
+	drained := make(chan struct{})
+	entered, result := serveUntilSignal(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("done"))
+	}), []func(context.Context) error{func(ctx context.Context) error {
+		close(drained)
+		return nil
+	}})
+
+	// A lookup is in flight when SIGTERM arrives
+	resp := make(chan *http.Response, 1)
+	go func() {
+		for {
+			r, err := http.Get("http://" + cfg.Addr)
+			if err == nil {
+				resp <- r
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	<-entered
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	<-drained
+
+	select {
+	case err := <-result:
+		t.Fatalf("Expected the server to wait for the request, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", cfg.Addr, time.Second); err == nil {
+		t.Error("Expected new connections to be refused while draining")
+	}
+
+	close(release)
+	r := <-resp
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got %d", r.StatusCode)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	cfg := defaultServerConfig
+	cfg.Addr = freeAddr(t)
+	cfg.ShutdownTimeout = 50 * time.Millisecond
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	entered, result := serveUntilSignal(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stuck
+	}), []func(context.Context) error{func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	go func() {
+		for {
+			if r, err := http.Get("http://" + cfg.Addr); err == nil {
+				r.Body.Close()
+				return
+			} else if errors.Is(err, syscall.ECONNREFUSED) {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return
+		}
+	}()
+	<-entered
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the shutdown deadline, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the server to give up after ShutdownTimeout")
+	}
+}
+
+func TestServer_FlushesHistoryAfterDrain(t *testing.T) {
+	cfg := defaultServerConfig
+	cfg.Addr = freeAddr(t)
+	cfg.ShutdownTimeout = 5 * time.Second
+	historySaveDelay = time.Hour
+	t.Cleanup(func() { historySaveDelay = 2 * time.Second })
+	path := filepath.Join(t.TempDir(), "history.json")
+	store, err := newHistoryStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lookup finishes only after SIGTERM, while the server drains
+	draining := make(chan struct{})
+	entered, result := serveUntilSignal(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-draining
+		time.Sleep(50 * time.Millisecond)
+		store.Record("51K12345", "1", sourcePrimary, nil)
+	}), []func(context.Context) error{func(context.Context) error {
+		close(draining)
+		return nil
+	}}, store.Flush)
+	go func() {
+		for {
+			if r, err := http.Get("http://" + cfg.Addr); err == nil {
+				r.Body.Close()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	<-entered
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err := <-result; err != nil {
+		t.Fatalf("Expected a clean shutdown, got %v", err)
+	}
+
+	reloaded, err := newHistoryStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.List("51K12345", 0); len(got) != 1 {
+		t.Errorf("Expected the lookup recorded during the drain on disk, got %d entries", len(got))
+	}
+}
+
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestServer_CertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "old.example")
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commonName := func() string {
+		t.Helper()
+		cert, err := c.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+
+	// A renewed certificate is picked up on the next check
+	writeTestCert(t, dir, "new.example")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if cn := commonName(); cn != "old.example" {
+		t.Errorf("Expected no check within certCheckInterval, got %s", cn)
+	}
+	c.checked = time.Now().Add(-certCheckInterval)
+	if cn := commonName(); cn != "new.example" {
+		t.Errorf("Expected the renewed certificate, got %s", cn)
+	}
+
+	// A broken file keeps the certificate in use
+	os.WriteFile(certFile, []byte("garbage"), 0o600)
+	if err := c.reload(); err == nil {
+		t.Error("Expected a reload error")
+	}
+	if cn := commonName(); cn != "new.example" {
+		t.Errorf("Expected the previous certificate to stay, got %s", cn)
+	}
+}
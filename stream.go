@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Server-Sent Events stream of lookup progress
+// ------------------------------------------------------------------------
+
+// sseHeartbeat keeps idle proxies from closing the stream while a captcha is
+// being solved.
+const sseHeartbeat = 15 * time.Second
+
+type streamAPI struct {
+	lookup func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error)
+}
+
+func newStreamAPI() *streamAPI {
+	return &streamAPI{lookup: lookupViolationsWithProgress}
+}
+
+// checkPlateStreamHandler handles GET /checkplate/stream?bienso=...&loaixe=...
+// It streams one "stage" event per lookup stage, then a final "result" event
+// with the violations or an "error" event, and closes the stream.
+//
+//	event: stage
+//	data: {"stage":"ocr_guess","attempt":1,"message":"X7K2P"}
+func (api *streamAPI) checkPlateStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+
+	plate := r.FormValue("bienso")
+	if plate == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing or empty parameter: bienso")
+		return
+	}
+	plate, vehicleCode, err := parseLookupInput(plate, r.FormValue("loaixe"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	api.stream(w, r, plate, vehicleCode)
+}
+
+// getViolationsStream handles GET /v1/plates/{plate}/violations/stream and
+// emits the same events as /checkplate/stream.
+func (api *streamAPI) getViolationsStream(w http.ResponseWriter, r *http.Request) {
+	plate, vehicleCode, err := parseLookupInput(r.PathValue("plate"), r.URL.Query().Get("vehicle_type"))
+	if err != nil {
+		writeEnvelopeError(w, http.StatusBadRequest, err)
+		return
+	}
+	api.stream(w, r, plate, vehicleCode)
+}
+
+// stream runs the lookup for validated input and writes its events.
+func (api *streamAPI) stream(w http.ResponseWriter, r *http.Request, plate, vehicleCode string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	type outcome struct {
+		data []*CsgtData
+		err  error
+	}
+	events := make(chan lookupEvent, 32)
+	done := make(chan outcome, 1)
+
+	go func() {
+		// Never block the lookup on a slow or departed client
+		progress := func(ev lookupEvent) {
+			select {
+			case events <- ev:
+			default:
+			}
+		}
+		data, err := api.lookup(r.Context(), plate, vehicleCode, progress)
+		done <- outcome{data, err}
+	}()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			writeSSE(w, "stage", ev)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case res := <-done:
+			// Drain stages reported just before the lookup returned
+			for drained := false; !drained; {
+				select {
+				case ev := <-events:
+					writeSSE(w, "stage", ev)
+				default:
+					drained = true
+				}
+			}
+
+			if res.err != nil {
+				_, e := apiErrorFor(res.err, http.StatusBadGateway)
+				writeSSE(w, "error", legacyError{Error: e.Message, Code: e.Code, Retryable: e.Retryable, RetryAfter: e.RetryAfter})
+			} else {
+				if res.data == nil {
+					res.data = []*CsgtData{}
+				}
+				writeSSE(w, "result", res.data)
+			}
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload = []byte(`{"error": "Failed to encode JSON"}`)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readSSE(t *testing.T, url string) (*http.Response, []sseEvent) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	var events []sseEvent
+	var cur sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			cur.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			cur.data = strings.TrimPrefix(line, "data: ")
+		case line == "" && cur.name != "":
+			events = append(events, cur)
+			cur = sseEvent{}
+		}
+	}
+	return resp, events
+}
+
+func TestStream_StagesThenResult(t *testing.T) {
+	api := newStreamAPI()
+	api.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		progress.report(stageQueryingPrimary, "")
+		progress.report(stagePrimaryEmpty, "")
+		progress.reportAttempt(stageCaptchaFetched, 1, "")
+		progress.reportAttempt(stageOCRGuess, 1, "X7K2P")
+		progress.reportAttempt(stageCaptchaRejected, 1, "rejected")
+		progress.reportAttempt(stageOCRGuess, 2, "X7K2R")
+		progress.report(stageResultsParsed, "1 violation(s)")
+		return []*CsgtData{{Plate: plate, VehicleType: vehicleCode}}, nil
+	}
+	server := httptest.NewServer(http.HandlerFunc(api.checkPlateStreamHandler))
+	defer server.Close()
+
+	resp, events := readSSE(t, server.URL+"?bienso=98E1-714.78&loaixe=xemay")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", ct)
+	}
+	if len(events) != 8 {
+		t.Fatalf("Expected 7 stages and a result, got %d: %+v", len(events), events)
+	}
+	if events[3].name != "stage" || events[3].data != `{"stage":"ocr_guess","attempt":1,"message":"X7K2P"}` {
+		t.Errorf("Unexpected OCR event: %+v", events[3])
+	}
+	if events[4].data != `{"stage":"captcha_rejected","attempt":1,"message":"rejected"}` {
+		t.Errorf("Unexpected rejection event: %+v", events[4])
+	}
+	last := events[len(events)-1]
+	if last.name != "result" || !strings.Contains(last.data, `"plate":"98E171478"`) || !strings.Contains(last.data, `"vehicle_type":"2"`) {
+		t.Errorf("Unexpected final event: %+v", last)
+	}
+}
+
+func TestStream_Error(t *testing.T) {
+	api := newStreamAPI()
+	api.lookup = func(ctx context.Context, plate, vehicleCode string, progress lookupProgress) ([]*CsgtData, error) {
+		return nil, errors.New("fallback also failed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(api.checkPlateStreamHandler))
+	defer server.Close()
+
+	_, events := readSSE(t, server.URL+"?bienso=51K12345")
+	if len(events) != 1 || events[0].name != "error" || !strings.Contains(events[0].data, "fallback also failed") {
+		t.Errorf("Expected a single error event, got %+v", events)
+	}
+}
+
+func TestStream_Validation(t *testing.T) {
+	api := newStreamAPI()
+	for _, query := range []string{"", "?bienso=abc", "?bienso=51K12345&loaixe=tau"} {
+		rec := httptest.NewRecorder()
+		api.checkPlateStreamHandler(rec, httptest.NewRequest("GET", "/checkplate/stream"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Plate subscriptions + change notifications
+// ------------------------------------------------------------------------
+
+// subscription is one recipient on one channel (e.g. a Telegram chat) that
+// wants to be told when the violations for a plate change.
+type subscription struct {
+	Channel     string    `json:"channel"`
+	Recipient   string    `json:"recipient"`
+	Plate       string    `json:"plate"`
+	VehicleCode string    `json:"vehicle_code"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// subscriptionStore keeps subscriptions in memory and mirrors them to a JSON
+// file so they survive restarts. An empty path keeps them in memory only.
+type subscriptionStore struct {
+	mu   sync.Mutex
+	path string
+	subs []*subscription
+}
+
+func newSubscriptionStore(path string) (*subscriptionStore, error) {
+	s := &subscriptionStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions %q: %w", path, err)
+	}
+	if err := json.Unmarshal(raw, &s.subs); err != nil {
+		return nil, fmt.Errorf("failed to parse subscriptions %q: %w", path, err)
+	}
+	return s, nil
+}
+
+// Subscribe adds (or refreshes) a subscription. The fingerprint is the state
+// the recipient has already seen, so they are only notified about changes.
+func (s *subscriptionStore) Subscribe(channel, recipient, plate, vehicleCode, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.Channel == channel && sub.Recipient == recipient && sub.Plate == plate {
+			sub.VehicleCode = vehicleCode
+			sub.Fingerprint = fingerprint
+			return s.saveLocked()
+		}
+	}
+
+	s.subs = append(s.subs, &subscription{
+		Channel:     channel,
+		Recipient:   recipient,
+		Plate:       plate,
+		VehicleCode: vehicleCode,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+	})
+	return s.saveLocked()
+}
+
+// Unsubscribe removes a subscription and reports whether one existed.
+func (s *subscriptionStore) Unsubscribe(channel, recipient, plate string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, sub := range s.subs {
+		if sub.Channel == channel && sub.Recipient == recipient && sub.Plate == plate {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			return true, s.saveLocked()
+		}
+	}
+	return false, nil
+}
+
+// List returns copies of the subscriptions for one recipient.
+func (s *subscriptionStore) List(channel, recipient string) []subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []subscription
+	for _, sub := range s.subs {
+		if sub.Channel == channel && sub.Recipient == recipient {
+			out = append(out, *sub)
+		}
+	}
+	return out
+}
+
+// All returns copies of every subscription.
+func (s *subscriptionStore) All() []subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		out = append(out, *sub)
+	}
+	return out
+}
+
+// setFingerprint records the state a recipient has been notified about.
+func (s *subscriptionStore) setFingerprint(channel, recipient, plate, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.Channel == channel && sub.Recipient == recipient && sub.Plate == plate {
+			sub.Fingerprint = fingerprint
+			return s.saveLocked()
+		}
+	}
+	return nil
+}
+
+func (s *subscriptionStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.subs)
+}
+
+// violationsFingerprint identifies a set of violations so the watcher can tell
+// whether anything changed since the last notification. Only what identifies
+// a violation and its status counts, in any order: the sources word places,
+// offices and plates differently, and list violations in different orders.
+func violationsFingerprint(data []*CsgtData) string {
+	keys := make([]string, 0, len(data))
+	for _, d := range data {
+		keys = append(keys, violationKey(d))
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// violationKey is a violation's time, action and status, with case and
+// spacing normalized.
+func violationKey(d *CsgtData) string {
+	norm := func(s string) string { return strings.ToLower(strings.Join(strings.Fields(s), " ")) }
+	return norm(d.ViolationTime) + "|" + norm(d.ViolationAction) + "|" + norm(d.Status)
+}
+
+// notifyFunc delivers a change notification to one recipient on a channel.
+type notifyFunc func(ctx context.Context, recipient, plate string, data []*CsgtData) error
+
+// plateWatcher periodically re-checks every subscribed plate and notifies
+// subscribers whose last seen state differs from the current one.
+type plateWatcher struct {
+	store     *subscriptionStore
+	lookup    func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error)
+	interval  time.Duration
+	mu        sync.Mutex
+	notifiers map[string]notifyFunc
+}
+
+func newPlateWatcher(store *subscriptionStore, interval time.Duration) *plateWatcher {
+	return &plateWatcher{
+		store:     store,
+		lookup:    lookupViolations,
+		interval:  interval,
+		notifiers: make(map[string]notifyFunc),
+	}
+}
+
+// RegisterNotifier sets how notifications for a channel are delivered.
+func (pw *plateWatcher) RegisterNotifier(channel string, fn notifyFunc) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.notifiers[channel] = fn
+}
+
+// Run checks all plates every interval until stop is closed, which also
+// aborts a check in progress.
+func (pw *plateWatcher) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			pw.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll looks up each distinct plate once and fans the result out to its
+// subscribers. It stops early when ctx is done. Whichever source answers,
+// the violations are compared by violationsFingerprint.
+func (pw *plateWatcher) CheckAll(ctx context.Context) {
+	type plateKey struct{ plate, vehicleCode string }
+
+	byPlate := make(map[plateKey][]subscription)
+	var order []plateKey
+	for _, sub := range pw.store.All() {
+		k := plateKey{sub.Plate, sub.VehicleCode}
+		if _, ok := byPlate[k]; !ok {
+			order = append(order, k)
+		}
+		byPlate[k] = append(byPlate[k], sub)
+	}
+
+	for _, k := range order {
+		data, err := pw.lookup(ctx, k.plate, k.vehicleCode)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Watcher: lookup for plate %s failed: %v\n", k.plate, err)
+			continue
+		}
+		fingerprint := violationsFingerprint(data)
+
+		for _, sub := range byPlate[k] {
+			if sub.Fingerprint == fingerprint {
+				continue
+			}
+
+			pw.mu.Lock()
+			notify := pw.notifiers[sub.Channel]
+			pw.mu.Unlock()
+			if notify == nil {
+				continue
+			}
+
+			if err := notify(ctx, sub.Recipient, sub.Plate, data); err != nil {
+				log.Printf("Watcher: notify %s:%s about %s failed: %v\n", sub.Channel, sub.Recipient, sub.Plate, err)
+				continue
+			}
+			if err := pw.store.setFingerprint(sub.Channel, sub.Recipient, sub.Plate, fingerprint); err != nil {
+				log.Printf("Watcher: failed to save subscription state: %v\n", err)
+			}
+		}
+	}
+}
+
+// ------------------------------------------------------------------------
+// Wiring
+// ------------------------------------------------------------------------
+
+// startChatBots starts the configured chat front-ends (telegram.*, zalo.* in
+// appConfig) and, if any is enabled, the watcher that notifies their
+// subscribers. Lookups asked for in chats are metered per user by clients.
+// Polling and the watcher run until stop is closed.
+func startChatBots(cfg chatConfig, clients *clientLimiter, stop <-chan struct{}) {
+	telegramToken := cfg.TelegramToken
+	zaloToken := cfg.ZaloToken
+	if telegramToken == "" && zaloToken == "" {
+		return
+	}
+
+	subs, err := newSubscriptionStore(cfg.SubscriptionsFile)
+	if err != nil {
+		log.Fatal("Failed to load subscriptions:", err)
+	}
+
+	watcher := newPlateWatcher(subs, cfg.WatchInterval)
+
+	if telegramToken != "" {
+		bot := newTelegramBot(telegramToken, cfg.TelegramAPIURL, subs)
+		bot.webhookSecret = cfg.TelegramWebhookSecret
+		bot.limiter = clients
+		watcher.RegisterNotifier(telegramChannel, bot.notify)
+
+		if webhookURL := cfg.TelegramWebhookURL; webhookURL != "" {
+			http.HandleFunc("/telegram/webhook", bot.webhookHandler)
+			if err := bot.SetWebhook(context.Background(), webhookURL); err != nil {
+				log.Printf("Telegram: setWebhook failed: %v\n", err)
+			}
+			fmt.Println("Telegram bot receiving updates via webhook:", webhookURL)
+		} else {
+			go bot.RunPolling(stop)
+			fmt.Println("Telegram bot receiving updates via long polling")
+		}
+	}
+
+	if zaloToken != "" {
+		bot := newZaloBot(zaloToken, cfg.ZaloAPIURL, subs)
+		bot.appID = cfg.ZaloAppID
+		bot.secretKey = cfg.ZaloSecretKey
+		bot.limiter = clients
+		watcher.RegisterNotifier(zaloChannel, bot.notify)
+
+		http.HandleFunc("/zalo/webhook", bot.webhookHandler)
+		fmt.Println("Zalo OA webhook listening on /zalo/webhook")
+	}
+
+	go watcher.Run(stop)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestViolationsFingerprint_IgnoresWording(t *testing.T) {
+	primary := []*CsgtData{
+		{Plate: "51K-123.45", ViolationTime: "10:05, 01/02/2024", ViolationAction: "Vượt đèn đỏ", Status: "Chưa xử phạt", ViolationPlace: "Ngã tư Hàng Xanh"},
+		{Plate: "51K-123.45", ViolationTime: "08:00, 03/02/2024", ViolationAction: "Quá tốc độ", Status: "Đã xử phạt"},
+	}
+	csgt := []*CsgtData{
+		{Plate: "51K12345", ViolationTime: "08:00,  03/02/2024", ViolationAction: "Quá tốc độ", Status: "Đã xử phạt", ResolutionLocation: "Đội CSGT số 1"},
+		{Plate: "51K12345", ViolationTime: "10:05, 01/02/2024", ViolationAction: "Vượt  đèn đỏ", Status: "Chưa xử phạt", ViolationPlace: "Hàng Xanh, Bình Thạnh"},
+	}
+	if violationsFingerprint(primary) != violationsFingerprint(csgt) {
+		t.Error("Expected the same violations to fingerprint the same across sources")
+	}
+
+	paid := []*CsgtData{primary[0], {ViolationTime: "08:00, 03/02/2024", ViolationAction: "Quá tốc độ", Status: "Chưa xử phạt"}}
+	if violationsFingerprint(primary) == violationsFingerprint(paid) {
+		t.Error("Expected a status change to change the fingerprint")
+	}
+}
+
+type watcherCtxKey struct{}
+
+func TestPlateWatcher_NotifiesAcrossSources(t *testing.T) {
+	subs, _ := newSubscriptionStore("")
+	subs.Subscribe(telegramChannel, "1", "51K12345", "1", violationsFingerprint(nil))
+
+	var notified [][]*CsgtData
+	watcher := newPlateWatcher(subs, time.Hour)
+	watcher.RegisterNotifier(telegramChannel, func(ctx context.Context, recipient, plate string, data []*CsgtData) error {
+		if ctx.Value(watcherCtxKey{}) == nil {
+			t.Error("Expected notify to get the watcher's context")
+		}
+		notified = append(notified, data)
+		return nil
+	})
+	ctx := context.WithValue(context.Background(), watcherCtxKey{}, true)
+
+	// A clean plate is answered by csgt.vn, the first violation by
+	// checkphatnguoi.vn; which source answers doesn't matter
+	watcher.lookup = stubLookup(nil)
+	watcher.CheckAll(ctx)
+	watcher.lookup = stubLookup([]*CsgtData{{Plate: "51K-123.45", ViolationTime: "10:05, 01/02/2024", ViolationAction: "Vượt đèn đỏ", Status: "Chưa xử phạt"}})
+	watcher.CheckAll(ctx)
+	if len(notified) != 1 {
+		t.Fatalf("Expected the new violation to be notified, got %d notifications", len(notified))
+	}
+
+	// The same violation worded by the other source is not a change
+	watcher.lookup = stubLookup([]*CsgtData{{Plate: "51K12345", ViolationTime: "10:05,  01/02/2024", ViolationAction: "Vượt  đèn đỏ", Status: "Chưa xử phạt", ResolutionLocation: "Đội CSGT số 1"}})
+	watcher.CheckAll(ctx)
+	if len(notified) != 1 {
+		t.Errorf("Expected no notification for the same violation, got %d", len(notified))
+	}
+}
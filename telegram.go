@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Telegram bot front-end
+// ------------------------------------------------------------------------
+
+const (
+	telegramChannel       = "telegram"
+	telegramMaxMessageLen = 4096
+	telegramPollTimeout   = 30 // seconds, long-polling wait on getUpdates
+	telegramMaxInFlight   = 8  // updates handled at once
+)
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	MessageID int64        `json:"message_id"`
+	Chat      telegramChat `json:"chat"`
+	Text      string       `json:"text"`
+}
+
+type telegramChat struct {
+	ID int64 `json:"id"`
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+// telegramBot answers plate queries and manages subscriptions for Telegram
+// chats. apiURL is configurable so tests can point it at a fake Bot API.
+type telegramBot struct {
+	token         string
+	apiURL        string
+	webhookSecret string // required for webhook delivery, see appConfig.validate
+	client        *http.Client
+	inFlight      chan struct{} // one slot per update being handled
+	chatCommands
+}
+
+func newTelegramBot(token, apiURL string, subs *subscriptionStore) *telegramBot {
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	return &telegramBot{
+		token:  token,
+		apiURL: strings.TrimRight(apiURL, "/"),
+		// Outlives the long-polling timeout of getUpdates, see upstreamDefaults
+		client:       upstream.Client(telegramChannel),
+		inFlight:     make(chan struct{}, telegramMaxInFlight),
+		chatCommands: newChatCommands(telegramChannel, subs),
+	}
+}
+
+// call invokes a Bot API method with a JSON body and decodes "result" into out.
+func (b *telegramBot) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s params: %w", method, err)
+	}
+
+	url := fmt.Sprintf("%s/bot%s/%s", b.apiURL, b.token, method)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("telegram %s: connection error: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("telegram %s: failed to read response: %w", method, err)
+	}
+
+	var tr telegramResponse
+	if err := json.Unmarshal(raw, &tr); err != nil {
+		return fmt.Errorf("telegram %s: could not parse JSON (status %d): %w", method, resp.StatusCode, err)
+	}
+	if !tr.OK {
+		return fmt.Errorf("telegram %s failed: %s", method, tr.Description)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(tr.Result, out); err != nil {
+			return fmt.Errorf("telegram %s: could not parse result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+func (b *telegramBot) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	var updates []telegramUpdate
+	err := b.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         telegramPollTimeout,
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// sendMessage sends text to a chat, splitting it to respect Telegram's
+// per-message length limit.
+func (b *telegramBot) sendMessage(ctx context.Context, chatID int64, text string) error {
+	for _, chunk := range splitMessage(text, telegramMaxMessageLen) {
+		err := b.call(ctx, "sendMessage", map[string]interface{}{
+			"chat_id": chatID,
+			"text":    chunk,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWebhook registers url with Telegram for webhook delivery.
+func (b *telegramBot) SetWebhook(ctx context.Context, url string) error {
+	return b.call(ctx, "setWebhook", map[string]interface{}{
+		"url":             url,
+		"allowed_updates": []string{"message"},
+		"secret_token":    b.webhookSecret,
+	}, nil)
+}
+
+// RunPolling long-polls getUpdates until stop is closed. Telegram refuses
+// getUpdates while a webhook is set, so any webhook is removed first. At most
+// telegramMaxInFlight updates are handled at once; polling waits for a free
+// slot, leaving the rest queued at Telegram.
+func (b *telegramBot) RunPolling(stop <-chan struct{}) {
+	// Closing stop aborts the pending getUpdates; lookups still running are
+	// abandoned
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := b.call(ctx, "deleteWebhook", map[string]interface{}{}, nil); err != nil {
+		log.Printf("Telegram: deleteWebhook failed: %v\n", err)
+	}
+
+	var offset int64
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		updates, err := b.getUpdates(ctx, offset)
+		if err != nil {
+			log.Printf("Telegram: getUpdates failed: %v\n", err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			select {
+			case b.inFlight <- struct{}{}:
+			case <-stop:
+				return
+			}
+			offset = u.UpdateID + 1
+			go func() {
+				defer func() { <-b.inFlight }()
+				b.handleUpdate(ctx, u)
+			}()
+		}
+	}
+}
+
+// webhookHandler receives updates pushed by Telegram. It acknowledges right
+// away and handles the update in the background, because a csgt.vn fallback
+// can take longer than Telegram is willing to wait. When telegramMaxInFlight
+// updates are already being handled it answers 503, and Telegram delivers
+// the update again later.
+func (b *telegramBot) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+		return
+	}
+	secret := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if b.webhookSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(b.webhookSecret)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, "Invalid secret token")
+		return
+	}
+
+	var u telegramUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, chatMaxBodyBytes)).Decode(&u); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse update: "+err.Error())
+		return
+	}
+
+	select {
+	case b.inFlight <- struct{}{}:
+	default:
+		writeJSONError(w, http.StatusServiceUnavailable, "Too many updates in progress")
+		return
+	}
+	// The reply outlives the request, so only its deadline-free values carry over
+	go func() {
+		defer func() { <-b.inFlight }()
+		b.handleUpdate(context.WithoutCancel(r.Context()), u)
+	}()
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (b *telegramBot) handleUpdate(ctx context.Context, u telegramUpdate) {
+	if u.Message == nil || strings.TrimSpace(u.Message.Text) == "" {
+		return
+	}
+
+	chatID := u.Message.Chat.ID
+	reply := b.replyTo(ctx, strconv.FormatInt(chatID, 10), u.Message.Text)
+	if err := b.sendMessage(ctx, chatID, reply); err != nil {
+		log.Printf("Telegram: failed to reply to chat %d: %v\n", chatID, err)
+	}
+}
+
+// notify is the watcher callback for the Telegram channel.
+func (b *telegramBot) notify(ctx context.Context, recipient, plate string, data []*CsgtData) error {
+	chatID, err := strconv.ParseInt(recipient, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid telegram chat id %q: %w", recipient, err)
+	}
+	return b.sendMessage(ctx, chatID, formatNotification(plate, data))
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBotAPI is a minimal stand-in for api.telegram.org that serves queued
+// updates and records every sendMessage call.
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	updates []telegramUpdate
+	sent    chan map[string]interface{}
+	calls   []string
+}
+
+func newFakeBotAPI(t *testing.T) (*fakeBotAPI, *httptest.Server) {
+	fake := &fakeBotAPI{sent: make(chan map[string]interface{}, 16)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/bottest-token/") {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		method := strings.TrimPrefix(r.URL.Path, "/bottest-token/")
+
+		var params map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&params)
+
+		fake.mu.Lock()
+		fake.calls = append(fake.calls, method)
+		var result interface{} = true
+		if method == "getUpdates" {
+			result = fake.updates
+			fake.updates = nil
+		}
+		fake.mu.Unlock()
+
+		if method == "sendMessage" {
+			fake.sent <- params
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	return fake, server
+}
+
+func (f *fakeBotAPI) waitMessage(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case msg := <-f.sent:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for sendMessage")
+		return nil
+	}
+}
+
+func stubLookup(data []*CsgtData) func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+	return func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		return data, nil
+	}
+}
+
+func TestTelegramBot_Polling(t *testing.T) {
+	fake, server := newFakeBotAPI(t)
+	defer server.Close()
+
+	fake.updates = []telegramUpdate{{
+		UpdateID: 10,
+		Message:  &telegramMessage{MessageID: 1, Chat: telegramChat{ID: 42}, Text: "51k-123.45"},
+	}}
+
+	subs, _ := newSubscriptionStore("")
+	bot := newTelegramBot("test-token", server.URL, subs)
+	bot.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		if plate != "51K12345" || vehicleCode != "1" {
+			t.Errorf("Expected lookup of 51K12345/1, got %s/%s", plate, vehicleCode)
+		}
+		return []*CsgtData{{Plate: "51K-123.45", ViolationAction: "Vượt đèn đỏ", Status: "Chưa xử phạt"}}, nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go bot.RunPolling(stop)
+
+	msg := fake.waitMessage(t)
+	if msg["chat_id"].(float64) != 42 {
+		t.Errorf("Expected reply to chat 42, got %v", msg["chat_id"])
+	}
+	if text := msg["text"].(string); !strings.Contains(text, "Vượt đèn đỏ") {
+		t.Errorf("Expected violation in reply, got: %s", text)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.calls) == 0 || fake.calls[0] != "deleteWebhook" {
+		t.Errorf("Expected deleteWebhook before polling, got calls %v", fake.calls)
+	}
+}
+
+func TestTelegramBot_WebhookSecret(t *testing.T) {
+	fake, server := newFakeBotAPI(t)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	bot := newTelegramBot("test-token", server.URL, subs)
+	bot.webhookSecret = "s3cret"
+	bot.lookup = stubLookup(nil)
+
+	body := `{"update_id":1,"message":{"message_id":1,"chat":{"id":7},"text":"51K12345"}}`
+
+	// Wrong secret is rejected
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	bot.webhookHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without secret, got %d", rec.Code)
+	}
+
+	// Right secret is accepted and answered
+	req = httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(body))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "s3cret")
+	rec = httptest.NewRecorder()
+	bot.webhookHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	msg := fake.waitMessage(t)
+	if text := msg["text"].(string); !strings.Contains(text, "Không tìm thấy vi phạm") {
+		t.Errorf("Expected 'no violations' reply, got: %s", text)
+	}
+}
+
+func TestTelegramBot_WebhookLimits(t *testing.T) {
+	fake, server := newFakeBotAPI(t)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	release := make(chan struct{})
+	bot := newTelegramBot("test-token", server.URL, subs)
+	bot.inFlight = make(chan struct{}, 1)
+	bot.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		<-release
+		return nil, nil
+	}
+	post := func(secret, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(body))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		rec := httptest.NewRecorder()
+		bot.webhookHandler(rec, req)
+		return rec.Code
+	}
+	body := `{"update_id":1,"message":{"message_id":1,"chat":{"id":7},"text":"51K12345"}}`
+
+	// No secret configured: nothing gets in
+	if code := post("", body); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a configured secret, got %d", code)
+	}
+
+	bot.webhookSecret = "s3cret"
+	if code := post("s3cret", `{"update_id":1,"message":{"text":"`+strings.Repeat("x", chatMaxBodyBytes)+`"}}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an oversized update, got %d", code)
+	}
+
+	// One update in flight fills the bot: the next one is redelivered later
+	if code := post("s3cret", body); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := post("s3cret", body); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while busy, got %d", code)
+	}
+	close(release)
+	fake.waitMessage(t)
+}
+
+func TestTelegramBot_PollingBoundsUpdates(t *testing.T) {
+	fake, server := newFakeBotAPI(t)
+	defer server.Close()
+	for i := int64(1); i <= 5; i++ {
+		fake.updates = append(fake.updates, telegramUpdate{UpdateID: i, Message: &telegramMessage{Chat: telegramChat{ID: i}, Text: "51K12345"}})
+	}
+
+	subs, _ := newSubscriptionStore("")
+	bot := newTelegramBot("test-token", server.URL, subs)
+	bot.inFlight = make(chan struct{}, 2)
+	var mu sync.Mutex
+	running, most := 0, 0
+	release := make(chan struct{})
+	bot.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil, nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go bot.RunPolling(stop)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for range 5 {
+		fake.waitMessage(t)
+	}
+	if most != 2 {
+		t.Errorf("Expected 2 updates handled at once, got %d", most)
+	}
+}
+
+func TestTelegramBot_SubscribeAndNotify(t *testing.T) {
+	fake, server := newFakeBotAPI(t)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	bot := newTelegramBot("test-token", server.URL, subs)
+	bot.lookup = stubLookup(nil)
+
+	reply := bot.replyTo(context.Background(), "99", "/subscribe 98E1-714.78 xemay")
+	if !strings.Contains(reply, "Đã đăng ký") {
+		t.Fatalf("Expected subscribe confirmation, got: %s", reply)
+	}
+	list := subs.List(telegramChannel, "99")
+	if len(list) != 1 || list[0].Plate != "98E171478" || list[0].VehicleCode != "2" {
+		t.Fatalf("Unexpected subscriptions: %+v", list)
+	}
+
+	watcher := newPlateWatcher(subs, time.Hour)
+	watcher.RegisterNotifier(telegramChannel, bot.notify)
+
+	// Nothing changed => no notification
+	watcher.lookup = stubLookup(nil)
+	watcher.CheckAll(context.Background())
+
+	// New violation => exactly one notification
+	watcher.lookup = stubLookup([]*CsgtData{{ViolationAction: "Không đội mũ bảo hiểm"}})
+	watcher.CheckAll(context.Background())
+	watcher.CheckAll(context.Background())
+
+	msg := fake.waitMessage(t)
+	if text := msg["text"].(string); !strings.Contains(text, "Không đội mũ bảo hiểm") {
+		t.Errorf("Expected notification with new violation, got: %s", text)
+	}
+	select {
+	case extra := <-fake.sent:
+		t.Errorf("Expected a single notification, got another: %v", extra)
+	default:
+	}
+
+	if reply := bot.replyTo(context.Background(), "99", "/unsubscribe 98E1-714.78"); !strings.Contains(reply, "Đã huỷ") {
+		t.Errorf("Expected unsubscribe confirmation, got: %s", reply)
+	}
+	if list := subs.List(telegramChannel, "99"); len(list) != 0 {
+		t.Errorf("Expected no subscriptions left, got %+v", list)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	text := strings.Repeat("dòng vi phạm\n", 100)
+	chunks := splitMessage(text, 50)
+	for _, c := range chunks {
+		if len([]rune(c)) > 50 {
+			t.Errorf("Chunk longer than limit: %d", len([]rune(c)))
+		}
+	}
+	if got := strings.Join(chunks, "\n"); strings.TrimSpace(got) != strings.TrimSpace(text) {
+		t.Error("Chunks do not reassemble to the original text")
+	}
+}
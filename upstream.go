@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// Shared HTTP clients for upstream calls
+// ------------------------------------------------------------------------
+
+// upstreamConfig tunes the client of one upstream. Durations of 0 mean no
+// limit, as in http.Transport.
+type upstreamConfig struct {
+	Timeout               time.Duration // whole request, body included
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int // 0 = unlimited
+
+	// Proxy is an http://, https:// or socks5:// URL. Empty uses HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY; "direct" never uses a proxy.
+	Proxy string
+
+	// InsecureSkipVerify disables certificate checks, for upstreams that
+	// serve broken chains. Never on by default.
+	InsecureSkipVerify bool
+}
+
+var defaultUpstreamConfig = upstreamConfig{
+	Timeout:               30 * time.Second,
+	DialTimeout:           10 * time.Second,
+	KeepAlive:             30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 20 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+}
+
+// upstreamSources are the upstreams with their own client, and the infix of
+// their UPSTREAM_<NAME>_* overrides.
+var upstreamSources = map[string]string{
+	sourcePrimary:   "PRIMARY",
+	sourceCSGT:      "CSGT",
+	telegramChannel: "TELEGRAM",
+	zaloChannel:     "ZALO",
+}
+
+// upstreamDefaults are per-source changes to defaultUpstreamConfig.
+var upstreamDefaults = map[string]func(*upstreamConfig){
+	// getUpdates answers only when its long-polling wait is over
+	telegramChannel: func(c *upstreamConfig) {
+		c.Timeout = (telegramPollTimeout + 30) * time.Second
+		c.ResponseHeaderTimeout = c.Timeout
+	},
+}
+
+// upstreamClients hands out one client per upstream. Clients of a source
+// share its Transport, so connections are reused across lookups, and its
+// rate limiter, if it has one.
+type upstreamClients struct {
+	clients  map[string]*http.Client
+	limiters map[string]*upstreamLimiter
+	base     *http.Client // for sources without their own client
+}
+
+// upstream serves every outbound call. main replaces it with the configured
+// clients.
+var upstream = mustUpstreamClients(newUpstreamClients(defaultUpstreamConfig, nil, defaultLimits))
+
+// newUpstreamClients builds the clients of all upstreamSources from base,
+// their upstreamDefaults and overrides. Sources in limits get a rate limiter.
+func newUpstreamClients(base upstreamConfig, overrides map[string]upstreamConfig, limits map[string]limitConfig) (*upstreamClients, error) {
+	baseClient, err := newUpstreamClient(base)
+	if err != nil {
+		return nil, err
+	}
+	u := &upstreamClients{clients: make(map[string]*http.Client), limiters: make(map[string]*upstreamLimiter), base: baseClient}
+	for source := range upstreamSources {
+		cfg, ok := overrides[source]
+		if !ok {
+			cfg = base
+			if fn := upstreamDefaults[source]; fn != nil {
+				fn(&cfg)
+			}
+		}
+		client, err := newUpstreamClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if lc, ok := limits[source]; ok {
+			l := newUpstreamLimiter(source, lc)
+			client.Transport = &limitedTransport{next: client.Transport, limiter: l}
+			u.limiters[source] = l
+		}
+		u.clients[source] = client
+	}
+	return u, nil
+}
+
+func mustUpstreamClients(u *upstreamClients, err error) *upstreamClients {
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// Client returns the shared client of source. It has no cookie jar.
+func (u *upstreamClients) Client(source string) *http.Client {
+	if c, ok := u.clients[source]; ok {
+		return c
+	}
+	return u.base
+}
+
+// Limiter returns the rate limiter of source, or nil if it has none.
+func (u *upstreamClients) Limiter(source string) *upstreamLimiter {
+	return u.limiters[source]
+}
+
+// ClientWithJar is Client with its own cookie jar, for sessions such as the
+// csgt.vn captcha. The connection pool is still shared.
+func (u *upstreamClients) ClientWithJar(source string, jar http.CookieJar) *http.Client {
+	c := *u.Client(source)
+	c.Jar = jar
+	return &c
+}
+
+func newUpstreamClient(cfg upstreamConfig) (*http.Client, error) {
+	proxy, err := upstreamProxy(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+	}
+	if cfg.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// upstreamProxy turns an upstreamConfig.Proxy into a Transport.Proxy.
+func upstreamProxy(raw string) (func(*http.Request) (*url.URL, error), error) {
+	switch raw {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", raw)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return http.ProxyURL(u), nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+}
+
+// upstreamStatusError is a non-200 answer from an upstream. It keeps the
+// status and Retry-After for the retry policy.
+type upstreamStatusError struct {
+	msg        string
+	Status     int
+	RetryAfter time.Duration // 0 when the upstream sent none
+}
+
+func (e *upstreamStatusError) Error() string { return e.msg }
+
+// newUpstreamStatusError describes resp as an ErrUpstreamUnavailable.
+// format gets the status code, e.g. "server returned status code: %d".
+func newUpstreamStatusError(resp *http.Response, format string) error {
+	e := &upstreamStatusError{msg: fmt.Sprintf(format, resp.StatusCode), Status: resp.StatusCode}
+	if v := strings.TrimSpace(resp.Header.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+	return markError(ErrUpstreamUnavailable, e)
+}
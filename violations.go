@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ------------------------------------------------------------------------
+// Typed violations: filtering, sorting and cursor pagination
+// ------------------------------------------------------------------------
+
+// violationTimeLayout is how both sources write CsgtData.ViolationTime,
+// e.g. "14:52, 06/01/2025".
+const violationTimeLayout = "15:04, 02/01/2006"
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+)
+
+// vietnamLocation is the time zone of violation times and printed reports.
+var vietnamLocation = func() *time.Location {
+	loc, err := time.LoadLocation("Asia/Ho_Chi_Minh")
+	if err != nil {
+		return time.FixedZone("ICT", 7*60*60)
+	}
+	return loc
+}()
+
+// typedViolation is a CsgtData with its strings parsed into what filters
+// and sorting need.
+type typedViolation struct {
+	*CsgtData
+	Time     time.Time // zero when ViolationTime doesn't parse
+	Unpaid   bool
+	Province string // last part of the violation place, e.g. "Bắc Giang"
+}
+
+func newTypedViolation(d *CsgtData) typedViolation {
+	v := typedViolation{CsgtData: d, Unpaid: isUnpaid(d), Province: provinceOf(d.ViolationPlace)}
+	if t, err := time.ParseInLocation(violationTimeLayout, strings.TrimSpace(d.ViolationTime), vietnamLocation); err == nil {
+		v.Time = t
+	}
+	return v
+}
+
+// provinceOf returns the province of a violation place, which both sources
+// write last: "Km 12+500, QL1A, Lạng Giang, Tỉnh Bắc Giang" -> "Bắc Giang".
+func provinceOf(place string) string {
+	parts := strings.Split(place, ",")
+	province := strings.TrimSpace(parts[len(parts)-1])
+	for _, prefix := range []string{"Tỉnh ", "Thành phố ", "TP. ", "TP.", "TP "} {
+		if len(province) > len(prefix) && strings.EqualFold(province[:len(prefix)], prefix) {
+			return strings.TrimSpace(province[len(prefix):])
+		}
+	}
+	return province
+}
+
+// foldText lowercases s and strips Vietnamese diacritics, so "Bắc Giang",
+// "bac giang" and "BẮC GIANG" compare equal.
+func foldText(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	folded = strings.NewReplacer("đ", "d", "Đ", "d").Replace(folded)
+	return strings.Join(strings.Fields(strings.ToLower(folded)), " ")
+}
+
+// violationQuery is the filters and sort order taken from the query string:
+//
+//	status=paid|unpaid
+//	from=2025-01-01&to=2025-01-31   violation date, inclusive, Vietnam time
+//	province=Bắc Giang              accents and case are ignored
+//	detected_by=Lục Ngạn            substring of the detecting unit
+//	sort=violation_time|-violation_time
+type violationQuery struct {
+	status     string
+	from, to   time.Time // to is exclusive; zero means open
+	province   string    // folded
+	detectedBy string    // folded
+	sort       string
+}
+
+func parseViolationQuery(q url.Values) (*violationQuery, error) {
+	vq := &violationQuery{
+		province:   foldText(q.Get("province")),
+		detectedBy: foldText(q.Get("detected_by")),
+	}
+
+	switch status := strings.ToLower(strings.TrimSpace(q.Get("status"))); status {
+	case "", "paid", "unpaid":
+		vq.status = status
+	default:
+		return nil, fmt.Errorf("Invalid status: %s (use paid or unpaid)", status)
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+		days int
+	}{{"from", &vq.from, 0}, {"to", &vq.to, 1}} {
+		raw := strings.TrimSpace(q.Get(p.name))
+		if raw == "" {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", raw, vietnamLocation)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s (expected YYYY-MM-DD)", p.name, raw)
+		}
+		*p.dst = day.AddDate(0, 0, p.days)
+	}
+	if !vq.from.IsZero() && !vq.to.IsZero() && !vq.from.Before(vq.to) {
+		return nil, errors.New("Invalid date range: from is after to")
+	}
+
+	switch s := strings.TrimSpace(q.Get("sort")); s {
+	case "", "violation_time", "-violation_time":
+		vq.sort = s
+	default:
+		return nil, fmt.Errorf("Invalid sort: %s (use violation_time or -violation_time)", s)
+	}
+	return vq, nil
+}
+
+// key identifies the filters and sort, so a cursor can't be reused with a
+// different query.
+func (vq *violationQuery) key() string {
+	return strings.Join([]string{vq.status, vq.from.Format(time.DateOnly), vq.to.Format(time.DateOnly), vq.province, vq.detectedBy, vq.sort}, "\x00")
+}
+
+func (vq *violationQuery) match(v typedViolation) bool {
+	switch {
+	case vq.status == "unpaid" && !v.Unpaid, vq.status == "paid" && v.Unpaid:
+		return false
+	case (!vq.from.IsZero() || !vq.to.IsZero()) && v.Time.IsZero():
+		return false
+	case !vq.from.IsZero() && v.Time.Before(vq.from), !vq.to.IsZero() && !v.Time.Before(vq.to):
+		return false
+	case vq.province != "" && foldText(v.Province) != vq.province:
+		return false
+	case vq.detectedBy != "" && !strings.Contains(foldText(v.DetectedBy), vq.detectedBy):
+		return false
+	}
+	return true
+}
+
+// apply filters and sorts data. Violations without a parsable time sort last
+// either way; ties keep the source order.
+func (vq *violationQuery) apply(data []*CsgtData) []*CsgtData {
+	var typed []typedViolation
+	for _, d := range data {
+		if v := newTypedViolation(d); vq.match(v) {
+			typed = append(typed, v)
+		}
+	}
+	if vq.sort != "" {
+		desc := vq.sort == "-violation_time"
+		sort.SliceStable(typed, func(i, j int) bool {
+			a, b := typed[i].Time, typed[j].Time
+			if a.IsZero() || b.IsZero() {
+				return !a.IsZero() && b.IsZero()
+			}
+			if desc {
+				return a.After(b)
+			}
+			return a.Before(b)
+		})
+	}
+
+	out := make([]*CsgtData, len(typed))
+	for i, v := range typed {
+		out[i] = v.CsgtData
+	}
+	return out
+}
+
+// ---- cursor pagination ----
+
+// page is a window of a result list. Cursors are opaque to clients; they
+// hold the size of the next page, a hash of the query they belong to and
+// where the page starts. Lookup results are paged by offset within the
+// history entry the first page recorded (entryID), so later pages neither
+// repeat the lookup nor shift; without a history the lookup is repeated.
+// History entries are paged by the check time and ID of the last one shown,
+// so lookups recorded in between do not shift them either.
+type page struct {
+	limit     int
+	queryHash string
+	offset    int
+	entryID   string
+	afterTime time.Time
+	afterID   string
+}
+
+var errInvalidCursor = errors.New("Invalid cursor")
+
+// parsePage reads ?limit= and ?cursor=. Without either, paginated is false
+// and the whole list is returned, as before pagination existed.
+func parsePage(q url.Values, queryKey string, defaultLimit int) (p page, paginated bool, err error) {
+	sum := sha256.Sum256([]byte(queryKey))
+	p = page{limit: defaultLimit, queryHash: hex.EncodeToString(sum[:4])}
+
+	if raw := q.Get("cursor"); raw != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(raw)
+		parts := strings.Split(string(decoded), ":")
+		if err != nil || len(parts) != 6 {
+			return p, false, errInvalidCursor
+		}
+		limit, err1 := strconv.Atoi(parts[0])
+		offset, err2 := strconv.Atoi(parts[2])
+		after, err3 := strconv.ParseInt(parts[4], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || limit < 1 || limit > maxPageSize || offset < 0 {
+			return p, false, errInvalidCursor
+		}
+		if parts[1] != p.queryHash {
+			return p, false, errors.New("Cursor does not belong to this query")
+		}
+		p.limit, p.offset, p.entryID, p.afterID, paginated = limit, offset, parts[3], parts[5], true
+		if p.afterID != "" {
+			p.afterTime = time.Unix(0, after)
+		}
+	}
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return p, false, fmt.Errorf("Invalid limit: %s (1-%d)", raw, maxPageSize)
+		}
+		p.limit, paginated = limit, true
+	}
+	return p, paginated, nil
+}
+
+func (p page) cursor() string {
+	var after int64
+	if p.afterID != "" {
+		after = p.afterTime.UnixNano()
+	}
+	raw := fmt.Sprintf("%d:%s:%d:%s:%d:%s", p.limit, p.queryHash, p.offset, p.entryID, after, p.afterID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// bounds returns the slice of a list of total items that p covers, and the
+// cursor of the next page ("" on the last one).
+func (p page) bounds(total int) (start, end int, next string) {
+	start = min(p.offset, total)
+	end = start + min(p.limit, total-start)
+	if end < total {
+		p.offset = end
+		next = p.cursor()
+	}
+	return start, end, next
+}
+
+// violationListing is a parsed violation query plus its page, for the
+// lookup routes.
+type violationListing struct {
+	query     *violationQuery
+	page      page
+	paginated bool
+	recorded  *historyEntry // the lookup's history entry, see record
+}
+
+func parseViolationListing(q url.Values) (*violationListing, error) {
+	vq, err := parseViolationQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	p, paginated, err := parsePage(q, vq.key(), defaultPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &violationListing{query: vq, page: p, paginated: paginated}, nil
+}
+
+// record returns a context under which the lookup chain hands the history
+// entry it records to l, for the cursor of the next page.
+func (l *violationListing) record(ctx context.Context) context.Context {
+	return context.WithValue(ctx, recordedEntryKey{}, &l.recorded)
+}
+
+// stored returns the results a later page is cut from: the history entry
+// its cursor points at. ok is false when the page needs a lookup.
+func (l *violationListing) stored(plate, vehicleCode string) (data []*CsgtData, ok bool, err error) {
+	if l.page.entryID == "" {
+		return nil, false, nil
+	}
+	var e *historyEntry
+	if lookupHistory != nil {
+		e = lookupHistory.Entry(plate, l.page.entryID)
+	}
+	if e == nil || e.VehicleType != vehicleTypeName(vehicleCode) {
+		return nil, false, errors.New("Cursor expired or belongs to another plate; repeat the lookup without it")
+	}
+	l.recorded = e
+	return e.Violations, true, nil
+}
+
+// apply filters, sorts and pages data, filling meta's count, total and
+// next cursor.
+func (l *violationListing) apply(data []*CsgtData, meta *lookupMeta) []*CsgtData {
+	data = l.query.apply(data)
+	meta.Total, meta.Count = len(data), len(data)
+	if !l.paginated {
+		return data
+	}
+	if l.recorded != nil {
+		l.page.entryID = l.recorded.ID
+	}
+	start, end, next := l.page.bounds(len(data))
+	meta.Count, meta.NextCursor = end-start, next
+	return data[start:end]
+}
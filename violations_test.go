@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+var querySample = []*CsgtData{
+	{Plate: "29D07834", ViolationTime: "08:10, 15/01/2025", ViolationPlace: "Km 12+500, QL1A, Lạng Giang, Tỉnh Bắc Giang", Status: "Đã xử phạt", DetectedBy: "Đội CSGT Lục Ngạn"},
+	{Plate: "29D07834", ViolationTime: "21:45, 02/03/2025", ViolationPlace: "Ngã tư Sở, Thanh Xuân, TP. Hà Nội", Status: "Chưa xử phạt", DetectedBy: "Đội CSGT số 6 - Phòng CSGT Hà Nội"},
+	{Plate: "29D07834", ViolationTime: "n/a", ViolationPlace: "Hà Nội", Status: "Chưa xử phạt"},
+	{Plate: "29D07834", ViolationTime: "06:00, 20/12/2024", ViolationPlace: "QL3, Thành phố Hà Nội", Status: "Chưa xử phạt", DetectedBy: "Phòng CSGT Hà Nội"},
+}
+
+func TestViolationQuery_FilterAndSort(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []int // indexes into querySample
+	}{
+		{"", []int{0, 1, 2, 3}},
+		{"status=unpaid", []int{1, 2, 3}},
+		{"status=paid", []int{0}},
+		{"province=ha+noi", []int{1, 2, 3}},
+		{"province=BẮC GIANG", []int{0}},
+		{"detected_by=luc+ngan", []int{0}},
+		{"from=2025-01-01", []int{0, 1}},
+		{"to=2025-01-15", []int{0, 3}},
+		{"from=2025-01-16&to=2025-03-02", []int{1}},
+		{"sort=violation_time", []int{3, 0, 1, 2}},
+		{"sort=-violation_time&status=unpaid", []int{1, 3, 2}},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		vq, err := parseViolationQuery(q)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		got := vq.apply(querySample)
+		ok := len(got) == len(tt.want)
+		for i := 0; ok && i < len(got); i++ {
+			ok = got[i] == querySample[tt.want[i]]
+		}
+		if !ok {
+			t.Errorf("%q: got %d violations, want indexes %v", tt.query, len(got), tt.want)
+		}
+	}
+
+	for _, bad := range []string{"status=open", "from=15/01/2025", "from=2025-02-01&to=2025-01-01", "sort=place"} {
+		q, _ := url.ParseQuery(bad)
+		if _, err := parseViolationQuery(q); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestPlates_V1FilterAndCursor(t *testing.T) {
+	mux := newTestPlateAPI(func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		return querySample, nil
+	})
+
+	var seen []string
+	path := "/v1/plates/29D-078.34/violations?status=unpaid&sort=-violation_time&limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("Cursor did not end")
+		}
+		var env testEnvelope
+		if code := doJSON(t, mux, "GET", path, "", &env); code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d", path, code)
+		}
+		var data []*CsgtData
+		_ = json.Unmarshal(env.Data, &data)
+		if env.Meta.Total != 3 || env.Meta.Count != len(data) {
+			t.Errorf("Unexpected meta %+v", env.Meta)
+		}
+		for _, d := range data {
+			seen = append(seen, d.ViolationTime)
+		}
+		path = ""
+		if env.Meta.NextCursor != "" {
+			path = "/v1/plates/29D-078.34/violations?status=unpaid&sort=-violation_time&cursor=" + env.Meta.NextCursor
+		}
+	}
+	if len(seen) != 3 || seen[0] != querySample[1].ViolationTime || seen[2] != "n/a" {
+		t.Errorf("Unexpected pages %v", seen)
+	}
+
+	// A cursor only works with the query it came from
+	q, _ := url.ParseQuery("status=unpaid&limit=1")
+	vq, _ := parseViolationQuery(q)
+	p, _, _ := parsePage(q, vq.key(), defaultPageSize)
+	_, _, next := p.bounds(3)
+	var env testEnvelope
+	if code := doJSON(t, mux, "GET", "/v1/plates/29D07834/violations?status=paid&cursor="+next, "", &env); code != http.StatusBadRequest || env.Error == nil {
+		t.Errorf("Expected 400 for a cursor of another query, got %d", code)
+	}
+	if code := doJSON(t, mux, "GET", "/v1/plates/29D07834/violations?cursor=bm9wZQ", "", &env); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a garbage cursor, got %d", code)
+	}
+}
+
+func TestPlates_CursorServesStoredLookup(t *testing.T) {
+	store, _ := newHistoryStore("", 0)
+	lookupHistory = store
+	t.Cleanup(func() { lookupHistory = nil })
+
+	calls := 0
+	data := querySample
+	mux := newTestPlateAPI(func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		calls++
+		recordLookup(ctx, lookupHistory, plate, vehicleCode, sourcePrimary, data)
+		return data, nil
+	})
+
+	var env testEnvelope
+	if code := doJSON(t, mux, "GET", "/v1/plates/29D07834/violations?status=unpaid&limit=2", "", &env); code != http.StatusOK || env.Meta.NextCursor == "" {
+		t.Fatalf("Expected a first page with a cursor, got %d %+v", code, env.Meta)
+	}
+	next := env.Meta.NextCursor
+
+	// Later pages are cut from the recorded lookup, even if the data changed
+	data = nil
+	env = testEnvelope{}
+	if code := doJSON(t, mux, "GET", "/v1/plates/29D07834/violations?status=unpaid&cursor="+next, "", &env); code != http.StatusOK {
+		t.Fatalf("Expected 200 for the second page, got %d", code)
+	}
+	var page []*CsgtData
+	_ = json.Unmarshal(env.Data, &page)
+	if calls != 1 || len(page) != 1 || env.Meta.Total != 3 || env.Meta.NextCursor != "" {
+		t.Errorf("Expected the last page without a new lookup, got %d lookups, %d results, meta %+v", calls, len(page), env.Meta)
+	}
+
+	if code := doJSON(t, mux, "GET", "/v1/plates/30A12345/violations?status=unpaid&cursor="+next, "", &env); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a cursor of another plate, got %d", code)
+	}
+	lookupHistory, _ = newHistoryStore("", 0)
+	if code := doJSON(t, mux, "GET", "/v1/plates/29D07834/violations?status=unpaid&cursor="+next, "", &env); code != http.StatusBadRequest || calls != 1 {
+		t.Errorf("Expected 400 for a cursor whose lookup is gone, got %d after %d lookups", code, calls)
+	}
+
+	// The manual csgt.vn flow records its result too
+	env = testEnvelope{}
+	if code := doJSON(t, mux, "POST", "/v1/lookups/csgt?limit=1", `{"plate":"29D07834","captcha":"abc"}`, &env); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if env.Meta.NextCursor != "" {
+		t.Errorf("Expected a single page, got %+v", env.Meta)
+	}
+	if got := lookupHistory.List("29D07834", 0); len(got) != 1 || got[0].Source != sourceCSGT {
+		t.Errorf("Expected the manual lookup in the history, got %+v", got)
+	}
+}
+
+func TestPage_Bounds(t *testing.T) {
+	q, _ := url.ParseQuery("limit=2")
+	p, _, _ := parsePage(q, "key", defaultPageSize)
+
+	p.offset = math.MaxInt
+	if start, end, next := p.bounds(3); start != 3 || end != 3 || next != "" {
+		t.Errorf("Expected an empty last page, got %d:%d %q", start, end, next)
+	}
+	p.offset, p.limit = 2, math.MaxInt
+	if start, end, _ := p.bounds(3); start != 2 || end != 3 {
+		t.Errorf("Expected 2:3, got %d:%d", start, end)
+	}
+
+	// Cursors are checked like ?limit=
+	p.offset, p.limit = 0, maxPageSize+1
+	q.Set("cursor", p.cursor())
+	q.Del("limit")
+	if _, _, err := parsePage(q, "key", defaultPageSize); err == nil {
+		t.Error("Expected a cursor above the page size limit to be rejected")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ------------------------------------------------------------------------
+// Zalo Official Account front-end
+// ------------------------------------------------------------------------
+
+const (
+	zaloChannel       = "zalo"
+	zaloMaxMessageLen = 2000 // Zalo rejects text messages longer than this
+	zaloMaxInFlight   = 8    // events handled at once
+)
+
+// zaloEvent is the part of a Zalo OA webhook event we care about.
+type zaloEvent struct {
+	AppID     string `json:"app_id"`
+	EventName string `json:"event_name"`
+	Timestamp string `json:"timestamp"`
+	Sender    struct {
+		ID string `json:"id"`
+	} `json:"sender"`
+	Message struct {
+		MsgID string `json:"msg_id"`
+		Text  string `json:"text"`
+	} `json:"message"`
+}
+
+type zaloResponse struct {
+	Error   int    `json:"error"`
+	Message string `json:"message"`
+}
+
+// zaloBot answers plate queries from OA followers and pushes notifications
+// for subscribed plates. apiURL is configurable so it can be exercised
+// against a local stub.
+type zaloBot struct {
+	accessToken string
+	apiURL      string
+	appID       string
+	secretKey   string // OA secret key, used to verify webhook signatures; required, see appConfig.validate
+	client      *http.Client
+	inFlight    chan struct{} // one slot per event being handled
+	chatCommands
+}
+
+func newZaloBot(accessToken, apiURL string, subs *subscriptionStore) *zaloBot {
+	if apiURL == "" {
+		apiURL = "https://openapi.zalo.me"
+	}
+	return &zaloBot{
+		accessToken:  accessToken,
+		apiURL:       strings.TrimRight(apiURL, "/"),
+		client:       upstream.Client(zaloChannel),
+		inFlight:     make(chan struct{}, zaloMaxInFlight),
+		chatCommands: newChatCommands(zaloChannel, subs),
+	}
+}
+
+// sendMessage sends a customer-service text message to a follower, split to
+// respect Zalo's message size limit.
+func (z *zaloBot) sendMessage(ctx context.Context, userID, text string) error {
+	for _, chunk := range splitMessage(text, zaloMaxMessageLen) {
+		if err := z.sendText(ctx, userID, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *zaloBot) sendText(ctx context.Context, userID, text string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"recipient": map[string]string{"user_id": userID},
+		"message":   map[string]string{"text": text},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode zalo message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", z.apiURL+"/v3.0/oa/message/cs", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("access_token", z.accessToken)
+
+	resp, err := z.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("zalo: connection error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("zalo: failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("zalo: server returned status code: %d", resp.StatusCode)
+	}
+
+	// Zalo answers 200 even on failure; the real status is in "error"
+	var zr zaloResponse
+	if err := json.Unmarshal(raw, &zr); err != nil {
+		return fmt.Errorf("zalo: could not parse JSON: %w", err)
+	}
+	if zr.Error != 0 {
+		return fmt.Errorf("zalo: send failed (error %d): %s", zr.Error, zr.Message)
+	}
+	return nil
+}
+
+// verifySignature checks the X-ZEvent-Signature header, which Zalo computes as
+// "mac=" + sha256(appId + body + timestamp + OA secret key).
+func (z *zaloBot) verifySignature(header string, body []byte, ev *zaloEvent) bool {
+	sum := sha256.Sum256([]byte(z.appID + string(body) + ev.Timestamp + z.secretKey))
+	expected := "mac=" + hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(header), []byte(expected)) == 1
+}
+
+// webhookHandler receives OA events. Like the Telegram webhook it
+// acknowledges immediately and replies in the background, and answers 503
+// when zaloMaxInFlight events are already being handled so Zalo retries
+// later.
+func (z *zaloBot) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, chatMaxBodyBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read event: "+err.Error())
+		return
+	}
+
+	var ev zaloEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse event: "+err.Error())
+		return
+	}
+
+	// Without a key nothing can be verified, so nothing is accepted
+	if z.secretKey == "" || !z.verifySignature(r.Header.Get("X-ZEvent-Signature"), body, &ev) {
+		writeJSONError(w, http.StatusUnauthorized, "Invalid event signature")
+		return
+	}
+
+	// Follows, reactions, images etc. are acknowledged and ignored
+	if ev.EventName == "user_send_text" && strings.TrimSpace(ev.Message.Text) != "" && ev.Sender.ID != "" {
+		select {
+		case z.inFlight <- struct{}{}:
+		default:
+			writeJSONError(w, http.StatusServiceUnavailable, "Too many events in progress")
+			return
+		}
+		// The reply outlives the request, so only its deadline-free values carry over
+		go func() {
+			defer func() { <-z.inFlight }()
+			z.handleText(context.WithoutCancel(r.Context()), ev.Sender.ID, ev.Message.Text)
+		}()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+}
+
+func (z *zaloBot) handleText(ctx context.Context, userID, text string) {
+	reply := z.replyTo(ctx, userID, text)
+	if err := z.sendMessage(ctx, userID, reply); err != nil {
+		log.Printf("Zalo: failed to reply to user %s: %v\n", userID, err)
+	}
+}
+
+// notify is the watcher callback for the Zalo channel.
+func (z *zaloBot) notify(ctx context.Context, recipient, plate string, data []*CsgtData) error {
+	return z.sendMessage(ctx, recipient, formatNotification(plate, data))
+}
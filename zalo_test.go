@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newZaloStub fakes the OA message API and forwards every sent message.
+func newZaloStub(t *testing.T, sent chan map[string]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3.0/oa/message/cs" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if r.Header.Get("access_token") != "oa-token" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": -216, "message": "Access token is invalid"})
+			return
+		}
+
+		var body map[string]map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		sent <- body
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": 0, "message": "Success"})
+	}))
+}
+
+func zaloSignature(appID, body, timestamp, secret string) string {
+	sum := sha256.Sum256([]byte(appID + body + timestamp + secret))
+	return "mac=" + hex.EncodeToString(sum[:])
+}
+
+func TestZaloBot_WebhookReply(t *testing.T) {
+	sent := make(chan map[string]map[string]string, 16)
+	server := newZaloStub(t, sent)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	bot := newZaloBot("oa-token", server.URL, subs)
+	bot.appID = "app-1"
+	bot.secretKey = "oa-secret"
+	bot.lookup = stubLookup([]*CsgtData{{ViolationAction: "Vượt đèn đỏ", Status: "Chưa xử phạt"}})
+
+	body := `{"app_id":"app-1","event_name":"user_send_text","timestamp":"1700000000000","sender":{"id":"u-1"},"message":{"msg_id":"m1","text":"51K-123.45"}}`
+
+	// Bad signature is rejected
+	req := httptest.NewRequest(http.MethodPost, "/zalo/webhook", strings.NewReader(body))
+	req.Header.Set("X-ZEvent-Signature", "mac=deadbeef")
+	rec := httptest.NewRecorder()
+	bot.webhookHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for bad signature, got %d", rec.Code)
+	}
+
+	// An oversized event is refused before it is parsed
+	big := `{"app_id":"app-1","message":{"text":"` + strings.Repeat("x", chatMaxBodyBytes) + `"}}`
+	req = httptest.NewRequest(http.MethodPost, "/zalo/webhook", strings.NewReader(big))
+	rec = httptest.NewRecorder()
+	bot.webhookHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an oversized event, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/zalo/webhook", strings.NewReader(body))
+	req.Header.Set("X-ZEvent-Signature", zaloSignature("app-1", body, "1700000000000", "oa-secret"))
+	rec = httptest.NewRecorder()
+	bot.webhookHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	// Without a secret key every event is refused
+	bot.secretKey = ""
+	req = httptest.NewRequest(http.MethodPost, "/zalo/webhook", strings.NewReader(body))
+	req.Header.Set("X-ZEvent-Signature", zaloSignature("app-1", body, "1700000000000", ""))
+	rec = httptest.NewRecorder()
+	bot.webhookHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a secret key, got %d", rec.Code)
+	}
+
+	select {
+	case msg := <-sent:
+		if msg["recipient"]["user_id"] != "u-1" {
+			t.Errorf("Expected reply to u-1, got %v", msg["recipient"])
+		}
+		if !strings.Contains(msg["message"]["text"], "Vượt đèn đỏ") {
+			t.Errorf("Expected violation in reply, got: %s", msg["message"]["text"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for reply")
+	}
+}
+
+func TestZaloBot_NotifySplitsLongMessages(t *testing.T) {
+	sent := make(chan map[string]map[string]string, 64)
+	server := newZaloStub(t, sent)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	bot := newZaloBot("oa-token", server.URL, subs)
+
+	var data []*CsgtData
+	for i := 0; i < 20; i++ {
+		data = append(data, &CsgtData{
+			ViolationAction:    strings.Repeat("Không chấp hành hiệu lệnh của đèn tín hiệu giao thông. ", 3),
+			ResolutionLocation: "1. Đội Cảnh sát giao thông\nĐịa chỉ: số 384 đường Xương Giang",
+		})
+	}
+
+	if err := bot.notify(context.Background(), "u-2", "51K12345", data); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	close(sent)
+
+	count := 0
+	for msg := range sent {
+		count++
+		if n := len([]rune(msg["message"]["text"])); n > zaloMaxMessageLen {
+			t.Errorf("Message %d has %d characters, limit is %d", count, n, zaloMaxMessageLen)
+		}
+	}
+	if count < 2 {
+		t.Errorf("Expected the notification to be split, got %d message(s)", count)
+	}
+}
+
+func TestZaloBot_SendError(t *testing.T) {
+	sent := make(chan map[string]map[string]string, 1)
+	server := newZaloStub(t, sent)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	bot := newZaloBot("wrong-token", server.URL, subs)
+
+	err := bot.sendMessage(context.Background(), "u-3", "xin chào")
+	if err == nil || !strings.Contains(err.Error(), "-216") {
+		t.Fatalf("Expected Zalo error -216, got: %v", err)
+	}
+}
+
+func TestZaloBot_WebhookBusy(t *testing.T) {
+	sent := make(chan map[string]map[string]string, 16)
+	server := newZaloStub(t, sent)
+	defer server.Close()
+
+	subs, _ := newSubscriptionStore("")
+	bot := newZaloBot("oa-token", server.URL, subs)
+	bot.appID = "app-1"
+	bot.secretKey = "oa-secret"
+	bot.inFlight = make(chan struct{}, 1)
+	release := make(chan struct{})
+	bot.lookup = func(ctx context.Context, plate, vehicleCode string) ([]*CsgtData, error) {
+		<-release
+		return nil, nil
+	}
+
+	body := `{"app_id":"app-1","event_name":"user_send_text","timestamp":"1700000000000","sender":{"id":"u-1"},"message":{"msg_id":"m1","text":"51K12345"}}`
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/zalo/webhook", strings.NewReader(body))
+		req.Header.Set("X-ZEvent-Signature", zaloSignature("app-1", body, "1700000000000", "oa-secret"))
+		rec := httptest.NewRecorder()
+		bot.webhookHandler(rec, req)
+		return rec.Code
+	}
+
+	// One event in flight fills the bot: the next one is delivered again later
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := post(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while busy, got %d", code)
+	}
+	close(release)
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for reply")
+	}
+}